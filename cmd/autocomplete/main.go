@@ -1,39 +1,60 @@
 package main
 
 import (
+	"backend_coding_challenge/cmd/internal/sources"
 	"backend_coding_challenge/models"
 	"flag"
 	"fmt"
 	"log"
-	"os"
 )
 
 func main() {
-	var dataPath string
+	var sourceFlags sources.Flags
+	var indexPath string
 	var limit int
-	flag.StringVar(&dataPath, "data", "data/cities_canada-usa.tsv", "path to CSV source data")
+	sourceFlags.Register("uses more memory")
+	flag.StringVar(&indexPath, "index", "", "path to an index written by buildindex, to search instead of loading -data (optional)")
 	flag.IntVar(&limit, "limit", 10, "maximum number of results to return")
 	flag.Parse()
 
 	query := flag.Arg(0)
 
-	f, err := os.Open(dataPath)
-	if err != nil {
-		log.Fatal(err)
+	var locations models.Index
+	if indexPath != "" {
+		index, err := models.OpenFSTIndex(indexPath)
+		if err != nil {
+			log.Fatalf("%s: %s", indexPath, err)
+		}
+		defer index.Close()
+		locations = index
+	} else {
+		tree, _, err := sourceFlags.Load()
+		if err != nil {
+			log.Fatal(err)
+		}
+		locations = tree
 	}
 
-	cities, err := models.ReadCityData(f)
-	if err != nil {
-		log.Fatal(err)
+	matches := locations.FindTokenMatches(query, limit)
+	if len(matches) < limit {
+		// names that sound like the query, excluding the ones already found
+		for _, match := range locations.FindPhoneticMatches(query, limit) {
+			if !containsMatch(matches, match) && len(matches) < limit {
+				matches = append(matches, match)
+			}
+		}
 	}
 
-	locations := models.NewTrie()
-
-	for _, location := range cities {
-		locations.Insert(location.Name, location)
+	for _, match := range matches {
+		fmt.Printf("%#v\n", match)
 	}
+}
 
-	for _, match := range locations.FindMatches(query, limit) {
-		fmt.Printf("%#v\n", match)
+func containsMatch(matches []models.Match, match models.Match) bool {
+	for _, existing := range matches {
+		if existing.ID == match.ID {
+			return true
+		}
 	}
+	return false
 }
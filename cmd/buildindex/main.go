@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"backend_coding_challenge/cmd/internal/files"
+	"backend_coding_challenge/cmd/internal/sources"
+	"backend_coding_challenge/models"
+)
+
+func main() {
+	var sourceFlags sources.Flags
+	var outputPath string
+	sourceFlags.Register("makes the index bigger")
+	flag.StringVar(&outputPath, "o", "index.fst", "path to write the index to")
+	flag.Parse()
+
+	locations, _, err := sourceFlags.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// a server never maps a half-written index
+	err = files.WriteAtomic(outputPath, func(w io.Writer) error {
+		return models.WriteFSTIndex(w, locations)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %s (%d bytes)", outputPath, info.Size())
+}
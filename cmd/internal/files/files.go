@@ -0,0 +1,34 @@
+// Package files has the file handling shared by the commands.
+package files
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteAtomic writes a file with write, replacing the old one only once the
+// new one is complete, so a reader never sees half of it and a crash can't
+// leave half of it. It's written to a temporary file next to path and renamed
+// over it. Temporary files are only readable by their owner, so it's made
+// readable like any other file.
+func WriteAtomic(path string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,113 @@
+// Package sources has the flags for which location data to load, and the
+// loading, shared by the commands.
+package sources
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"backend_coding_challenge/data"
+	"backend_coding_challenge/models"
+)
+
+// embedded is the copy of the default dataset built into the commands, which
+// is loaded without -data. It's only linked into the commands that load data.
+var embedded = models.Source{Name: data.CitiesCanadaUSAName, Open: func() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(data.CitiesCanadaUSA)), nil
+}}
+
+// Paths collects the values of a flag that can be repeated.
+type Paths []string
+
+func (paths *Paths) String() string {
+	return strings.Join(*paths, ",")
+}
+
+func (paths *Paths) Set(path string) error {
+	*paths = append(*paths, path)
+	return nil
+}
+
+// Flags are the -data, -filters, -synonyms and -phonetic flags.
+type Flags struct {
+	Data     Paths
+	Filters  string
+	Synonyms string
+	Phonetic bool
+}
+
+// Register defines the flags on the command line. phoneticCost says what
+// -phonetic costs for the command, e.g. "uses more memory".
+func (flags *Flags) Register(phoneticCost string) {
+	flag.Var(&flags.Data, "data", "path to CSV source data, repeat to merge several sources in order (default: embedded cities_canada-usa.tsv)")
+	flag.StringVar(&flags.Filters, "filters", "", "path to a JSON config of rules for which rows to load (optional)")
+	flag.StringVar(&flags.Synonyms, "synonyms", "", "path to a synonyms file, added to the built-in synonyms (optional)")
+	flag.BoolVar(&flags.Phonetic, "phonetic", false, "also index names by sound, to find misspelled names ("+phoneticCost+")")
+}
+
+// Load reads the filters and synonyms, and streams the -data sources (or the
+// embedded dataset) into a tree, later sources overriding earlier ones. It logs what was loaded, and
+// returns the filter (nil without -filters) for applying to updates.
+func (flags *Flags) Load() (*models.Trie, *models.Filter, error) {
+	var filter *models.Filter
+	if flags.Filters != "" {
+		f, err := os.Open(flags.Filters)
+		if err != nil {
+			return nil, nil, err
+		}
+		filter, err = models.ReadFilter(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	synonyms := models.DefaultSynonyms()
+	if flags.Synonyms != "" {
+		f, err := os.Open(flags.Synonyms)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = synonyms.Read(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", flags.Synonyms, err)
+		}
+	}
+
+	loaded := []models.Source{}
+	for _, path := range flags.Data {
+		loaded = append(loaded, models.FileSource(path))
+	}
+	if len(loaded) == 0 {
+		loaded = append(loaded, embedded)
+	}
+
+	tree, report, err := models.LoadSources(loaded, models.LoadOptions{
+		Filter:   filter,
+		Synonyms: synonyms,
+		Phonetic: flags.Phonetic,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, source := range report.Sources {
+		log.Printf("Loaded %s: %+v", source.Name, source)
+	}
+	for _, conflict := range report.Conflicts {
+		log.Printf("Merge conflict: %s", conflict)
+	}
+	if filter != nil {
+		for _, rule := range filter.Rules {
+			log.Printf("Filter %q dropped %d rows", rule.Name, rule.Dropped)
+		}
+	}
+
+	return tree, filter, nil
+}
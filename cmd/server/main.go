@@ -1,49 +1,250 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"backend_coding_challenge/cmd/internal/files"
+	"backend_coding_challenge/cmd/internal/sources"
 	"backend_coding_challenge/controllers"
 	"backend_coding_challenge/models"
+	"backend_coding_challenge/public"
 )
 
 func main() {
-	var dataPath string
+	var sourceFlags sources.Flags
+	var indexPath string
+	var verifyIndex bool
+	var profilesPath string
+	var popularityPath string
+	var popularityHalfLife time.Duration
+	var popularityInterval time.Duration
+	var selectionsPath string
+	var weightsPath string
 	var listenAddress string
-	flag.StringVar(&dataPath, "data", "data/cities_canada-usa.tsv", "path to CSV source data")
+	var staticDir string
+	var updatesDir string
+	var updateInterval time.Duration
+	sourceFlags.Register("uses more memory")
+	flag.StringVar(&indexPath, "index", "", "path to an index written by buildindex, to serve instead of loading -data (optional)")
+	flag.BoolVar(&verifyIndex, "verify-index", false, "check the whole -index before serving it, which reads all of it at startup")
+	flag.StringVar(&profilesPath, "profiles", "", "path to a JSON config of scoring profiles, reloaded on SIGHUP (optional)")
+	flag.StringVar(&popularityPath, "popularity", "", "path to save the counts of selected suggestions to, and read them from at startup (optional)")
+	flag.DurationVar(&popularityHalfLife, "popularity-half-life", models.DefaultPopularityHalfLife, "how long until a selection counts for half as much")
+	flag.DurationVar(&popularityInterval, "popularity-interval", time.Minute, "how often to save new selections to the -popularity file")
+	flag.StringVar(&selectionsPath, "selections", "", "path to append a log of selected suggestions to, for train (optional)")
+	flag.StringVar(&weightsPath, "weights", "", "path to a weights file written by train, for the learned profile function (optional)")
 	flag.StringVar(&listenAddress, "addr", ":8000", "TCP host:port to listen for requests on")
+	flag.StringVar(&staticDir, "static", "", "directory of static files to serve (default: embedded public/ assets)")
+	flag.StringVar(&updatesDir, "updates", "", "directory of GeoNames modifications/deletes files to apply (optional)")
+	flag.DurationVar(&updateInterval, "update-interval", time.Hour, "how often to check the updates directory for new files")
 	flag.Parse()
 
-	publicDir := "./public"
+	if indexPath != "" && updatesDir != "" {
+		log.Fatal("An -index can't be updated, rebuild it instead of using -updates")
+	}
 
-	f, err := os.Open(dataPath)
-	if err != nil {
-		log.Fatal(err)
+	var locations models.Index
+	if indexPath != "" {
+		index, err := models.OpenFSTIndex(indexPath)
+		if err != nil {
+			log.Fatalf("%s: %s", indexPath, err)
+		}
+		defer index.Close()
+		if verifyIndex {
+			if err := index.Verify(); err != nil {
+				log.Fatalf("%s: %s", indexPath, err)
+			}
+		}
+		log.Printf("Opened %s: %d locations", indexPath, index.Len())
+		locations = index
+	} else {
+		tree, filter, err := sourceFlags.Load()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if updatesDir != "" {
+			go applyUpdates(models.NewUpdater(tree, filter), updatesDir, updateInterval)
+		}
+		locations = tree
 	}
 
-	// Read location data and insert into tree
-	cities, err := models.ReadCityData(f)
-	if err != nil {
-		log.Fatal(err)
+	static := http.FS(public.Files)
+	if staticDir != "" {
+		static = http.Dir(staticDir)
 	}
 
-	locations := models.NewTrie()
+	suggestions := controllers.NewSuggestionsController(locations)
+	if profilesPath != "" {
+		profiles, err := readProfiles(profilesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		suggestions.SetProfiles(profiles)
+		go reloadProfiles(suggestions, profilesPath)
+	}
 
-	for _, location := range cities {
-		locations.Insert(location.Name, location)
+	popularity := models.NewPopularity(popularityHalfLife)
+	if popularityPath != "" {
+		var err error
+		if popularity, err = readPopularity(popularityPath, popularityHalfLife); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Read %s: %d selected locations", popularityPath, popularity.Len())
+		go savePopularity(popularity, popularityPath, popularityInterval)
 	}
+	suggestions.SetPopularity(popularity)
 
-	suggestions := controllers.NewSuggestionsController(locations)
+	if selectionsPath != "" {
+		f, err := os.OpenFile(selectionsPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		suggestions.SetSelectionsLog(f)
+	}
+
+	if weightsPath != "" {
+		weights, err := readWeights(weightsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Read %s: %s", weightsPath, weights)
+		suggestions.SetWeights(weights)
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/suggestions", suggestions.HandleSuggestions)
-	mux.Handle("/", http.FileServer(http.Dir(publicDir)))
+	mux.HandleFunc("/v2/suggestions", suggestions.HandleSuggestionsV2)
+	mux.HandleFunc("/suggestions/selected", suggestions.HandleSelected)
+	mux.Handle("/", http.FileServer(static))
 
 	log.Printf("Serving on %s...", listenAddress)
 	log.Fatal(
 		http.ListenAndServe(listenAddress, mux),
 	)
 }
+
+// Apply new update files as they appear, until the server exits.
+func applyUpdates(updater *models.Updater, dir string, interval time.Duration) {
+	for {
+		applied, err := updater.ApplyDir(dir)
+		for _, name := range applied {
+			log.Printf("Applied update file %s", name)
+		}
+		if err != nil {
+			log.Printf("Failed to apply updates: %s", err)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// Read a -profiles config.
+func readProfiles(path string) (*models.Profiles, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	profiles, err := models.ReadProfiles(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return profiles, nil
+}
+
+// Reread the -profiles config on every SIGHUP, until the server exits. A config
+// that doesn't read is logged, and the last good one is kept.
+func reloadProfiles(suggestions *controllers.SuggestionsController, path string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		profiles, err := readProfiles(path)
+		if err != nil {
+			log.Printf("Failed to reload profiles: %s", err)
+			continue
+		}
+		suggestions.SetProfiles(profiles)
+		log.Printf("Reloaded %d profiles from %s", len(profiles.Profiles), path)
+	}
+}
+
+// Read a -weights file.
+func readWeights(path string) (*models.Weights, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	weights, err := models.ReadWeights(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return weights, nil
+}
+
+// Read a -popularity file, or start counting from nothing if there isn't one
+// yet.
+func readPopularity(path string, halfLife time.Duration) (*models.Popularity, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return models.NewPopularity(halfLife), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	popularity, err := models.ReadPopularity(f, halfLife)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return popularity, nil
+}
+
+// Save the -popularity file whenever there are new selections, checking every
+// interval, and once more when the server is stopped.
+func savePopularity(popularity *models.Popularity, path string, interval time.Duration) {
+	stops := make(chan os.Signal, 1)
+	signal.Notify(stops, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(interval)
+
+	saved := popularity.Selections()
+	for {
+		select {
+		case <-ticker.C:
+			if selections := popularity.Selections(); selections != saved {
+				if err := writePopularity(popularity, path); err != nil {
+					log.Printf("Failed to save popularity: %s", err)
+					continue
+				}
+				saved = selections
+			}
+		case <-stops:
+			if err := writePopularity(popularity, path); err != nil {
+				log.Fatalf("Failed to save popularity: %s", err)
+			}
+			os.Exit(0)
+		}
+	}
+}
+
+// Write the -popularity file, so a crash can't leave half of it.
+func writePopularity(popularity *models.Popularity, path string) error {
+	return files.WriteAtomic(path, func(w io.Writer) error {
+		return popularity.Write(w, time.Now())
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"backend_coding_challenge/cmd/internal/files"
+	"backend_coding_challenge/cmd/internal/sources"
+	"backend_coding_challenge/controllers"
+	"backend_coding_challenge/models"
+)
+
+func main() {
+	var sourceFlags sources.Flags
+	var selectionsPaths sources.Paths
+	var candidates int
+	var epochs int
+	var rate float64
+	var l2 float64
+	var outputPath string
+	sourceFlags.Register("uses more memory")
+	flag.Var(&selectionsPaths, "selections", "path to a log of selections written by the server, repeat to train on several")
+	flag.IntVar(&candidates, "candidates", 10, "how many of the other suggestions for each selection to compare it to")
+	flag.IntVar(&epochs, "epochs", 2000, "how many steps of gradient descent to take")
+	flag.Float64Var(&rate, "rate", 0.5, "learning rate, how big each step is")
+	flag.Float64Var(&l2, "l2", 0.001, "L2 regularization, how much to keep weights near 0")
+	flag.StringVar(&outputPath, "o", "weights.json", "path to write the weights to")
+	flag.Parse()
+
+	if len(selectionsPaths) == 0 {
+		log.Fatal("Nothing to train on, give at least one -selections log")
+	}
+
+	// The suggestions for each selection are found again the way the server
+	// found them, in the same data it had, or as close to it as there is.
+	locations, _, err := sourceFlags.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	suggestions := controllers.NewSuggestionsController(locations)
+
+	pairs := [][]float64{}
+	for _, path := range selectionsPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		selections, err := models.ReadSelections(f)
+		if err != nil {
+			log.Fatalf("%s: %s", path, err)
+		}
+		f.Close()
+
+		skipped := 0
+		for _, selection := range selections {
+			selected, found := locations.Get(selection.ID)
+			if !found {
+				skipped++
+				continue
+			}
+			// one more, in case the selected location is among them
+			suggested, err := suggestions.Suggested(context.Background(), selection, candidates+1)
+			if err != nil {
+				log.Fatal(err)
+			}
+			pairs = append(pairs, models.SelectionPairs(selection, selected, suggested, candidates)...)
+		}
+		log.Printf("Read %s: %d selections, %d not found in the data", path, len(selections), skipped)
+	}
+	if len(pairs) == 0 {
+		log.Fatal("No selections had anything to compare them to")
+	}
+
+	weights := models.TrainWeights(pairs, epochs, rate, l2)
+	loss, accuracy := weights.Evaluate(pairs)
+	log.Printf("Trained on %d pairs: loss %.4f, %.1f%% of pairs ranked right", len(pairs), loss, 100*accuracy)
+	log.Printf("Weights: %s", weights)
+
+	// a server never reads half-written weights
+	if err := files.WriteAtomic(outputPath, weights.Write); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %s", outputPath)
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend_coding_challenge/data"
+	"backend_coding_challenge/models"
+)
+
+// Number of random queries checked against every index
+const differentialQueries = 300
+
+// TestIndexes_Differential sends the same random queries to controllers backed
+// by each Index implementation, and checks that they respond with exactly the
+// same results as the LinearIndex, which is the reference. Ranking breaks every
+// tie, so even the order of equal scores has to match. The reference never lets
+// the controller stop walking early, or look up an area instead of walking, so
+// those are checked too.
+func TestIndexes_Differential(t *testing.T) {
+	reference := models.NewLinearIndex()
+	reference.EnablePhonetic()
+	indexes := map[string]models.Index{}
+
+	trie := models.NewTrie()
+	trie.EnablePhonetic()
+	indexes["trie"] = trie
+
+	locations := []models.Location{}
+	err := models.ScanCityData(bytes.NewReader(data.CitiesCanadaUSA), func(location models.Location) error {
+		reference.Insert(location.Name, location)
+		trie.Insert(location.Name, location)
+		locations = append(locations, location)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "index.fst")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.WriteFSTIndex(f, trie); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	fst, err := models.OpenFSTIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fst.Close()
+	indexes["fst"] = fst
+
+	expected := NewSuggestionsController(unbounded{reference})
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < differentialQueries; i++ {
+		query := randomQuery(random, locations)
+		want := suggest(t, expected, query)
+
+		for name, index := range indexes {
+			if got := suggest(t, NewSuggestionsController(index), query); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s: %#v != %#v", name, query, got, want)
+			}
+		}
+	}
+}
+
+// unbounded is an Index that never tells the controller how long later names
+// are, so it never stops walking early, and never finds an area small enough
+// to check each location in.
+type unbounded struct {
+	models.Index
+}
+
+func (index unbounded) FindInArea(prefix string, area models.Area, limit int) ([]models.Match, bool) {
+	return nil, false
+}
+
+func (index unbounded) Walk(ctx context.Context, prefix string, visit models.Visitor) error {
+	return index.Index.Walk(ctx, prefix, func(match models.Match, minLength int) bool {
+		return visit(match, 0)
+	})
+}
+
+// randomQuery makes a query from the name of a random location: a prefix of the
+// name or one of its later words, sometimes with a typo, a country,
+// coordinates, a radius, a bounding box or a viewport.
+func randomQuery(random *rand.Rand, locations []models.Location) string {
+	location := locations[random.Intn(len(locations))]
+
+	text := location.Name
+	if words := strings.Fields(text); len(words) > 1 && random.Intn(4) == 0 {
+		text = strings.Join(words[random.Intn(len(words)):], " ")
+	}
+	runes := []rune(text)
+	runes = runes[:1+random.Intn(len(runes))]
+	if len(runes) > 2 && random.Intn(5) == 0 {
+		i := random.Intn(len(runes) - 1)
+		runes[i], runes[i+1] = runes[i+1], runes[i]
+	}
+	text = string(runes)
+
+	if random.Intn(10) == 0 {
+		text = strings.ToUpper(text)
+	}
+	if random.Intn(5) == 0 {
+		text += ", " + location.Country
+	}
+
+	params := url.Values{"q": {text}, "limit": {fmt.Sprint([]int{0, 1, 5, 10}[random.Intn(4)])}}
+	if random.Intn(2) == 0 {
+		params.Set("latitude", fmt.Sprint(25+random.Float64()*35))
+		params.Set("longitude", fmt.Sprint(-130+random.Float64()*70))
+		if random.Intn(3) == 0 {
+			params.Set("radius_km", fmt.Sprint(10+random.Float64()*2000))
+		}
+	}
+	if random.Intn(4) == 0 {
+		// from the size of a city to most of the continent
+		width, height := 0.1+random.Float64()*60, 0.1+random.Float64()*30
+		long, lat := location.Long-random.Float64()*width, location.Lat-random.Float64()*height
+		params.Set("bbox", fmt.Sprintf("%g,%g,%g,%g", long, lat, long+width, lat+height))
+	}
+	if random.Intn(4) == 0 {
+		width := 0.1 + random.Float64()*60
+		long, lat := -130+random.Float64()*70, 25+random.Float64()*35
+		params.Set("viewport", fmt.Sprintf("%g,%g,%g,%g", long, lat, long+width, lat+width/2))
+		if random.Intn(2) == 0 {
+			params.Set("zoom", fmt.Sprint(random.Intn(19)))
+		}
+	}
+	if random.Intn(4) == 0 {
+		params.Set("diversify", fmt.Sprint(random.Float64()))
+	}
+	return params.Encode()
+}
+
+func suggest(t *testing.T, suggestions *SuggestionsController, query string) []models.Result {
+	req := httptest.NewRequest("GET", "http://example.com/suggestions?"+query, nil)
+	res := httptest.NewRecorder()
+	suggestions.HandleSuggestions(res, req)
+
+	results := []models.Result{}
+	if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
@@ -1,11 +1,19 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mholt/binding"
 
@@ -13,71 +21,462 @@ import (
 )
 
 type SuggestionsController struct {
-	locations *models.Trie
+	locations  models.Index
+	profiles   atomic.Pointer[models.Profiles]
+	popularity *models.Popularity
+	weights    *models.Weights
+
+	selectionsMu  sync.Mutex
+	selectionsLog io.Writer
+}
+
+func NewSuggestionsController(locations models.Index) *SuggestionsController {
+	c := &SuggestionsController{
+		locations:  locations,
+		popularity: models.NewPopularity(models.DefaultPopularityHalfLife),
+	}
+	c.SetProfiles(models.DefaultProfiles())
+	return c
+}
+
+// SetPopularity replaces the counts of selections that are recorded and scored
+// by, e.g. with ones read from disk. It has to be called before handling any
+// requests.
+func (c *SuggestionsController) SetPopularity(popularity *models.Popularity) {
+	c.popularity = popularity
 }
 
-func NewSuggestionsController(locations *models.Trie) *SuggestionsController {
-	return &SuggestionsController{locations: locations}
+// SetProfiles replaces the scoring profiles that requests can choose from.
+// It's safe to call while requests are being handled, which keep the profiles
+// they started with.
+func (c *SuggestionsController) SetProfiles(profiles *models.Profiles) {
+	c.profiles.Store(profiles)
 }
 
+// A SuggestionsResponse is the body of a v2 response. Unlike v1, which is just
+// the list of suggestions, it has room for more than the results.
+type SuggestionsResponse struct {
+	Suggestions []models.Result `json:"suggestions"`
+	DidYouMean  []string        `json:"did_you_mean,omitempty"` // only when there are no suggestions
+}
+
+// Offer at most this many corrections in did_you_mean
+const maxCorrections = 3
+
+// HandleSuggestions responds with the list of suggestions (v1).
 func (c *SuggestionsController) HandleSuggestions(res http.ResponseWriter, req *http.Request) {
-	// Parse input from query string
-	form := &SuggestionForm{Limit: 10}
+	form, ok := c.bind(res, req)
+	if !ok {
+		return
+	}
+
+	results, err := c.suggest(req.Context(), form)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(res, results)
+}
+
+// HandleSuggestionsV2 responds with a SuggestionsResponse, which offers
+// corrected queries when nothing matches.
+func (c *SuggestionsController) HandleSuggestionsV2(res http.ResponseWriter, req *http.Request) {
+	form, ok := c.bind(res, req)
+	if !ok {
+		return
+	}
+
+	results, err := c.suggest(req.Context(), form)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := SuggestionsResponse{Suggestions: results}
+	if len(response.Suggestions) == 0 {
+		response.DidYouMean = c.didYouMean(form)
+	}
+
+	writeJSON(res, response)
+}
+
+// SetWeights sets the learned weights that the "learned" profile function
+// scores by. It has to be called before handling any requests.
+func (c *SuggestionsController) SetWeights(weights *models.Weights) {
+	c.weights = weights
+}
+
+// SetSelectionsLog makes the controller log every selection to w, one JSON
+// object per line, to learn weights from. It has to be called before handling
+// any requests.
+func (c *SuggestionsController) SetSelectionsLog(w io.Writer) {
+	c.selectionsLog = w
+}
+
+// HandleSelected records that a user selected a location from the suggestions
+// for a query, which profiles can rank popular locations higher by.
+func (c *SuggestionsController) HandleSelected(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		http.Error(res, "selections have to be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	form := &SelectionForm{}
 	if err := binding.Bind(req, form); err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if _, found := c.locations.Get(form.ID); !found {
+		http.Error(res, fmt.Sprintf("unknown location %q", form.ID), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("SuggestionsController: selected %#v", form)
+	now := time.Now()
+	c.popularity.Select(form.Query, form.ID, now)
+	if c.selectionsLog != nil {
+		c.logSelection(models.Selection{Time: now, Query: form.Query, ID: form.ID, Lat: form.Lat, Long: form.Long})
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// logSelection appends a selection to the selections log. Failing to is only
+// logged, since the selection has still been counted.
+func (c *SuggestionsController) logSelection(selection models.Selection) {
+	line, err := json.Marshal(selection)
+	if err != nil {
+		log.Printf("Failed to log selection: %s", err)
+		return
+	}
+
+	c.selectionsMu.Lock()
+	defer c.selectionsMu.Unlock()
+	if _, err := c.selectionsLog.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to log selection: %s", err)
+	}
+}
+
+// bind parses the query string into a form and looks up its scoring profile.
+// If either fails, it responds with the error and returns false.
+func (c *SuggestionsController) bind(res http.ResponseWriter, req *http.Request) (*SuggestionForm, bool) {
+	form := &SuggestionForm{Limit: 10}
+	if err := binding.Bind(req, form); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	profile, found := c.profiles.Load().Get(form.Profile)
+	if !found {
+		http.Error(res, fmt.Sprintf("unknown profile %q", form.Profile), http.StatusBadRequest)
+		return nil, false
+	}
+	form.profile = profile
+	form.popularity = c.popularity
+	form.weights = c.weights
+
+	// Without a preference, the profile can take one from the language
+	if len(form.preferred) == 0 && profile.InferCountry {
+		res.Header().Add("Vary", "Accept-Language")
+		if country := acceptLanguageCountry(req.Header.Get("Accept-Language")); country != "" {
+			form.preferred = []string{country}
+		}
+	}
 
+	return form, true
+}
+
+// suggest finds, scores and sorts the results for a query. It gives up if ctx
+// is done, e.g. because the client has gone away.
+func (c *SuggestionsController) suggest(ctx context.Context, form *SuggestionForm) ([]models.Result, error) {
 	log.Printf("SuggestionsController: %#v", form)
 
-	// Initialize the algorithm used to score results
-	var scorer models.Scorer
-	var matches []models.Location
-	var matchLimit int
+	ranked, err := c.rankMatches(ctx, form)
+	if err != nil {
+		return nil, err
+	}
 
+	// Construct result objects from the locations, best first. Ranking breaks
+	// ties, so the order never changes.
+	results := models.NewResults(form.diversify(ranked))
 	if form.Lat != nil && form.Long != nil {
-		// Use geo distance for scoring when latitude and longitude are passed
-		scorer = models.NewGeoDistanceScorer(*form.Lat, *form.Long)
+		for i := range results {
+			results[i].AddDistance(*form.Lat, *form.Long, form.Units)
+		}
+	}
+	return results, nil
+}
 
-		// Don't limit results before scoring, or close results may be excluded
-		matchLimit = 0
-	} else {
-		// Fall back to scoring by length relative to the prefix otherwise
-		scorer = models.NewRelativeLengthScorer(form.Query)
-		matchLimit = form.Limit
+// Suggested finds the suggestions that a selection was picked from, or as
+// close to them as the controller can: the best <limit> for its query and
+// coordinates by the default profile, found as a request for them would be.
+func (c *SuggestionsController) Suggested(ctx context.Context, selection models.Selection, limit int) ([]models.ScoredLocation, error) {
+	profile, _ := c.profiles.Load().Get("")
+	form := &SuggestionForm{
+		Query:      selection.Query,
+		Lat:        selection.Lat,
+		Long:       selection.Long,
+		Limit:      limit,
+		profile:    profile,
+		popularity: c.popularity,
+		weights:    c.weights,
 	}
+	return c.rankMatches(ctx, form)
+}
 
-	matches = c.locations.FindMatches(form.Query, matchLimit)
+// rankMatches finds the matches for a query, and returns the best <candidates>
+// of them, best first.
+func (c *SuggestionsController) rankMatches(ctx context.Context, form *SuggestionForm) ([]models.ScoredLocation, error) {
+	// Split off any region or country at the end of the query
+	query := models.ParseQuery(form.Query)
+	typedQualifiers := len(query.Qualifiers) > 0
+	query = form.restrict(query)
+
+	// Only the best <limit> results are kept as they're scored, or a few times
+	// as many to pick diverse results from
+	ranked := ranker{top: models.NewTopK(form.candidates()), explain: form.Explain}
+
+	// Nicknames like "NYC" stand for a whole query, so what that finds is what
+	// the user meant, however long its names are. They're ranked first, and
+	// the query itself doesn't rank them again.
+	var aliasMatches []models.Match
+	if alias, found := c.locations.Synonyms().Alias(form.Query); found {
+		aliasQuery := form.restrict(models.ParseQuery(alias))
+		aliasMatches = allowed(c.locations.FindTokenMatches(aliasQuery.Text, 0), aliasQuery)
+		ranked.rank(aliasMatches, newAliasScorer(form, aliasQuery.Text))
+		ranked = ranked.excluding(aliasMatches)
+	}
+
+	// Match the start of any word in the name. Matches on later words are
+	// weighted down, so results can't simply be limited before scoring, but
+	// the search can stop once nothing it finds later could score highly
+	// enough.
+	scorer := newScorer(form, query.Text)
+	matches, err := c.findMatches(ctx, query, scorer, ranked)
+	if err == nil && len(matches) == 0 && typedQualifiers {
+		// The "qualifier" may have been part of the name after all
+		query = form.restrict(models.Query{Text: form.Query})
+		scorer = newScorer(form, query.Text)
+		matches, err = c.findMatches(ctx, query, scorer, ranked)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if query.Partial && len(matches) > 0 {
+		// The "qualifier" may be the start of a word still being typed ("New
+		// Or"), so add what the whole query finds
+		whole := form.restrict(models.Query{Text: form.Query})
+		wholeMatches := excludeMatches(allowed(c.locations.FindTokenMatches(whole.Text, 0), whole), matches)
+		ranked.rank(wholeMatches, newScorer(form, whole.Text))
+		matches = append(matches, wholeMatches...)
+	}
 	log.Printf("%d matches found for prefix query", len(matches))
+	matches = append(matches, aliasMatches...)
 
-	// Construct result objects from the locations and apply scores
-	results := []models.Result{}
-	for _, location := range matches {
-		score := scorer.Score(location)
-		results = append(results, models.NewResult(location, score))
+	// If the spelling didn't find enough, add names that sound like the query.
+	// They're weighted down a lot, so they mostly rank below everything else.
+	if ranked.top.Len() == 0 || ranked.top.Len() < form.Limit {
+		phoneticMatches := excludeMatches(allowed(c.locations.FindPhoneticMatches(query.Text, 0), query), matches)
+		ranked.rank(phoneticMatches, newPhoneticScorer(form, query.Text))
 	}
 
-	// Sort by score descending (should already be sorted by this point)
-	sort.Sort(sort.Reverse(models.ResultsByScore(results)))
+	return ranked.top.Sorted(), nil
+}
+
+// didYouMean finds the names that a query with no results was probably a
+// misspelling of, keeping any qualifiers as they were typed.
+func (c *SuggestionsController) didYouMean(form *SuggestionForm) []string {
+	raw := form.Query
+	query := form.restrict(models.ParseQuery(raw))
 
-	// Trim array of results to <limit>
-	if form.Limit > 0 && len(results) > form.Limit {
-		results = results[:form.Limit]
+	suffix := ""
+	if trimmed := strings.TrimSpace(raw); strings.HasPrefix(trimmed, query.Text) {
+		suffix = trimmed[len(query.Text):]
 	}
 
-	// Write out the results
-	if err := json.NewEncoder(res).Encode(results); err != nil {
+	corrections := []string{}
+	for _, name := range c.locations.Corrections(query.Text, maxCorrections, query.Allow) {
+		corrections = append(corrections, name+suffix)
+	}
+	return corrections
+}
+
+// writeJSON writes out a response body.
+func writeJSON(res http.ResponseWriter, body interface{}) {
+	if err := json.NewEncoder(res).Encode(body); err != nil {
 		res.WriteHeader(500)
 		fmt.Fprint(res, `{"error": "failed to marshal response as JSON"}`)
 		return
 	}
 }
 
+// Diverse results are picked from this many times the limit of the best
+// matches.
+const diversifyCandidates = 3
+
+// Areas with up to this many locations around them are searched by checking
+// each of those locations, rather than every match for the prefix
+const maxAreaLocations = 5000
+
+// findMatches finds the locations matching the query text, qualifiers and area,
+// and ranks them. If the scorer can bound the scores of longer names, it stops
+// as soon as the top is full of matches that score higher than any later one
+// can, since the later ones can't make it in.
+func (c *SuggestionsController) findMatches(ctx context.Context, query models.Query, scorer models.Scorer, ranked ranker) ([]models.Match, error) {
+	// A small area has far fewer locations than a short prefix has matches
+	if query.Area != nil {
+		if matches, found := c.locations.FindInArea(query.Text, query.Area, maxAreaLocations); found {
+			matches = allowed(matches, query)
+			ranked.rank(matches, scorer)
+			return matches, nil
+		}
+	}
+
+	bounded, _ := scorer.(models.BoundedScorer)
+
+	matches := []models.Match{}
+	minLength := math.MinInt
+	err := c.locations.Walk(ctx, query.Text, func(match models.Match, length int) bool {
+		// names only get longer, so only check when they do. Equal scores
+		// don't count, since ties are broken by other things.
+		if bounded != nil && length > minLength {
+			minLength = length
+			if ranked.top.Full() && ranked.top.Min().Score > bounded.MaxScore(minLength) {
+				return false
+			}
+		}
+
+		if query.Allow(match.Location) {
+			matches = append(matches, match)
+			ranked.push(match, scorer)
+		}
+		return true
+	})
+	return matches, err
+}
+
+// allowed filters matches by the query's qualifiers.
+func allowed(matches []models.Match, query models.Query) []models.Match {
+	filtered := []models.Match{}
+	for _, match := range matches {
+		if query.Allow(match.Location) {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
+}
+
+// newScorer initializes the algorithm used to score results for the query text,
+// from the form's profile. The default profile uses the viewport when one is
+// passed, geo distance when latitude and longitude are, and length relative to
+// the prefix otherwise.
+func newScorer(form *SuggestionForm, text string) models.Scorer {
+	return form.profile.Scorer(text, form.scoringContext())
+}
+
+// newPhoneticScorer is like newScorer, for names that only sound like the
+// query text, so they're scored by how close they sound instead of by length.
+func newPhoneticScorer(form *SuggestionForm, text string) models.Scorer {
+	request := form.scoringContext()
+	request.Phonetic = true
+	return form.profile.Scorer(text, request)
+}
+
+// newAliasScorer is like newScorer, for what an alias stands for, so every
+// match gets the text score of an exact one.
+func newAliasScorer(form *SuggestionForm, text string) models.Scorer {
+	request := form.scoringContext()
+	request.Alias = true
+	return form.profile.Scorer(text, request)
+}
+
+// A ranker scores matches and keeps the best of them.
+type ranker struct {
+	top     *models.TopK
+	explain bool            // whether to explain each score
+	skip    map[string]bool // IDs of locations that are already ranked
+}
+
+// rank scores matches and adds them to the top.
+func (ranked ranker) rank(matches []models.Match, scorer models.Scorer) {
+	for _, match := range matches {
+		ranked.push(match, scorer)
+	}
+}
+
+// push scores a match and adds it to the top.
+func (ranked ranker) push(match models.Match, scorer models.Scorer) {
+	if ranked.skip[match.ID] {
+		return
+	}
+	scored := models.ScoredLocation{Location: match.Location, Score: scorer.Score(match.Location) * match.Weight()}
+	if ranked.explain {
+		explanation := models.ExplainMatch(scorer, match)
+		scored.Explanation = &explanation
+	}
+	ranked.top.Push(scored)
+}
+
+// excluding returns a ranker that skips matches, which it already ranked.
+func (ranked ranker) excluding(matches []models.Match) ranker {
+	skip := make(map[string]bool)
+	for id := range ranked.skip {
+		skip[id] = true
+	}
+	for _, match := range matches {
+		skip[match.ID] = true
+	}
+	ranked.skip = skip
+	return ranked
+}
+
+// excludeMatches removes the locations in exclude from matches.
+func excludeMatches(matches, exclude []models.Match) []models.Match {
+	ids := make(map[string]bool)
+	for _, match := range exclude {
+		ids[match.ID] = true
+	}
+
+	filtered := []models.Match{}
+	for _, match := range matches {
+		if !ids[match.ID] {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
+}
+
 type SuggestionForm struct {
-	Query string   // Prefix to query locations
-	Lat   *float64 // Longitude for sorting results by distance (optional)
-	Long  *float64 // Latitude for sorting results by distance (optional)
-	Limit int      // Limit to this many results in response (default 10)
+	Query    string   // Prefix to query locations
+	Lat      *float64 // Longitude for sorting results by distance (optional)
+	Long     *float64 // Latitude for sorting results by distance (optional)
+	Limit    int      // Limit to this many results in response (default 10)
+	Explain  bool     // Include a breakdown of each score in the results (optional)
+	Profile  string   // Name of the scoring profile (default "default")
+	Units    string   // Units of the distance to each result, "km" (default) or "mi"
+	BBox     string   // Only results in minLon,minLat,maxLon,maxLat (optional)
+	RadiusKm *float64 // Only results within this distance of latitude/longitude (optional)
+	Viewport string   // Rank results in minLon,minLat,maxLon,maxLat first (optional)
+	Zoom     *float64 // Map zoom level of the viewport (default: fits its width)
+
+	// How much to trade score for variety, from 0 (the default) to 1, so that
+	// fewer results have the same name
+	Diversify *float64
+
+	// Only results in any of these countries or regions, and rank those in
+	// PreferCountries first. Each can be repeated or comma-separated (optional)
+	Countries       []string
+	Regions         []string
+	PreferCountries []string
+
+	profile    *models.Profile    // looked up from Profile
+	area       models.Area        // parsed from BBox and RadiusKm
+	viewport   *models.Viewport   // parsed from Viewport and Zoom
+	qualifiers []models.Qualifier // parsed from Countries and Regions
+	preferred  []string           // country codes from PreferCountries or Accept-Language
+	popularity *models.Popularity // the controller's
+	weights    *models.Weights    // the controller's
 }
 
 // for auto-binding and validation with mholt/binding
@@ -88,8 +487,248 @@ func (form *SuggestionForm) FieldMap(req *http.Request) binding.FieldMap {
 			Required:     true,
 			ErrorMessage: "query parameter 'q' is required",
 		},
-		&form.Lat:   "latitude",
-		&form.Long:  "longitude",
-		&form.Limit: "limit",
+		&form.Lat:             "latitude",
+		&form.Long:            "longitude",
+		&form.Limit:           "limit",
+		&form.Explain:         "explain",
+		&form.Profile:         "profile",
+		&form.Units:           "units",
+		&form.BBox:            "bbox",
+		&form.RadiusKm:        "radius_km",
+		&form.Viewport:        "viewport",
+		&form.Zoom:            "zoom",
+		&form.Diversify:       "diversify",
+		&form.Countries:       "country",
+		&form.Regions:         "region",
+		&form.PreferCountries: "prefer_country",
+	}
+}
+
+// candidates returns how many of the best matches to keep: the limit, or more
+// to pick diverse results from.
+func (form *SuggestionForm) candidates() int {
+	if form.Diversify == nil || *form.Diversify == 0 {
+		return form.Limit
+	}
+	return form.Limit * diversifyCandidates
+}
+
+// diversify picks the results from the ranked candidates, if asked to. Only
+// which results make the limit changes: they're still returned best first, as
+// clients expect. Without a limit, every result is returned anyway.
+func (form *SuggestionForm) diversify(ranked []models.ScoredLocation) []models.ScoredLocation {
+	if form.Diversify == nil || *form.Diversify == 0 || form.Limit <= 0 {
+		return ranked
+	}
+
+	picked := models.Diversify(ranked, form.Limit, *form.Diversify)
+	sort.Sort(models.ByRank(picked))
+	return picked
+}
+
+// scoringContext is what the form says about the user for scoring.
+func (form *SuggestionForm) scoringContext() models.ScoringContext {
+	return models.ScoringContext{
+		Lat:             form.Lat,
+		Long:            form.Long,
+		Viewport:        form.viewport,
+		PreferCountries: form.preferred,
+		Popularity:      form.popularity,
+		Weights:         form.weights,
+	}
+}
+
+// restrict adds the form's area and country and region filters to a query.
+func (form *SuggestionForm) restrict(query models.Query) models.Query {
+	query.Area = form.area
+	query.Qualifiers = append(append([]models.Qualifier{}, query.Qualifiers...), form.qualifiers...)
+	return query
+}
+
+// Validate checks the coordinates, units, diversify, area, countries, regions
+// and viewport, and fills in the defaults.
+func (form *SuggestionForm) Validate(req *http.Request) error {
+	if err := checkCoordinates(form.Lat, form.Long); err != nil {
+		return err
+	}
+
+	units, err := models.ParseUnits(form.Units)
+	if err != nil {
+		return err
+	}
+	form.Units = units
+
+	if form.Diversify != nil && !(*form.Diversify >= 0 && *form.Diversify <= 1) {
+		return fmt.Errorf("diversify has to be between 0 and 1")
+	}
+
+	if form.area, err = form.parseArea(); err != nil {
+		return err
+	}
+	if err := form.parseCountries(); err != nil {
+		return err
+	}
+
+	if form.Viewport != "" {
+		box, err := models.ParseBoundingBox(form.Viewport)
+		if err != nil {
+			return err
+		}
+		form.viewport, err = models.NewViewport(box, form.Zoom)
+		return err
+	}
+	if form.Zoom != nil {
+		return fmt.Errorf("zoom needs a viewport")
+	}
+	return nil
+}
+
+// parseCountries turns the country and region filters into qualifiers, and
+// prefer_country into country codes.
+func (form *SuggestionForm) parseCountries() error {
+	if countries := splitValues(form.Countries); len(countries) > 0 {
+		qualifier, err := models.CountryQualifier(countries)
+		if err != nil {
+			return err
+		}
+		form.qualifiers = append(form.qualifiers, qualifier)
+	}
+	if regions := splitValues(form.Regions); len(regions) > 0 {
+		qualifier, err := models.RegionQualifier(regions)
+		if err != nil {
+			return err
+		}
+		form.qualifiers = append(form.qualifiers, qualifier)
+	}
+
+	if preferred := splitValues(form.PreferCountries); len(preferred) > 0 {
+		qualifier, err := models.CountryQualifier(preferred)
+		if err != nil {
+			return err
+		}
+		for country := range qualifier.Countries {
+			form.preferred = append(form.preferred, country)
+		}
+		sort.Strings(form.preferred)
+	}
+	return nil
+}
+
+// splitValues splits the comma-separated values of a repeatable parameter.
+func splitValues(params []string) []string {
+	values := []string{}
+	for _, param := range params {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// parseArea combines bbox and radius_km into the area that results have to be
+// in, or nil if there's neither.
+func (form *SuggestionForm) parseArea() (models.Area, error) {
+	areas := models.Areas{}
+	if form.BBox != "" {
+		box, err := models.ParseBoundingBox(form.BBox)
+		if err != nil {
+			return nil, err
+		}
+		areas = append(areas, box)
+	}
+	if form.RadiusKm != nil {
+		if form.Lat == nil || form.Long == nil {
+			return nil, fmt.Errorf("radius_km needs latitude and longitude")
+		}
+		if *form.RadiusKm <= 0 {
+			return nil, fmt.Errorf("radius_km has to be positive")
+		}
+		areas = append(areas, models.Circle{Lat: *form.Lat, Long: *form.Long, RadiusKm: *form.RadiusKm})
+	}
+
+	switch len(areas) {
+	case 0:
+		return nil, nil
+	case 1:
+		return areas[0], nil
+	}
+	return areas, nil
+}
+
+// checkCoordinates checks that a latitude and longitude, where they're given,
+// are somewhere on Earth.
+func checkCoordinates(lat, long *float64) error {
+	if lat != nil && !(math.Abs(*lat) <= 90) {
+		return fmt.Errorf("latitude has to be between -90 and 90")
+	}
+	if long != nil && !(math.Abs(*long) <= 180) {
+		return fmt.Errorf("longitude has to be between -180 and 180")
+	}
+	return nil
+}
+
+// acceptLanguageCountry finds the country of the most preferred language in an
+// Accept-Language header that has one, e.g. "CA" for "fr-CA,fr;q=0.9,en;q=0.8".
+// Regions that aren't countries, like "es-419", don't count.
+func acceptLanguageCountry(header string) string {
+	country, best := "", 0.0
+	for _, entry := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= best {
+			continue
+		}
+
+		// the region is the first two letter subtag after the language, which
+		// can come after a script ("zh-Hant-TW")
+		for _, subtag := range strings.Split(tag, "-")[1:] {
+			if models.IsCountryCode(subtag) {
+				country, best = strings.ToUpper(subtag), quality
+				break
+			}
+		}
+	}
+	return country
+}
+
+// A SelectionForm is a location that a user selected from the suggestions for
+// a query. It can be form encoded or JSON.
+type SelectionForm struct {
+	Query string   `json:"q"`         // What the user had typed
+	ID    string   `json:"id"`        // GeoNames id of the location they selected
+	Lat   *float64 `json:"latitude"`  // Latitude the suggestions were for (optional)
+	Long  *float64 `json:"longitude"` // Longitude the suggestions were for (optional)
+}
+
+// for auto-binding and validation with mholt/binding
+func (form *SelectionForm) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&form.Query: binding.Field{
+			Form:         "q",
+			Required:     true,
+			ErrorMessage: "parameter 'q' is required",
+		},
+		&form.ID: binding.Field{
+			Form:         "id",
+			Required:     true,
+			ErrorMessage: "parameter 'id' is required",
+		},
+		&form.Lat:  "latitude",
+		&form.Long: "longitude",
 	}
 }
+
+// Validate checks the coordinates, which are kept in the selections log.
+func (form *SelectionForm) Validate(req *http.Request) error {
+	return checkCoordinates(form.Lat, form.Long)
+}
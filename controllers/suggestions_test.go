@@ -1,17 +1,35 @@
 package controllers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"reflect"
 	"testing"
+	"time"
 
+	"backend_coding_challenge/data"
 	"backend_coding_challenge/models"
 )
 
 // alias NewResult for quick shorthand
 var result func(models.Location, float64) models.Result = models.NewResult
 
+// resultFrom is a result with the distance from a caller at lat, long.
+func resultFrom(location models.Location, score, lat, long float64, units string) models.Result {
+	r := result(location, score)
+	r.AddDistance(lat, long, units)
+	return r
+}
+
 func TestSuggestionsController_HandleSuggestions(t *testing.T) {
 	// sample locations
 	victoria := models.Location{ID: "6174041", Name: "Victoria", DisplayName: "Victoria, 02, CA", Lat: 48.43294143676758, Long: -123.36930084228516, Country: "CA"}
@@ -58,19 +76,65 @@ func TestSuggestionsController_HandleSuggestions(t *testing.T) {
 			200,
 			[]models.Result{},
 		},
+		"qualified query": {
+			"q=Vi,+US",
+			200,
+			[]models.Result{
+				result(vista, models.InverseLengthScore(3)),
+			},
+		},
+		"qualified query without results falls back to whole query": {
+			"q=Vi,+Nope",
+			200,
+			[]models.Result{},
+		},
 		"successful query with lat/long": {
 			"q=Vi&latitude=48.43&longitude=-123.33",
 			200,
 			[]models.Result{
-				result(victoria, models.DistanceScore(48.43, -123.33, victoria.Lat, victoria.Long)),
-				result(vista, models.DistanceScore(48.43, -123.33, vista.Lat, vista.Long)),
+				resultFrom(victoria, models.DistanceScore(48.43, -123.33, victoria.Lat, victoria.Long), 48.43, -123.33, "km"),
+				resultFrom(vista, models.DistanceScore(48.43, -123.33, vista.Lat, vista.Long), 48.43, -123.33, "km"),
 			},
 		},
 		"query with lat/long does not limit before scoring/sorting": {
 			"q=Vi&latitude=48.43&longitude=-123.33&limit=1",
 			200,
 			[]models.Result{
-				result(victoria, models.DistanceScore(48.43, -123.33, victoria.Lat, victoria.Long)),
+				resultFrom(victoria, models.DistanceScore(48.43, -123.33, victoria.Lat, victoria.Long), 48.43, -123.33, "km"),
+			},
+		},
+		"distance in miles": {
+			"q=Vi&latitude=48.43&longitude=-123.33&limit=1&units=mi",
+			200,
+			[]models.Result{
+				resultFrom(victoria, models.DistanceScore(48.43, -123.33, victoria.Lat, victoria.Long), 48.43, -123.33, "mi"),
+			},
+		},
+		"bad units": {
+			"q=Vi&latitude=48.43&longitude=-123.33&units=furlongs",
+			400,
+			nil,
+		},
+		"latitude off the globe": {
+			"q=Vi&latitude=91&longitude=-123.33",
+			400,
+			nil,
+		},
+		"longitude off the globe": {
+			"q=Vi&latitude=48.43&longitude=-180.5",
+			400,
+			nil,
+		},
+		"latitude not a number": {
+			"q=Vi&latitude=NaN&longitude=-123.33",
+			400,
+			nil,
+		},
+		"edge of the globe": {
+			"q=Vista&latitude=-90&longitude=180",
+			200,
+			[]models.Result{
+				resultFrom(vista, models.DistanceScore(-90, 180, vista.Lat, vista.Long), -90, 180, "km"),
 			},
 		},
 	}
@@ -101,3 +165,960 @@ func TestSuggestionsController_HandleSuggestions(t *testing.T) {
 		})
 	}
 }
+
+func TestSuggestionsController_HandleSuggestionsLaterWords(t *testing.T) {
+	newYork := models.Location{ID: "5128581", Name: "New York", DisplayName: "New York, NY, US", Country: "US"}
+	yorktown := models.Location{ID: "4791259", Name: "Yorktown", DisplayName: "Yorktown, VA, US", Country: "US"}
+
+	locations := models.NewTrie()
+	locations.Insert(newYork.Name, newYork)
+	locations.Insert(yorktown.Name, yorktown)
+
+	suggestions := NewSuggestionsController(locations)
+
+	req := httptest.NewRequest("GET", "http://example.com/suggestions?q=york", nil)
+	res := httptest.NewRecorder()
+	suggestions.HandleSuggestions(res, req)
+
+	results := []models.Result{}
+	if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+
+	// both names are 4 characters longer than the query, but "Yorktown"
+	// matches from the start of its name
+	expected := []models.Result{
+		result(yorktown, models.InverseLengthScore(4)),
+		result(newYork, models.InverseLengthScore(4)*models.LaterTokenWeight),
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v != %#v", results, expected)
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsAlias(t *testing.T) {
+	newYork := models.Location{ID: "5128581", Name: "New York City", DisplayName: "New York City, NY, US", Country: "US", Region: "NY"}
+	nyack := models.Location{ID: "5129245", Name: "Nyack", DisplayName: "Nyack, NY, US", Country: "US", Region: "NY"}
+
+	locations := models.NewTrie()
+	locations.Insert(newYork.Name, newYork)
+	locations.Insert(nyack.Name, nyack)
+
+	suggestions := NewSuggestionsController(locations)
+
+	req := httptest.NewRequest("GET", "http://example.com/suggestions?q=NYC", nil)
+	res := httptest.NewRecorder()
+	suggestions.HandleSuggestions(res, req)
+
+	results := []models.Result{}
+	if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+
+	// what "NYC" stands for scores as an exact match
+	expected := []models.Result{
+		result(newYork, 1),
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("%#v != %#v", results, expected)
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsPhonetic(t *testing.T) {
+	cheyenne := models.Location{ID: "5821086", Name: "Cheyenne", DisplayName: "Cheyenne, WY, US", Country: "US", Region: "WY"}
+	shelby := models.Location{ID: "4407050", Name: "Shelby", DisplayName: "Shelby, NC, US", Country: "US", Region: "NC"}
+
+	locations := models.NewTrie()
+	locations.EnablePhonetic()
+	locations.Insert(cheyenne.Name, cheyenne)
+	locations.Insert(shelby.Name, shelby)
+
+	suggestions := NewSuggestionsController(locations)
+
+	tests := map[string]struct {
+		query   string
+		results []models.Result
+	}{
+		"misspelled query finds names that sound the same": {
+			"q=Shyenne",
+			[]models.Result{
+				// the same sounds, two edits away
+				result(cheyenne, models.InverseLengthScore(2)*models.PhoneticWeight),
+			},
+		},
+		"phonetic matches are ranked below spelled matches": {
+			"q=She",
+			[]models.Result{
+				result(shelby, models.InverseLengthScore(3)),
+				// one more sound, one edit away
+				result(cheyenne, models.InverseLengthScore(2)*models.PhoneticWeight),
+			},
+		},
+		"phonetic matches are only added when there aren't enough": {
+			"q=She&limit=1",
+			[]models.Result{
+				result(shelby, models.InverseLengthScore(3)),
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results, tt.results) {
+				t.Errorf("%#v != %#v", results, tt.results)
+			}
+		})
+	}
+}
+
+// loadCities indexes the whole embedded dataset.
+func loadCities(t *testing.T, phonetic bool) *models.Trie {
+	trie := models.NewTrie()
+	if phonetic {
+		trie.EnablePhonetic()
+	}
+	err := models.ScanCityData(bytes.NewReader(data.CitiesCanadaUSA), func(location models.Location) error {
+		trie.Insert(location.Name, location)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trie
+}
+
+func TestSuggestionsController_HandleSuggestionsDataset(t *testing.T) {
+	suggestions := NewSuggestionsController(loadCities(t, true))
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := map[string]struct {
+		query string
+		first string
+	}{
+		"a misspelling finds the name it sounds like before others with the same sounds": {
+			"q=Shyenne",
+			"Cheyenne, WY, US",
+		},
+		"an alias finds what it stands for before names it's the start of": {
+			"q=NYC",
+			"New York City, NY, US",
+		},
+		"an alias finds what it stands for before names it's a later word of": {
+			"q=vegas",
+			"Las Vegas, NV, US",
+		},
+		"a synonym longer than the name doesn't score over 1": {
+			"q=saint+catharines",
+			"St. Catharines, Ontario, CA",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) == 0 || results[0].Name != tt.first {
+				t.Errorf("%#v doesn't start with %#v", results, tt.first)
+			}
+			for _, result := range results {
+				if result.Score > 1 {
+					t.Errorf("%#v scores over 1", result)
+				}
+			}
+		})
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsV2(t *testing.T) {
+	toronto := models.Location{ID: "6167865", Name: "Toronto", DisplayName: "Toronto, ON, CA", Country: "CA", Region: "08", Population: 2600000}
+	torrington := models.Location{ID: "4843811", Name: "Torrington", DisplayName: "Torrington, CT, US", Country: "US", Region: "CT", Population: 36000}
+
+	locations := models.NewTrie()
+	locations.Insert(toronto.Name, toronto)
+	locations.Insert(torrington.Name, torrington)
+
+	suggestions := NewSuggestionsController(locations)
+
+	tests := map[string]struct {
+		query    string
+		response SuggestionsResponse
+	}{
+		"results": {
+			"q=Toro",
+			SuggestionsResponse{Suggestions: []models.Result{result(toronto, models.InverseLengthScore(3))}},
+		},
+		"no results": {
+			"q=Torotno",
+			SuggestionsResponse{Suggestions: []models.Result{}, DidYouMean: []string{"Toronto"}},
+		},
+		"no results with qualifier": {
+			"q=Torotno,+ON",
+			SuggestionsResponse{Suggestions: []models.Result{}, DidYouMean: []string{"Toronto, ON"}},
+		},
+		"no corrections": {
+			"q=Xyzzy",
+			SuggestionsResponse{Suggestions: []models.Result{}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/v2/suggestions?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestionsV2(res, req)
+
+			response := SuggestionsResponse{}
+			if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(response, tt.response) {
+				t.Errorf("%#v != %#v", response, tt.response)
+			}
+		})
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsOrder(t *testing.T) {
+	// all the same length, so they tie on score
+	vandaliaIL := models.Location{ID: "4252174", Name: "Vandalia", DisplayName: "Vandalia, IL, US", Country: "US", Region: "IL", Population: 7042}
+	vandaliaOH := models.Location{ID: "4524474", Name: "Vandalia", DisplayName: "Vandalia, OH, US", Country: "US", Region: "OH", Population: 15246}
+	vandaliaMO := models.Location{ID: "4413795", Name: "Vandalia", DisplayName: "Vandalia, MO, US", Country: "US", Region: "MO", Population: 7042}
+	vandaliaMO2 := models.Location{ID: "4413794", Name: "Vandalia", DisplayName: "Vandalia, MO, US", Country: "US", Region: "MO", Population: 7042}
+
+	// population first, then display name, then ID
+	expected := []models.Result{
+		result(vandaliaOH, models.InverseLengthScore(4)),
+		result(vandaliaIL, models.InverseLengthScore(4)),
+		result(vandaliaMO2, models.InverseLengthScore(4)),
+		result(vandaliaMO, models.InverseLengthScore(4)),
+	}
+
+	orders := [][]models.Location{
+		{vandaliaIL, vandaliaOH, vandaliaMO, vandaliaMO2},
+		{vandaliaMO2, vandaliaMO, vandaliaOH, vandaliaIL},
+		{vandaliaMO, vandaliaIL, vandaliaMO2, vandaliaOH},
+	}
+	for i, order := range orders {
+		trie, linear := models.NewTrie(), models.NewLinearIndex()
+		for _, location := range order {
+			trie.Insert(location.Name, location)
+			linear.Insert(location.Name, location)
+		}
+
+		for name, index := range map[string]models.Index{"trie": trie, "linear": linear} {
+			suggestions := NewSuggestionsController(index)
+
+			for run := 0; run < 10; run++ {
+				for limit := 1; limit <= len(expected); limit++ {
+					req := httptest.NewRequest("GET", fmt.Sprintf("http://example.com/suggestions?q=Vand&limit=%d", limit), nil)
+					res := httptest.NewRecorder()
+					suggestions.HandleSuggestions(res, req)
+
+					results := []models.Result{}
+					if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(results, expected[:limit]) {
+						t.Errorf("%s, order %d, run %d: %#v != %#v", name, i, run, results, expected[:limit])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsStopsEarly(t *testing.T) {
+	// "St. Ab" is found after "Saxxxxx", since its key ("saint ab") is longer,
+	// but it's the shorter name, so the walk can't stop before reaching it
+	stAb := models.Location{ID: "1", Name: "St. Ab", DisplayName: "St. Ab"}
+	saxxxxx := models.Location{ID: "2", Name: "Saxxxxx", DisplayName: "Saxxxxx"}
+	longer := models.Location{ID: "3", Name: "Saxxxxxxxxxxxxxxxx", DisplayName: "Saxxxxxxxxxxxxxxxx"}
+
+	trie, linear := models.NewTrie(), models.NewLinearIndex()
+	for _, location := range []models.Location{stAb, saxxxxx, longer} {
+		trie.Insert(location.Name, location)
+		linear.Insert(location.Name, location)
+	}
+
+	for name, index := range map[string]models.Index{"trie": trie, "linear": linear} {
+		t.Run(name, func(t *testing.T) {
+			var visited []string
+			spy := visitSpy{index, &visited}
+			suggestions := NewSuggestionsController(spy)
+
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?q=sa&limit=1", nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			if expected := []models.Result{result(stAb, models.InverseLengthScore(4))}; !reflect.DeepEqual(results, expected) {
+				t.Errorf("%#v != %#v", results, expected)
+			}
+			// the longest name can't beat "St. Ab", so the walk stops there
+			if expected := []string{"Saxxxxx", "St. Ab"}; !reflect.DeepEqual(visited, expected) {
+				t.Errorf("%#v != %#v", visited, expected)
+			}
+		})
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsCanceled(t *testing.T) {
+	trie := models.NewTrie()
+	trie.Insert("Toronto", models.Location{ID: "1", Name: "Toronto"})
+	suggestions := NewSuggestionsController(trie)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "http://example.com/suggestions?q=tor", nil).WithContext(ctx)
+	res := httptest.NewRecorder()
+	suggestions.HandleSuggestions(res, req)
+
+	if res.Code != http.StatusServiceUnavailable {
+		t.Errorf("%#v != %#v", res.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// visitSpy records the names of the matches that a walk visits, unless the
+// visitor stops at them.
+type visitSpy struct {
+	models.Index
+	visited *[]string
+}
+
+func (spy visitSpy) Walk(ctx context.Context, prefix string, visit models.Visitor) error {
+	return spy.Index.Walk(ctx, prefix, func(match models.Match, minLength int) bool {
+		if !visit(match, minLength) {
+			return false
+		}
+		*spy.visited = append(*spy.visited, match.Name)
+		return true
+	})
+}
+
+// Short prefixes with coordinates are the most expensive queries: every match
+// is scored, since distance can't be bounded by the length of the name.
+func BenchmarkSuggestionsController_Suggest(b *testing.B) {
+	trie := models.NewTrie()
+	err := models.ScanCityData(bytes.NewReader(data.CitiesCanadaUSA), func(location models.Location) error {
+		trie.Insert(location.Name, location)
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	suggestions := NewSuggestionsController(trie)
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	lat, long := 43.70011, -79.4163
+	for _, query := range []string{"s", "sa", "new", "saint"} {
+		b.Run(query, func(b *testing.B) {
+			form := &SuggestionForm{Query: query, Lat: &lat, Long: &long, Limit: 10, Units: models.Kilometers, profile: models.DefaultProfile()}
+			for i := 0; i < b.N; i++ {
+				if _, err := suggestions.suggest(context.Background(), form); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsExplain(t *testing.T) {
+	newYork := models.Location{ID: "1", Name: "New York", DisplayName: "New York, NY, US"}
+	trie := models.NewTrie()
+	trie.Insert(newYork.Name, newYork)
+	suggestions := NewSuggestionsController(trie)
+
+	explanation := &models.Explanation{
+		Value:       models.InverseLengthScore(4) * models.LaterTokenWeight,
+		Description: "product of:",
+		Details: []models.Explanation{
+			{Value: models.InverseLengthScore(4), Description: "2^-4, for a name 4 characters longer than the query"},
+			{Value: models.LaterTokenWeight, Description: "matched word 2 of the name, not the first"},
+		},
+	}
+	explained := result(newYork, explanation.Value)
+	explained.Explanation = explanation
+
+	tests := map[string]struct {
+		query    string
+		expected []models.Result
+	}{
+		"explain": {
+			"q=york&explain=true",
+			[]models.Result{explained},
+		},
+		"don't explain": {
+			"q=york",
+			[]models.Result{result(newYork, explanation.Value)},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results, tt.expected) {
+				t.Errorf("%#v != %#v", results, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsProfile(t *testing.T) {
+	sale := models.Location{ID: "1", Name: "Sale", DisplayName: "Sale, MA, US", Population: 100}
+	saintLouis := models.Location{ID: "2", Name: "Saint Louis", DisplayName: "Saint Louis, MO, US", Population: 999999}
+	trie := models.NewTrie()
+	trie.Insert(sale.Name, sale)
+	trie.Insert(saintLouis.Name, saintLouis)
+	suggestions := NewSuggestionsController(trie)
+
+	population := &models.PopulationScorer{}
+	tests := map[string]struct {
+		query    string
+		expected []models.Result
+	}{
+		"default": {
+			"q=sa",
+			[]models.Result{result(sale, models.InverseLengthScore(2)), result(saintLouis, models.InverseLengthScore(9))},
+		},
+		"by name": {
+			"q=sa&profile=prominence",
+			[]models.Result{result(saintLouis, population.Score(saintLouis)), result(sale, population.Score(sale))},
+		},
+	}
+
+	profiles, err := models.ReadProfiles(bytes.NewBufferString(`{"profiles": {
+		"prominence": {"functions": [{"type": "population"}]}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	suggestions.SetProfiles(profiles)
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results, tt.expected) {
+				t.Errorf("%#v != %#v", results, tt.expected)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/suggestions?q=sa&profile=nearby", nil)
+		res := httptest.NewRecorder()
+		suggestions.HandleSuggestions(res, req)
+
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("%#v != %#v", res.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("reloaded", func(t *testing.T) {
+		suggestions.SetProfiles(models.DefaultProfiles())
+
+		req := httptest.NewRequest("GET", "http://example.com/suggestions?q=sa&profile=prominence", nil)
+		res := httptest.NewRecorder()
+		suggestions.HandleSuggestions(res, req)
+
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("%#v != %#v", res.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestSuggestionsController_HandleSuggestionsArea(t *testing.T) {
+	// the shortest names are outside the areas, so they'd take up the limit
+	// if the area was applied after it
+	sale := models.Location{ID: "1", Name: "Sale", DisplayName: "Sale, VIC, AU", Lat: -38.1, Long: 147.07}
+	salem := models.Location{ID: "2", Name: "Salem", DisplayName: "Salem, OR, US", Lat: 44.94, Long: -123.04}
+	sandy := models.Location{ID: "3", Name: "Sandy", DisplayName: "Sandy, OR, US", Lat: 45.4, Long: -122.26}
+	saanich := models.Location{ID: "4", Name: "Saanich", DisplayName: "Saanich, BC, CA", Lat: 48.48, Long: -123.38}
+	savusavu := models.Location{ID: "5", Name: "Savusavu", DisplayName: "Savusavu, FJ", Lat: -16.78, Long: 179.33}
+	somosomo := models.Location{ID: "6", Name: "Somosomo", DisplayName: "Somosomo, FJ", Lat: -16.77, Long: -179.97}
+	trie := models.NewTrie()
+	for _, location := range []*models.Location{&sale, &salem, &sandy, &saanich, &savusavu, &somosomo} {
+		// coordinates are stored as float32
+		location.Lat, location.Long = float64(float32(location.Lat)), float64(float32(location.Long))
+		trie.Insert(location.Name, *location)
+	}
+	suggestions := NewSuggestionsController(trie)
+
+	tests := map[string]struct {
+		query    string
+		status   int
+		expected []models.Result
+	}{
+		"bounding box": {
+			"q=sa&bbox=-124,48,-123,49&limit=1",
+			200,
+			[]models.Result{result(saanich, models.InverseLengthScore(5))},
+		},
+		"across the antimeridian": {
+			"q=s&bbox=179,-17,-179,-16",
+			200,
+			[]models.Result{result(savusavu, models.InverseLengthScore(7)), result(somosomo, models.InverseLengthScore(7))},
+		},
+		"radius": {
+			"q=sa&latitude=45.5&longitude=-122.7&radius_km=100&limit=1",
+			200,
+			[]models.Result{resultFrom(sandy, models.DistanceScore(45.5, -122.7, sandy.Lat, sandy.Long), 45.5, -122.7, "km")},
+		},
+		"radius and bounding box": {
+			"q=sa&latitude=45.5&longitude=-122.7&radius_km=100&bbox=-124,44,-122.5,45",
+			200,
+			[]models.Result{resultFrom(salem, models.DistanceScore(45.5, -122.7, salem.Lat, salem.Long), 45.5, -122.7, "km")},
+		},
+		"bad bounding box": {
+			"q=sa&bbox=-124,48,-123",
+			400,
+			nil,
+		},
+		"radius without coordinates": {
+			"q=sa&radius_km=100",
+			400,
+			nil,
+		},
+		"negative radius": {
+			"q=sa&latitude=45.5&longitude=-122.7&radius_km=-1",
+			400,
+			nil,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			if res.Code != tt.status {
+				t.Fatalf("%#v != %#v", res.Code, tt.status)
+			}
+			if res.Code != 200 {
+				return
+			}
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results, tt.expected) {
+				t.Errorf("%#v != %#v", results, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsViewport(t *testing.T) {
+	// Oakville is just out of a viewport of Toronto, and Ottawa far out of it
+	oakville := models.Location{ID: "2", Name: "Oakville", DisplayName: "Oakville, ON, CA", Lat: 43.45, Long: -79.68, Population: 10000}
+	ottawa := models.Location{ID: "3", Name: "Ottawa", DisplayName: "Ottawa, ON, CA", Lat: 45.41, Long: -75.7, Population: 812129}
+	trie := models.NewTrie()
+	for _, location := range []models.Location{oakville, ottawa} {
+		trie.Insert(location.Name, location)
+	}
+	suggestions := NewSuggestionsController(trie)
+
+	tests := map[string]struct {
+		query    string
+		status   int
+		expected []string
+	}{
+		"without a viewport": {"q=o", 200, []string{"Ottawa, ON, CA", "Oakville, ON, CA"}},
+		"zoomed in":          {"q=o&viewport=-79.7,43.5,-79,43.9&zoom=12", 200, []string{"Oakville, ON, CA", "Ottawa, ON, CA"}},
+		"zoomed out":         {"q=o&viewport=-79.7,43.5,-79,43.9&zoom=1", 200, []string{"Ottawa, ON, CA", "Oakville, ON, CA"}},
+		"bad viewport":       {"q=o&viewport=-79.7,43.5", 400, nil},
+		"zoom without one":   {"q=o&zoom=12", 400, nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			if res.Code != tt.status {
+				t.Fatalf("%#v != %#v", res.Code, tt.status)
+			}
+			if res.Code != 200 {
+				return
+			}
+			if vary := res.Header().Get("Vary") == "Accept-Language"; vary != (name == "language inferred") {
+				t.Errorf("unexpected Vary: %q", res.Header().Get("Vary"))
+			}
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, result := range results {
+				names = append(names, result.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("%#v != %#v", names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsCountry(t *testing.T) {
+	londonON := models.Location{ID: "1", Name: "London", DisplayName: "London, ON, CA", Country: "CA", Region: "08", Population: 346765}
+	londonKY := models.Location{ID: "2", Name: "London", DisplayName: "London, KY, US", Country: "US", Region: "KY", Population: 7993}
+	londonOH := models.Location{ID: "3", Name: "London", DisplayName: "London, OH, US", Country: "US", Region: "OH", Population: 9904}
+	trie := models.NewTrie()
+	for _, location := range []models.Location{londonON, londonKY, londonOH} {
+		trie.Insert(location.Name, location)
+	}
+	suggestions := NewSuggestionsController(trie)
+
+	profiles, err := models.ReadProfiles(bytes.NewBufferString(`{"profiles": {
+		"local": {"functions": [{"type": "text"}], "infer_country": true}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	suggestions.SetProfiles(profiles)
+
+	tests := map[string]struct {
+		query    string
+		language string
+		status   int
+		expected []string
+	}{
+		"no filter":               {"q=london", "", 200, []string{"London, ON, CA", "London, OH, US", "London, KY, US"}},
+		"country":                 {"q=london&country=US", "", 200, []string{"London, OH, US", "London, KY, US"}},
+		"countries":               {"q=london&country=CA&country=us", "", 200, []string{"London, ON, CA", "London, OH, US", "London, KY, US"}},
+		"regions":                 {"q=london&region=KY,ON", "", 200, []string{"London, ON, CA", "London, KY, US"}},
+		"country and region":      {"q=london&country=US&region=US-KY,CA-ON", "", 200, []string{"London, KY, US"}},
+		"with a typed one too":    {"q=london,+ohio&country=US", "", 200, []string{"London, OH, US"}},
+		"unknown country":         {"q=london&country=Narnia", "", 400, nil},
+		"unknown region":          {"q=london&region=CA-XX", "", 400, nil},
+		"preferred":               {"q=london&prefer_country=US", "", 200, []string{"London, OH, US", "London, KY, US", "London, ON, CA"}},
+		"language not inferred":   {"q=london", "en-US", 200, []string{"London, ON, CA", "London, OH, US", "London, KY, US"}},
+		"language inferred":       {"q=london&profile=local", "en-US,en;q=0.9", 200, []string{"London, OH, US", "London, KY, US", "London, ON, CA"}},
+		"preferred over language": {"q=london&profile=local&prefer_country=CA", "en-US", 200, []string{"London, ON, CA", "London, OH, US", "London, KY, US"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?"+tt.query, nil)
+			req.Header.Set("Accept-Language", tt.language)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			if res.Code != tt.status {
+				t.Fatalf("%#v != %#v", res.Code, tt.status)
+			}
+			if res.Code != 200 {
+				return
+			}
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, result := range results {
+				names = append(names, result.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("%#v != %#v", names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsPartialQualifier(t *testing.T) {
+	trie := models.NewTrie()
+	for i, location := range []models.Location{
+		{Name: "New Orleans", DisplayName: "New Orleans, LA", Country: "US", Region: "LA", Population: 389617},
+		{Name: "Newberg", DisplayName: "Newberg, OR", Country: "US", Region: "OR", Population: 22068},
+		{Name: "Newport", DisplayName: "Newport, OR", Country: "US", Region: "OR", Population: 10116},
+		{Name: "Des Moines", DisplayName: "Des Moines, IA", Country: "US", Region: "IA", Population: 203433},
+		{Name: "Desloge", DisplayName: "Desloge, MO", Country: "US", Region: "MO", Population: 5054},
+	} {
+		location.ID = fmt.Sprint(i + 1)
+		trie.Insert(location.Name, location)
+	}
+	suggestions := NewSuggestionsController(trie)
+
+	tests := map[string]struct {
+		query    string
+		expected []string
+	}{
+		"still typing":      {"q=New+Or", []string{"Newberg, OR", "Newport, OR", "New Orleans, LA"}},
+		"still typing too":  {"q=Des+Mo", []string{"Des Moines, IA", "Desloge, MO"}},
+		"typed a region":    {"q=New+OR+", []string{"Newberg, OR", "Newport, OR"}},
+		"typed after comma": {"q=New,+Or", []string{"Newberg, OR", "Newport, OR"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, result := range results {
+				names = append(names, result.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("%#v != %#v", names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAcceptLanguageCountry(t *testing.T) {
+	tests := map[string]string{
+		"":                         "",
+		"en":                       "",
+		"en-CA":                    "CA",
+		"fr-CA,fr;q=0.9,en;q=0.8":  "CA",
+		"en;q=0.9,de-AT;q=0.8":     "AT",
+		"en-GB;q=0.5,en-US;q=0.9":  "US",
+		"zh-Hant-TW":               "TW",
+		"es-419,es-MX;q=0.9":       "MX",
+		"en-US;q=oops,fr-FR;q=0.1": "FR",
+		"*":                        "",
+	}
+	for header, expected := range tests {
+		if actual := acceptLanguageCountry(header); actual != expected {
+			t.Errorf("%q: %q != %q", header, actual, expected)
+		}
+	}
+}
+
+func TestSuggestionsController_HandleSelected(t *testing.T) {
+	springfieldIL := models.Location{ID: "1", Name: "Springfield", DisplayName: "Springfield, IL, US", Country: "US", Region: "IL", Population: 116250}
+	springfieldMO := models.Location{ID: "2", Name: "Springfield", DisplayName: "Springfield, MO, US", Country: "US", Region: "MO", Population: 159498}
+	springfieldMA := models.Location{ID: "3", Name: "Springfield", DisplayName: "Springfield, MA, US", Country: "US", Region: "MA", Population: 153060}
+	trie := models.NewTrie()
+	for _, location := range []models.Location{springfieldIL, springfieldMO, springfieldMA} {
+		trie.Insert(location.Name, location)
+	}
+	suggestions := NewSuggestionsController(trie)
+
+	profiles, err := models.ReadProfiles(bytes.NewBufferString(`{"profiles": {
+		"popular": {"functions": [{"type": "text"}, {"type": "popularity"}]}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	suggestions.SetProfiles(profiles)
+	popularity := models.NewPopularity(models.DefaultPopularityHalfLife)
+	suggestions.SetPopularity(popularity)
+	selectionsLog := &bytes.Buffer{}
+	suggestions.SetSelectionsLog(selectionsLog)
+
+	suggest := func(profile string) []string {
+		req := httptest.NewRequest("GET", "http://example.com/suggestions?q=spring&profile="+profile, nil)
+		res := httptest.NewRecorder()
+		suggestions.HandleSuggestions(res, req)
+
+		results := []models.Result{}
+		if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, result := range results {
+			names = append(names, result.Name)
+		}
+		return names
+	}
+	unselected := suggest("popular")
+	if len(unselected) != 3 || unselected[0] == "Springfield, MA, US" {
+		t.Fatalf("unexpected results before any selections: %#v", unselected)
+	}
+
+	tests := map[string]struct {
+		method      string
+		contentType string
+		body        string
+		status      int
+	}{
+		"form":         {"POST", "application/x-www-form-urlencoded", "q=spring&id=3&latitude=42.1&longitude=-72.6", 204},
+		"JSON":         {"POST", "application/json", `{"q": "spr", "id": "3"}`, 204},
+		"GET":          {"GET", "", "", 405},
+		"no id":        {"POST", "application/x-www-form-urlencoded", "q=spring", 400},
+		"no query":     {"POST", "application/json", `{"id": "3"}`, 400},
+		"unknown id":   {"POST", "application/x-www-form-urlencoded", "q=spring&id=4", 400},
+		"bad latitude": {"POST", "application/json", `{"q": "spr", "id": "3", "latitude": -91, "longitude": 0}`, 400},
+		"invalid JSON": {"POST", "application/json", `{"id": 3}`, 400},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://example.com/suggestions/selected", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			res := httptest.NewRecorder()
+			suggestions.HandleSelected(res, req)
+
+			if res.Code != tt.status {
+				t.Errorf("%#v != %#v: %s", res.Code, tt.status, res.Body)
+			}
+		})
+	}
+
+	expected := []string{"Springfield, MA, US"}
+	for _, name := range unselected {
+		if name != expected[0] {
+			expected = append(expected, name)
+		}
+	}
+	if actual := suggest("popular"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%#v != %#v", actual, expected)
+	}
+	if actual := suggest(""); !reflect.DeepEqual(actual, unselected) {
+		t.Errorf("the default profile shouldn't change: %#v != %#v", actual, unselected)
+	}
+	for _, query := range []string{"spring", "spr"} {
+		if actual := popularity.PrefixCount(query, "3", time.Now()); math.Abs(actual-1) > 1e-6 {
+			t.Errorf("%s: %#v != %#v", query, actual, 1.0)
+		}
+	}
+
+	selections, err := models.ReadSelections(selectionsLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := map[string]bool{}
+	for _, selection := range selections {
+		queries[selection.Query] = selection.ID == "3" && (selection.Lat != nil) == (selection.Query == "spring")
+	}
+	if expected := map[string]bool{"spring": true, "spr": true}; !reflect.DeepEqual(queries, expected) {
+		t.Errorf("%#v != %#v", queries, expected)
+	}
+}
+
+func TestSuggestionsController_Suggested(t *testing.T) {
+	trie := models.NewTrie()
+	trie.EnablePhonetic()
+	for _, location := range []models.Location{
+		{ID: "1", Name: "Springfield", DisplayName: "Springfield, IL, US", Country: "US", Region: "IL", Population: 116250},
+		{ID: "2", Name: "Springfield", DisplayName: "Springfield, MO, US", Country: "US", Region: "MO", Population: 159498},
+		{ID: "3", Name: "Spring", DisplayName: "Spring, TX, US", Country: "US", Region: "TX", Population: 54298},
+		{ID: "4", Name: "Cheyenne", DisplayName: "Cheyenne, WY, US", Country: "US", Region: "WY", Population: 59466},
+	} {
+		trie.Insert(location.Name, location)
+	}
+	suggestions := NewSuggestionsController(trie)
+
+	// the same suggestions as a request for the query, qualified or misspelled
+	for _, query := range []string{"spring", "spring, MO", "Shyenne"} {
+		t.Run(query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?limit=2&q="+url.QueryEscape(query), nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			expected := []string{}
+			for _, result := range results {
+				expected = append(expected, result.Name)
+			}
+
+			suggested, err := suggestions.Suggested(context.Background(), models.Selection{Query: query}, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, location := range suggested {
+				names = append(names, location.DisplayName)
+			}
+			if len(names) == 0 || !reflect.DeepEqual(names, expected) {
+				t.Errorf("%#v != %#v", names, expected)
+			}
+		})
+	}
+}
+
+func TestSuggestionsController_HandleSuggestionsDiversify(t *testing.T) {
+	trie := models.NewTrie()
+	for i, region := range []string{"IL", "MO", "MA", "OR", "OH"} {
+		trie.Insert("Springfield", models.Location{ID: fmt.Sprint(i + 1), Name: "Springfield", DisplayName: "Springfield, " + region, Country: "US", Region: region, Population: int64(100000 - i)})
+	}
+	trie.Insert("Spring Valley", models.Location{ID: "6", Name: "Spring Valley", DisplayName: "Spring Valley, NV", Country: "US", Region: "NV", Population: 215597})
+	trie.Insert("Springdale", models.Location{ID: "7", Name: "Springdale", DisplayName: "Springdale, AR", Country: "US", Region: "AR", Population: 81125})
+	suggestions := NewSuggestionsController(trie)
+
+	tests := map[string]struct {
+		query    string
+		status   int
+		expected []string
+	}{
+		"not diversified": {"q=springf&limit=3", 200, []string{"Springfield, IL", "Springfield, MO", "Springfield, MA"}},
+		"zero":            {"q=springf&limit=3&diversify=0", 200, []string{"Springfield, IL", "Springfield, MO", "Springfield, MA"}},
+		"nothing else":    {"q=springf&limit=3&diversify=0.5", 200, []string{"Springfield, IL", "Springfield, MO", "Springfield, MA"}},
+		"before":          {"q=spring&limit=3", 200, []string{"Springdale, AR", "Springfield, IL", "Springfield, MO"}},
+		"diversified": {"q=spring&limit=3&diversify=0.5", 200,
+			[]string{"Springdale, AR", "Springfield, IL", "Spring Valley, NV"}},
+		// picked before Springfield, MO, but it scores lower
+		"still best first": {"q=spring&limit=4&diversify=0.5", 200,
+			[]string{"Springdale, AR", "Springfield, IL", "Springfield, MO", "Spring Valley, NV"}},
+		"no limit": {"q=spring&limit=0&diversify=0.5", 200, []string{
+			"Springdale, AR", "Springfield, IL", "Springfield, MO", "Springfield, MA", "Springfield, OR", "Springfield, OH", "Spring Valley, NV",
+		}},
+		"too much":     {"q=spring&diversify=1.5", 400, nil},
+		"not a number": {"q=spring&diversify=yes", 400, nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/suggestions?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			suggestions.HandleSuggestions(res, req)
+
+			if res.Code != tt.status {
+				t.Fatalf("%#v != %#v", res.Code, tt.status)
+			}
+			if res.Code != 200 {
+				return
+			}
+
+			results := []models.Result{}
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, result := range results {
+				names = append(names, result.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("%#v != %#v", names, tt.expected)
+			}
+		})
+	}
+}
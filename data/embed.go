@@ -0,0 +1,13 @@
+// Package data embeds the default dataset, so the server and CLI work as a
+// single binary from any working directory.
+package data
+
+import _ "embed"
+
+// CitiesCanadaUSA is the contents of cities_canada-usa.tsv.
+//
+//go:embed cities_canada-usa.tsv
+var CitiesCanadaUSA []byte
+
+// CitiesCanadaUSAName identifies the embedded copy in logs and merge reports.
+const CitiesCanadaUSAName = "embedded:cities_canada-usa.tsv"
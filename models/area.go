@@ -0,0 +1,141 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// An Area is a part of the Earth's surface that results can be restricted to.
+type Area interface {
+	Contains(lat, long float64) bool
+
+	// Bounds covers the area with boxes that don't cross the antimeridian,
+	// for looking it up in a grid.
+	Bounds() []BoundingBox
+}
+
+// A BoundingBox is the area between two latitudes and two longitudes, like a
+// map viewport. A box with MinLong greater than MaxLong crosses the
+// antimeridian: 170,-10,-170,10 is the 20° either side of it.
+type BoundingBox struct {
+	MinLong, MinLat, MaxLong, MaxLat float64
+}
+
+// ParseBoundingBox parses a box written as "minLon,minLat,maxLon,maxLat".
+func ParseBoundingBox(text string) (BoundingBox, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, fmt.Errorf("bounding box %q isn't minLon,minLat,maxLon,maxLat", text)
+	}
+
+	values := [4]float64{}
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return BoundingBox{}, fmt.Errorf("bounding box %q isn't minLon,minLat,maxLon,maxLat", text)
+		}
+		values[i] = value
+	}
+
+	box := BoundingBox{MinLong: values[0], MinLat: values[1], MaxLong: values[2], MaxLat: values[3]}
+	switch {
+	case math.Abs(box.MinLong) > 180 || math.Abs(box.MaxLong) > 180:
+		return BoundingBox{}, fmt.Errorf("bounding box %q has a longitude outside -180 to 180", text)
+	case math.Abs(box.MinLat) > 90 || math.Abs(box.MaxLat) > 90:
+		return BoundingBox{}, fmt.Errorf("bounding box %q has a latitude outside -90 to 90", text)
+	case box.MinLat > box.MaxLat:
+		return BoundingBox{}, fmt.Errorf("bounding box %q has minLat above maxLat", text)
+	}
+	return box, nil
+}
+
+func (box BoundingBox) Contains(lat, long float64) bool {
+	if lat < box.MinLat || lat > box.MaxLat {
+		return false
+	}
+	if box.crossesAntimeridian() {
+		return long >= box.MinLong || long <= box.MaxLong
+	}
+	return long >= box.MinLong && long <= box.MaxLong
+}
+
+// Bounds splits a box that crosses the antimeridian in two.
+func (box BoundingBox) Bounds() []BoundingBox {
+	if !box.crossesAntimeridian() {
+		return []BoundingBox{box}
+	}
+	return []BoundingBox{
+		{MinLong: box.MinLong, MinLat: box.MinLat, MaxLong: 180, MaxLat: box.MaxLat},
+		{MinLong: -180, MinLat: box.MinLat, MaxLong: box.MaxLong, MaxLat: box.MaxLat},
+	}
+}
+
+func (box BoundingBox) crossesAntimeridian() bool {
+	return box.MinLong > box.MaxLong
+}
+
+// A Circle is the area within a distance of a point, along the surface.
+type Circle struct {
+	Lat, Long float64
+	RadiusKm  float64
+}
+
+func (circle Circle) Contains(lat, long float64) bool {
+	return HaversineDistance(circle.Lat, circle.Long, lat, long) <= circle.RadiusKm
+}
+
+// Bounds is the smallest box around the circle, split if it crosses the
+// antimeridian. A circle around a pole covers every longitude.
+func (circle Circle) Bounds() []BoundingBox {
+	radius := circle.RadiusKm / earthRadiusKm
+	dLat := radius * 180 / math.Pi
+	minLat, maxLat := circle.Lat-dLat, circle.Lat+dLat
+	if minLat <= -90 || maxLat >= 90 || radius >= math.Pi/2 {
+		return []BoundingBox{{MinLong: -180, MinLat: math.Max(minLat, -90), MaxLong: 180, MaxLat: math.Min(maxLat, 90)}}
+	}
+
+	// the circle's furthest points east and west are at the longitudes where
+	// great circles through the pole touch it
+	dLong := math.Asin(math.Sin(radius)/math.Cos(radians(circle.Lat))) * 180 / math.Pi
+	minLong, maxLong := circle.Long-dLong, circle.Long+dLong
+	if minLong < -180 {
+		minLong += 360
+	}
+	if maxLong > 180 {
+		maxLong -= 360
+	}
+	return BoundingBox{MinLong: minLong, MinLat: minLat, MaxLong: maxLong, MaxLat: maxLat}.Bounds()
+}
+
+// Areas is where every one of several areas overlap, e.g. the part of a map
+// viewport within a distance of the user.
+type Areas []Area
+
+func (areas Areas) Contains(lat, long float64) bool {
+	for _, area := range areas {
+		if !area.Contains(lat, long) {
+			return false
+		}
+	}
+	return true
+}
+
+// Bounds are the bounds of whichever area's are smallest, which cover where
+// they overlap.
+func (areas Areas) Bounds() []BoundingBox {
+	var smallest []BoundingBox
+	smallestSize := math.Inf(1)
+	for _, area := range areas {
+		bounds := area.Bounds()
+		size := 0.0
+		for _, box := range bounds {
+			size += (box.MaxLong - box.MinLong) * (box.MaxLat - box.MinLat)
+		}
+		if size < smallestSize {
+			smallest, smallestSize = bounds, size
+		}
+	}
+	return smallest
+}
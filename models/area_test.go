@@ -0,0 +1,149 @@
+package models
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseBoundingBox(t *testing.T) {
+	tests := map[string]struct {
+		text     string
+		expected BoundingBox
+		valid    bool
+	}{
+		"viewport":                {"-79.6,43.5,-79.1,43.9", BoundingBox{-79.6, 43.5, -79.1, 43.9}, true},
+		"spaces":                  {"-79.6, 43.5, -79.1, 43.9", BoundingBox{-79.6, 43.5, -79.1, 43.9}, true},
+		"across the antimeridian": {"170,-20,-170,-10", BoundingBox{170, -20, -170, -10}, true},
+		"too few":                 {"-79.6,43.5,-79.1", BoundingBox{}, false},
+		"not a number":            {"west,43.5,-79.1,43.9", BoundingBox{}, false},
+		"longitude out of range":  {"-190,43.5,-79.1,43.9", BoundingBox{}, false},
+		"latitude out of range":   {"-79.6,-95,-79.1,43.9", BoundingBox{}, false},
+		"upside down":             {"-79.6,43.9,-79.1,43.5", BoundingBox{}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			box, err := ParseBoundingBox(tt.text)
+			if (err == nil) != tt.valid {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if box != tt.expected {
+				t.Errorf("%#v != %#v", box, tt.expected)
+			}
+		})
+	}
+}
+
+func TestArea_Contains(t *testing.T) {
+	toronto := Circle{Lat: 43.70011, Long: -79.4163, RadiusKm: 100}
+
+	tests := map[string]struct {
+		area      Area
+		lat, long float64
+		expected  bool
+	}{
+		"in a box":                        {BoundingBox{-80, 43, -79, 44}, 43.7, -79.4, true},
+		"on the edge of a box":            {BoundingBox{-80, 43, -79, 44}, 44, -79, true},
+		"west of a box":                   {BoundingBox{-80, 43, -79, 44}, 43.7, -80.1, false},
+		"north of a box":                  {BoundingBox{-80, 43, -79, 44}, 44.1, -79.4, false},
+		"east of the antimeridian":        {BoundingBox{170, -20, -170, -10}, -17, -179.5, true},
+		"west of the antimeridian":        {BoundingBox{170, -20, -170, -10}, -17, 179.5, true},
+		"outside across the antimeridian": {BoundingBox{170, -20, -170, -10}, -17, 0, false},
+		"in a circle":                     {toronto, 43.25, -79.87, true}, // Hamilton, ~60 km
+		"outside a circle":                {toronto, 45.42, -75.7, false}, // Ottawa, ~350 km
+		"in both":                         {Areas{toronto, BoundingBox{-80, 43, -79, 44}}, 43.25, -79.87, true},
+		"in only one":                     {Areas{toronto, BoundingBox{-79.5, 43, -79, 44}}, 43.25, -79.87, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := tt.area.Contains(tt.lat, tt.long); actual != tt.expected {
+				t.Errorf("%v != %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+// Every point in a circle has to be in its bounds, or a grid lookup would miss
+// it, including around the antimeridian and the poles.
+func TestCircle_Bounds(t *testing.T) {
+	circles := map[string]Circle{
+		"city":                    {Lat: 43.70011, Long: -79.4163, RadiusKm: 50},
+		"far north":               {Lat: 78, Long: 15, RadiusKm: 500},
+		"across the antimeridian": {Lat: -17, Long: 179, RadiusKm: 300},
+		"around the pole":         {Lat: 88, Long: 0, RadiusKm: 500},
+		"half the world":          {Lat: 0, Long: 0, RadiusKm: 15000},
+	}
+	random := rand.New(rand.NewSource(1))
+
+	for name, circle := range circles {
+		t.Run(name, func(t *testing.T) {
+			bounds := circle.Bounds()
+			for i := 0; i < 100000; i++ {
+				lat, long := random.Float64()*180-90, random.Float64()*360-180
+				if !circle.Contains(lat, long) {
+					continue
+				}
+
+				inBounds := false
+				for _, box := range bounds {
+					inBounds = inBounds || box.Contains(lat, long)
+				}
+				if !inBounds {
+					t.Fatalf("(%v, %v) isn't in %#v", lat, long, bounds)
+				}
+			}
+		})
+	}
+}
+
+func TestTrie_FindInArea(t *testing.T) {
+	suva := Location{ID: "1", Name: "Suva", Lat: -18.14, Long: 178.44}
+	taveuni := Location{ID: "2", Name: "Somosomo", Lat: -16.77, Long: -179.97}
+	savusavu := Location{ID: "3", Name: "Savusavu", Lat: -16.78, Long: 179.33}
+	apia := Location{ID: "4", Name: "Apia", Lat: -13.83, Long: -171.76}
+
+	tree := NewTrie()
+	for _, location := range []Location{suva, taveuni, savusavu, apia} {
+		tree.Insert(location.Name, location)
+	}
+
+	tests := map[string]struct {
+		prefix   string
+		area     Area
+		limit    int
+		expected []string
+		found    bool
+	}{
+		"across the antimeridian": {"s", BoundingBox{178, -20, -179, -15}, 10, []string{"Savusavu", "Somosomo", "Suva"}, true},
+		"one side":                {"s", BoundingBox{178, -20, 180, -15}, 10, []string{"Savusavu", "Suva"}, true},
+		"prefix":                  {"sa", BoundingBox{178, -20, -179, -15}, 10, []string{"Savusavu"}, true},
+		"circle":                  {"s", Circle{Lat: -16.78, Long: 179.33, RadiusKm: 100}, 10, []string{"Savusavu", "Somosomo"}, true},
+		"too many":                {"s", BoundingBox{178, -20, -179, -15}, 2, nil, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			matches, found := tree.FindInArea(tt.prefix, tt.area, tt.limit)
+			if found != tt.found {
+				t.Fatalf("%v != %v", found, tt.found)
+			}
+
+			var names []string
+			for _, match := range matches {
+				names = append(names, match.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("%#v != %#v", names, tt.expected)
+			}
+		})
+	}
+
+	// locations added and removed after the grid is built
+	tree.Insert("Nadi", Location{ID: "5", Name: "Nadi", Lat: -17.8, Long: 177.42})
+	tree.Remove("1")
+	matches, _ := tree.FindInArea("", BoundingBox{177, -20, 179, -17}, 10)
+	if len(matches) != 1 || matches[0].Name != "Nadi" {
+		t.Errorf("%#v", matches)
+	}
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// A Decay scores locations by how far they are from an origin, like the decay
+// functions of Elasticsearch's function_score. Locations within offset of the
+// origin score 1, and the score falls off past that, to decay at scale further
+// out:
+//   - "exp" halves (for the default decay of 0.5) every scale, and never reaches 0
+//   - "gauss" falls slowly near the origin, fastest around scale, then flattens out
+//   - "linear" falls at a constant rate, reaching 0 at scale / (1 - decay)
+//
+// It's set on a profile's distance function:
+//
+//	{"type": "distance", "decay": {"function": "gauss", "scale_km": 100, "offset_km": 5}}
+type Decay struct {
+	Function string  `json:"function"`
+	Origin   *Origin `json:"origin"`    // defaults to the request's coordinates
+	ScaleKm  float64 `json:"scale_km"`  // how far past offset the score is decay
+	OffsetKm float64 `json:"offset_km"` // how far from the origin still scores 1
+	Decay    float64 `json:"decay"`     // the score at scale, between 0 and 1 (default 0.5)
+}
+
+// An Origin is the point a Decay measures distances from.
+type Origin struct {
+	Lat  float64 `json:"lat"`
+	Long float64 `json:"long"`
+}
+
+// Decay functions
+const (
+	DecayExp    = "exp"
+	DecayGauss  = "gauss"
+	DecayLinear = "linear"
+)
+
+// check validates a decay and fills in its defaults.
+func (decay *Decay) check() error {
+	switch decay.Function {
+	case DecayExp, DecayGauss, DecayLinear:
+	default:
+		return fmt.Errorf("unknown decay function %q", decay.Function)
+	}
+
+	if decay.ScaleKm <= 0 {
+		return fmt.Errorf("decay needs a positive scale_km")
+	}
+	if decay.OffsetKm < 0 {
+		return fmt.Errorf("negative decay offset_km")
+	}
+	if decay.Decay == 0 {
+		decay.Decay = 0.5
+	}
+	if decay.Decay < 0 || decay.Decay >= 1 {
+		return fmt.Errorf("decay must be between 0 and 1")
+	}
+	if origin := decay.Origin; origin != nil && (math.Abs(origin.Lat) > 90 || math.Abs(origin.Long) > 180) {
+		return fmt.Errorf("decay origin (%g, %g) isn't a coordinate", origin.Lat, origin.Long)
+	}
+
+	return nil
+}
+
+// Score scores a distance from the origin, in km.
+func (decay *Decay) Score(distanceKm float64) float64 {
+	x := math.Max(distanceKm-decay.OffsetKm, 0) / decay.ScaleKm
+
+	switch decay.Function {
+	case DecayGauss:
+		return math.Pow(decay.Decay, x*x)
+	case DecayLinear:
+		return math.Max(1-(1-decay.Decay)*x, 0)
+	}
+	return math.Pow(decay.Decay, x)
+}
+
+// A DecayScorer scores results by their distance from a point, with a Decay.
+type DecayScorer struct {
+	lat, long float64
+	decay     *Decay
+}
+
+// NewDecayScorer scores by distance from the decay's origin, or from lat, long
+// if it doesn't have one.
+func NewDecayScorer(lat, long float64, decay *Decay) *DecayScorer {
+	if decay.Origin != nil {
+		lat, long = decay.Origin.Lat, decay.Origin.Long
+	}
+	return &DecayScorer{lat: lat, long: long, decay: decay}
+}
+
+func (scorer *DecayScorer) Score(location Location) float64 {
+	return scorer.decay.Score(HaversineDistance(scorer.lat, scorer.long, location.Lat, location.Long))
+}
+
+func (scorer *DecayScorer) Explain(location Location) Explanation {
+	decay := scorer.decay
+	distance := HaversineDistance(scorer.lat, scorer.long, location.Lat, location.Long)
+
+	formulas := map[string]string{
+		DecayExp:    "%g^(max(%.0f - %g, 0) / %g)",
+		DecayGauss:  "%g^((max(%.0f - %g, 0) / %g)^2)",
+		DecayLinear: "max(1 - (1 - %g) * max(%.0f - %g, 0) / %g, 0)",
+	}
+	formula := fmt.Sprintf(formulas[decay.Function], decay.Decay, distance, decay.OffsetKm, decay.ScaleKm)
+	return Explanation{
+		Value:       scorer.Score(location),
+		Description: fmt.Sprintf("%s, %s decay for %.0f km from (%g, %g)", formula, decay.Function, distance, scorer.lat, scorer.long),
+	}
+}
+
+// MaxScore is 1, for a location within offset. Names don't bound distance.
+func (scorer *DecayScorer) MaxScore(minLength int) float64 {
+	return 1.0
+}
+
+// HaversineDistance is the great-circle distance between two points in km.
+// Unlike the spherical law of cosines, it's accurate for points close together.
+func HaversineDistance(lat1, long1, lat2, long2 float64) float64 {
+	lat1, long1, lat2, long2 = radians(lat1), radians(long1), radians(lat2), radians(long2)
+
+	a := math.Pow(math.Sin((lat2-lat1)/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin((long2-long1)/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(math.Sqrt(a), 1))
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestHaversineDistance(t *testing.T) {
+	tests := map[string]struct {
+		lat1, long1, lat2, long2 float64
+		expected                 float64
+	}{
+		"same point": {
+			45.50884, -73.58781, 45.50884, -73.58781,
+			0,
+		},
+		"Toronto to Montreal": {
+			43.70011, -79.4163, 45.50884, -73.58781,
+			504,
+		},
+		"antipodes": {
+			0, 0, 0, 180,
+			math.Pi * earthRadiusKm,
+		},
+		"across the antimeridian": {
+			0, 179.5, 0, -179.5,
+			111,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := HaversineDistance(tt.lat1, tt.long1, tt.lat2, tt.long2); math.Abs(actual-tt.expected) > 1 {
+				t.Errorf("%v != %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDecay_Score(t *testing.T) {
+	tests := map[string]struct {
+		decay    Decay
+		distance float64
+		expected float64
+	}{
+		"within offset":          {Decay{Function: DecayGauss, ScaleKm: 100, OffsetKm: 10, Decay: 0.5}, 10, 1},
+		"exp at scale":           {Decay{Function: DecayExp, ScaleKm: 100, OffsetKm: 10, Decay: 0.5}, 110, 0.5},
+		"exp at twice scale":     {Decay{Function: DecayExp, ScaleKm: 100, Decay: 0.5}, 200, 0.25},
+		"gauss at scale":         {Decay{Function: DecayGauss, ScaleKm: 100, OffsetKm: 10, Decay: 0.5}, 110, 0.5},
+		"gauss at twice scale":   {Decay{Function: DecayGauss, ScaleKm: 100, Decay: 0.5}, 200, 0.0625},
+		"linear at scale":        {Decay{Function: DecayLinear, ScaleKm: 100, OffsetKm: 10, Decay: 0.5}, 110, 0.5},
+		"linear at twice scale":  {Decay{Function: DecayLinear, ScaleKm: 100, Decay: 0.5}, 200, 0},
+		"linear past zero":       {Decay{Function: DecayLinear, ScaleKm: 100, Decay: 0.5}, 5000, 0},
+		"different decay":        {Decay{Function: DecayExp, ScaleKm: 50, Decay: 0.1}, 50, 0.1},
+		"linear different decay": {Decay{Function: DecayLinear, ScaleKm: 50, Decay: 0.8}, 100, 0.6},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := tt.decay.Score(tt.distance); math.Abs(actual-tt.expected) > 1e-12 {
+				t.Errorf("%v != %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+// Linear scoring over the whole Earth barely separates a city 100 km away from
+// one 1000 km away. A decay over a useful scale does.
+func TestDecayScorer_Score(t *testing.T) {
+	toronto := Origin{Lat: 43.70011, Long: -79.4163}
+	hamilton := Location{Name: "Hamilton", Lat: 43.23341, Long: -79.94964}   // ~60 km
+	montreal := Location{Name: "Montreal", Lat: 45.50884, Long: -73.58781}   // ~500 km
+	halifax := Location{Name: "Halifax", Lat: 44.64533, Long: -63.57239}     // ~1300 km
+	vancouver := Location{Name: "Vancouver", Lat: 49.24966, Long: -123.1193} // ~3400 km
+
+	scorer := NewDecayScorer(toronto.Lat, toronto.Long, &Decay{Function: DecayGauss, ScaleKm: 500, OffsetKm: 50, Decay: 0.5})
+
+	previous := 1.0
+	for _, location := range []Location{hamilton, montreal, halifax, vancouver} {
+		score := scorer.Score(location)
+		if score >= previous {
+			t.Errorf("%s: %v >= %v", location.Name, score, previous)
+		}
+		if explained := scorer.Explain(location); explained.Value != score {
+			t.Errorf("%s: explained %v != %v", location.Name, explained.Value, score)
+		}
+		previous = score
+	}
+
+	if spread := scorer.Score(hamilton) - scorer.Score(halifax); spread < 0.5 {
+		t.Errorf("%v spread between 60 and 1300 km", spread)
+	}
+	if scorer.Score(vancouver) > 1e-6 {
+		t.Errorf("%v for 3400 km", scorer.Score(vancouver))
+	}
+}
+
+func TestReadProfiles_Decay(t *testing.T) {
+	tests := map[string]struct {
+		function string
+		valid    bool
+	}{
+		"defaults":         {`{"type": "distance", "decay": {"function": "exp", "scale_km": 100}}`, true},
+		"with origin":      {`{"type": "distance", "decay": {"function": "gauss", "scale_km": 100, "offset_km": 5, "decay": 0.2, "origin": {"lat": 45.5, "long": -73.6}}}`, true},
+		"unknown function": {`{"type": "distance", "decay": {"function": "step", "scale_km": 100}}`, false},
+		"no scale":         {`{"type": "distance", "decay": {"function": "exp"}}`, false},
+		"negative offset":  {`{"type": "distance", "decay": {"function": "exp", "scale_km": 100, "offset_km": -1}}`, false},
+		"decay of 1":       {`{"type": "distance", "decay": {"function": "exp", "scale_km": 100, "decay": 1}}`, false},
+		"bad origin":       {`{"type": "distance", "decay": {"function": "exp", "scale_km": 100, "origin": {"lat": 95, "long": 0}}}`, false},
+		"not distance":     {`{"type": "text", "decay": {"function": "exp", "scale_km": 100}}`, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadProfiles(strings.NewReader(`{"profiles": {"p": {"functions": [` + tt.function + `]}}}`))
+			if (err == nil) != tt.valid {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestProfile_ScorerDecay(t *testing.T) {
+	lat, long := 43.70011, -79.4163
+	montreal := Location{Name: "Montreal", Lat: 45.50884, Long: -73.58781}
+	decay := &Decay{Function: DecayExp, ScaleKm: 100, Decay: 0.5}
+	profile := &Profile{Mode: ModeFirst, Functions: []*ScoreFunction{{Type: FunctionDistance, Weight: 1, Decay: decay}}}
+
+	if actual, expected := profile.Scorer("m", ScoringContext{Lat: &lat, Long: &long}).Score(montreal), NewDecayScorer(lat, long, decay).Score(montreal); actual != expected {
+		t.Errorf("from the request: %v != %v", actual, expected)
+	}
+	if actual := profile.Scorer("m", ScoringContext{}).Score(montreal); actual != 1 {
+		t.Errorf("without coordinates: %v != 1", actual)
+	}
+
+	decay.Origin = &Origin{Lat: montreal.Lat, Long: montreal.Long}
+	if actual := profile.Scorer("m", ScoringContext{}).Score(montreal); actual != 1 {
+		t.Errorf("from the origin: %v != 1", actual)
+	}
+	if actual := profile.Scorer("m", ScoringContext{Lat: &lat, Long: &long}).Score(montreal); actual != 1 {
+		t.Errorf("from the origin, ignoring the request: %v != 1", actual)
+	}
+}
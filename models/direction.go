@@ -0,0 +1,46 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// Units for distances in results
+const (
+	Kilometers = "km"
+	Miles      = "mi"
+)
+
+const kmPerMile = 1.609344
+
+// ParseUnits checks the units asked for, defaulting to kilometers.
+func ParseUnits(units string) (string, error) {
+	switch units {
+	case "":
+		return Kilometers, nil
+	case Kilometers, Miles:
+		return units, nil
+	}
+	return "", fmt.Errorf("unknown units %q, use %q or %q", units, Kilometers, Miles)
+}
+
+// Bearing is the initial bearing of the great circle from the first point to
+// the second, in degrees clockwise from north, from 0 up to 360. Following a
+// great circle, the bearing changes along the way, so this is the direction to
+// set off in.
+func Bearing(lat1, long1, lat2, long2 float64) float64 {
+	lat1, long1, lat2, long2 = radians(lat1), radians(long1), radians(lat2), radians(long2)
+
+	y := math.Sin(long2-long1) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(long2-long1)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+var compassPoints = []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+
+// CompassDirection names the nearest of the 8 points of the compass to a
+// bearing, e.g. "NW" for 300°.
+func CompassDirection(bearing float64) string {
+	sector := int(math.Round(bearing/45)) % len(compassPoints)
+	return compassPoints[sector]
+}
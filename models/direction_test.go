@@ -0,0 +1,67 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBearing(t *testing.T) {
+	tests := map[string]struct {
+		lat1, long1, lat2, long2 float64
+		bearing                  float64
+		direction                string
+	}{
+		"north":                   {0, 0, 10, 0, 0, "N"},
+		"east along the equator":  {0, 0, 0, 10, 90, "E"},
+		"south":                   {10, 0, 0, 0, 180, "S"},
+		"west":                    {0, 10, 0, 0, 270, "W"},
+		"across the antimeridian": {0, 179.5, 0, -179.5, 90, "E"},
+		"Toronto to Montreal":     {43.70011, -79.4163, 45.50884, -73.58781, 64.4, "NE"},
+		"Montreal to Toronto":     {45.50884, -73.58781, 43.70011, -79.4163, 248.5, "W"},
+		"Toronto to Vancouver":    {43.70011, -79.4163, 49.24966, -123.1193, 296.2, "NW"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			bearing := Bearing(tt.lat1, tt.long1, tt.lat2, tt.long2)
+			if math.Abs(bearing-tt.bearing) > 0.1 {
+				t.Errorf("%v != %v", bearing, tt.bearing)
+			}
+			if direction := CompassDirection(bearing); direction != tt.direction {
+				t.Errorf("%q != %q", direction, tt.direction)
+			}
+		})
+	}
+}
+
+func TestCompassDirection(t *testing.T) {
+	tests := map[float64]string{
+		0: "N", 22.4: "N", 22.6: "NE", 135: "SE", 200: "S", 315: "NW", 337.4: "NW", 337.6: "N", 359.9: "N",
+	}
+	for bearing, expected := range tests {
+		if actual := CompassDirection(bearing); actual != expected {
+			t.Errorf("%v: %q != %q", bearing, actual, expected)
+		}
+	}
+}
+
+func TestResult_AddDistance(t *testing.T) {
+	montreal := NewResult(Location{Name: "Montreal", Lat: 45.50884, Long: -73.58781}, 1)
+
+	km := montreal
+	km.AddDistance(43.70011, -79.4163, Kilometers)
+	if km.Units != Kilometers || math.Abs(*km.Distance-504) > 1 || km.Direction != "NE" {
+		t.Errorf("%v %s %s", *km.Distance, km.Units, km.Direction)
+	}
+
+	miles := montreal
+	miles.AddDistance(43.70011, -79.4163, Miles)
+	if miles.Units != Miles || math.Abs(*miles.Distance-*km.Distance/kmPerMile) > 1e-9 {
+		t.Errorf("%v %s", *miles.Distance, miles.Units)
+	}
+
+	here := montreal
+	here.AddDistance(montreal.Lat, montreal.Long, Kilometers)
+	if *here.Distance != 0 || here.Bearing != nil || here.Direction != "" {
+		t.Errorf("%v %v %q", *here.Distance, here.Bearing, here.Direction)
+	}
+}
@@ -0,0 +1,81 @@
+package models
+
+import "strings"
+
+// How similar two results are when they're duplicates: the same name in the
+// same region is as good as the same place to someone skimming a list, and the
+// same name elsewhere is half as bad.
+const (
+	sameNameAndRegion = 1.0
+	sameName          = 0.5
+)
+
+// Diversify picks n of the ranked locations (best first), trading relevance
+// for variety with maximal marginal relevance (MMR): each pick is the location
+// with the highest
+//
+//	(1 - strength) * score / top score - strength * similarity
+//
+// where similarity is to the most similar location already picked, so a page
+// of Springfields makes room for Spring Valley. A strength of 0 keeps the
+// ranking as it is, and 1 only breaks ties by it. The top location is always
+// picked first, and ties go to the better ranked location.
+func Diversify(ranked []ScoredLocation, n int, strength float64) []ScoredLocation {
+	n = min(n, len(ranked))
+	if n == 0 || strength <= 0 {
+		return ranked[:n]
+	}
+
+	type candidate struct {
+		relevance  float64
+		name       string  // lowercased
+		region     string  // country and region
+		similarity float64 // to the most similar pick so far
+		picked     bool
+	}
+	candidates := make([]candidate, len(ranked))
+	for i, location := range ranked {
+		candidates[i] = candidate{
+			name:   strings.ToLower(location.Name),
+			region: location.Country + location.Region,
+		}
+		if top := ranked[0].Score; top > 0 {
+			candidates[i].relevance = location.Score / top
+		}
+	}
+
+	picks := make([]ScoredLocation, 0, n)
+	for pick := 0; pick < n; pick++ {
+		best := 0 // the top location, to start with
+		if pick > 0 {
+			best = -1
+			for i, c := range candidates {
+				if c.picked {
+					continue
+				}
+				if best < 0 || marginalRelevance(c.relevance, c.similarity, strength) >
+					marginalRelevance(candidates[best].relevance, candidates[best].similarity, strength) {
+					best = i
+				}
+			}
+		}
+
+		candidates[best].picked = true
+		picks = append(picks, ranked[best])
+		for i := range candidates {
+			if candidates[i].name != candidates[best].name {
+				continue
+			}
+			similarity := sameName
+			if candidates[i].region == candidates[best].region {
+				similarity = sameNameAndRegion
+			}
+			candidates[i].similarity = max(candidates[i].similarity, similarity)
+		}
+	}
+	return picks
+}
+
+func marginalRelevance(relevance, similarity, strength float64) float64 {
+	return (1-strength)*relevance - strength*similarity
+}
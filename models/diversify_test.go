@@ -0,0 +1,43 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiversify(t *testing.T) {
+	ranked := []ScoredLocation{
+		{Location: Location{ID: "1", Name: "Springfield", Country: "US", Region: "IL"}, Score: 1.0},
+		{Location: Location{ID: "2", Name: "Springfield", Country: "US", Region: "MO"}, Score: 0.95},
+		{Location: Location{ID: "3", Name: "Springfield", Country: "US", Region: "IL"}, Score: 0.9},
+		{Location: Location{ID: "4", Name: "Spring Valley", Country: "US", Region: "IL"}, Score: 0.8},
+		{Location: Location{ID: "5", Name: "springfield", Country: "US", Region: "MA"}, Score: 0.75},
+		{Location: Location{ID: "6", Name: "Spring", Country: "US", Region: "TX"}, Score: 0.1},
+	}
+
+	tests := map[string]struct {
+		n        int
+		strength float64
+		expected []string
+	}{
+		"not diversified":        {4, 0, []string{"1", "2", "3", "4"}},
+		"same region goes first": {6, 0.1, []string{"1", "2", "4", "3", "5", "6"}},
+		"more":                   {6, 0.3, []string{"1", "4", "2", "5", "3", "6"}},
+		"only variety":           {6, 1, []string{"1", "4", "6", "2", "5", "3"}},
+		"fewer picks":            {2, 0.3, []string{"1", "4"}},
+		"more than there are":    {10, 0, []string{"1", "2", "3", "4", "5", "6"}},
+		"nothing":                {0, 0.5, []string{}},
+		"top is always kept":     {1, 1, []string{"1"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ids := []string{}
+			for _, location := range Diversify(ranked, tt.n, tt.strength) {
+				ids = append(ids, location.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Errorf("%#v != %#v", ids, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package models
+
+import "fmt"
+
+// An Explanation breaks a score down into the parts it was calculated from, so
+// that a ranking can be understood. Each part has its own value and may be
+// broken down further, and the description says how the parts were combined.
+type Explanation struct {
+	Value       float64       `json:"value"`
+	Description string        `json:"description"`
+	Details     []Explanation `json:"details,omitempty"`
+}
+
+// An Explainer is a Scorer that can explain its scores. The value of the
+// explanation is always the same as the score.
+type Explainer interface {
+	Scorer
+	Explain(Location) Explanation
+}
+
+// Explain explains the score a scorer gives a location, or just reports the
+// score if the scorer can't explain it.
+func Explain(scorer Scorer, location Location) Explanation {
+	if explainer, ok := scorer.(Explainer); ok {
+		return explainer.Explain(location)
+	}
+	return Explanation{Value: scorer.Score(location), Description: fmt.Sprintf("%T", scorer)}
+}
+
+// ExplainMatch explains the score of a match: the score of its location,
+// weighted down if it was found by a later word or by sound.
+func ExplainMatch(scorer Scorer, match Match) Explanation {
+	score := Explain(scorer, match.Location)
+	if match.Weight() == 1 {
+		return score
+	}
+
+	details := []Explanation{score}
+	if match.Token > 0 {
+		details = append(details, Explanation{
+			Value:       TokenWeight(match.Token),
+			Description: fmt.Sprintf("matched word %d of the name, not the first", match.Token+1),
+		})
+	}
+	if match.Phonetic {
+		details = append(details, Explanation{
+			Value:       PhoneticWeight,
+			Description: "sounds like the query, but isn't spelled like it",
+		})
+	}
+	return Explanation{
+		Value:       score.Value * match.Weight(),
+		Description: "product of:",
+		Details:     details,
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExplainMatch(t *testing.T) {
+	newYork := Location{Name: "New York", Lat: 40.71427, Long: -74.00597}
+
+	tests := map[string]struct {
+		scorer   Scorer
+		match    Match
+		expected Explanation
+	}{
+		"first word": {
+			NewRelativeLengthScorer("new"),
+			Match{newYork, 0, false},
+			Explanation{
+				Value:       InverseLengthScore(5),
+				Description: "2^-5, for a name 5 characters longer than the query",
+			},
+		},
+		"later word": {
+			NewRelativeLengthScorer("york"),
+			Match{newYork, 1, false},
+			Explanation{
+				Value:       InverseLengthScore(4) * LaterTokenWeight,
+				Description: "product of:",
+				Details: []Explanation{
+					{Value: InverseLengthScore(4), Description: "2^-4, for a name 4 characters longer than the query"},
+					{Value: LaterTokenWeight, Description: "matched word 2 of the name, not the first"},
+				},
+			},
+		},
+		"phonetic": {
+			NewGeoDistanceScorer(40.71427, -74.00597),
+			Match{newYork, 0, true},
+			Explanation{
+				Value:       PhoneticWeight,
+				Description: "product of:",
+				Details: []Explanation{
+					{Value: 1, Description: "1 - 0, for 0 km from (40.71427, -74.00597) as a fraction of half the Earth's circumference"},
+					{Value: PhoneticWeight, Description: "sounds like the query, but isn't spelled like it"},
+				},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual := ExplainMatch(tt.scorer, tt.match)
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("%#v != %#v", actual, tt.expected)
+			}
+
+			// the explanation always adds up to the score
+			if score := tt.scorer.Score(tt.match.Location) * tt.match.Weight(); actual.Value != score {
+				t.Errorf("%#v != %#v", actual.Value, score)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Filter decides which rows of the source data are loaded at all, so that
+// different products can be served from the same file. A row is loaded only if
+// it passes every rule. It's read from a JSON config like:
+//
+//	{"rules": [
+//	    {"name": "major cities", "feature_codes": ["PPLC", "PPLA"]},
+//	    {"name": "canada", "countries": ["CA"]},
+//	    {"name": "towns", "min_population": 5000}
+//	]}
+type Filter struct {
+	Rules []*FilterRule `json:"rules"`
+}
+
+// A FilterRule passes a row if it matches every condition that is set. Keeping
+// one condition per rule gives the most useful drop counts.
+type FilterRule struct {
+	Name          string   `json:"name"`
+	FeatureCodes  []string `json:"feature_codes"`  // any of these GeoNames feature codes
+	Countries     []string `json:"countries"`      // any of these ISO-3166 country codes
+	MinPopulation int64    `json:"min_population"` // at least this many people
+
+	// Dropped counts the rows rejected by this rule. Rows are only counted
+	// against the first rule that rejects them.
+	Dropped int `json:"-"`
+}
+
+// ReadFilter parses a JSON filter config.
+func ReadFilter(file io.Reader) (*Filter, error) {
+	filter := &Filter{}
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(filter); err != nil {
+		return nil, fmt.Errorf("invalid filter config: %s", err)
+	}
+
+	for i, rule := range filter.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+	}
+
+	return filter, nil
+}
+
+// Allow reports whether a location passes every rule, counting the drop
+// against the first rule that rejects it. A nil filter allows everything.
+func (filter *Filter) Allow(location Location) bool {
+	if filter == nil {
+		return true
+	}
+
+	for _, rule := range filter.Rules {
+		if !rule.Match(location) {
+			rule.Dropped++
+			return false
+		}
+	}
+
+	return true
+}
+
+// Match reports whether a location satisfies every condition in the rule.
+func (rule *FilterRule) Match(location Location) bool {
+	if len(rule.FeatureCodes) > 0 && !containsFold(rule.FeatureCodes, location.FeatureCode) {
+		return false
+	}
+	if len(rule.Countries) > 0 && !containsFold(rule.Countries, location.Country) {
+		return false
+	}
+	if location.Population < rule.MinPopulation {
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, s string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+	return false
+}
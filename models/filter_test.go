@@ -0,0 +1,123 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadFilter(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		valid  bool
+		names  []string
+	}{
+		"named and unnamed rules": {
+			`{"rules": [{"name": "canada", "countries": ["CA"]}, {"min_population": 5000}]}`,
+			true,
+			[]string{"canada", "rule 2"},
+		},
+		"empty config": {
+			`{}`,
+			true,
+			[]string{},
+		},
+		"unknown field": {
+			`{"rules": [{"population": 5000}]}`,
+			false,
+			nil,
+		},
+		"invalid JSON": {
+			`{"rules": `,
+			false,
+			nil,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			filter, err := ReadFilter(strings.NewReader(tt.config))
+			if (err == nil) != tt.valid {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if len(filter.Rules) != len(tt.names) {
+				t.Fatalf("%d rules != %d", len(filter.Rules), len(tt.names))
+			}
+			for i, rule := range filter.Rules {
+				if rule.Name != tt.names[i] {
+					t.Errorf("%q != %q", rule.Name, tt.names[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFilter_Allow(t *testing.T) {
+	filter := &Filter{Rules: []*FilterRule{
+		{Name: "admin seats", FeatureCodes: []string{"PPLC", "PPLA"}},
+		{Name: "canada", Countries: []string{"ca"}},
+		{Name: "towns", MinPopulation: 5000},
+	}}
+
+	tests := map[string]struct {
+		location Location
+		allowed  bool
+	}{
+		"passes every rule": {
+			Location{FeatureCode: "PPLA", Country: "CA", Population: 500000},
+			true,
+		},
+		"wrong feature code": {
+			Location{FeatureCode: "PPL", Country: "CA", Population: 500000},
+			false,
+		},
+		"wrong country": {
+			Location{FeatureCode: "PPLA", Country: "US", Population: 500000},
+			false,
+		},
+		"too small": {
+			Location{FeatureCode: "PPLC", Country: "CA", Population: 10},
+			false,
+		},
+		"only counted against the first rule": {
+			Location{FeatureCode: "PPL", Country: "US", Population: 10},
+			false,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := filter.Allow(tt.location); actual != tt.allowed {
+				t.Errorf("%v != %v", actual, tt.allowed)
+			}
+		})
+	}
+
+	for i, expected := range []int{2, 1, 1} {
+		if filter.Rules[i].Dropped != expected {
+			t.Errorf("%s dropped %d != %d", filter.Rules[i].Name, filter.Rules[i].Dropped, expected)
+		}
+	}
+
+	var none *Filter
+	if !none.Allow(Location{}) {
+		t.Errorf("nil filter should allow everything")
+	}
+}
+
+func TestLoadTrie_Filter(t *testing.T) {
+	filter := &Filter{Rules: []*FilterRule{{Name: "towns", MinPopulation: 5000}}}
+	data := geonamesRow("1", "Montreal", "") + strings.Replace(geonamesRow("2", "Montmagny", ""), "\t1000\t", "\t12000\t", 1)
+
+	tree, err := LoadTrie(strings.NewReader(data), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tree.Find("montreal") || !tree.Find("montmagny") {
+		t.Errorf("filter wasn't applied while loading")
+	}
+	if tree.Locations().Len() != 1 || filter.Rules[0].Dropped != 1 {
+		t.Errorf("%d locations, %d dropped", tree.Locations().Len(), filter.Rules[0].Dropped)
+	}
+}
@@ -0,0 +1,248 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// An fst is a minimal acyclic finite state transducer that maps each key in a
+// set to its ordinal (its position in sorted order). Unlike a trie, states
+// with the same suffixes are shared, so "-ville" is only stored once however
+// many names end with it.
+//
+// Ordinals are the sum of the outputs on the arcs along a key's path. An arc's
+// output is the number of keys that sort before any key through it and after
+// the path so far: one if a key ends at its source, plus the keys through
+// every earlier arc.
+//
+// The transducer is a flat byte slice of nodes, written children first, so
+// that it can be used straight from a memory-mapped file:
+//
+//	flags  uint32  1 if a key ends at this node
+//	count  uint32  number of keys from this node on
+//	arcs   uint32  number of arcs
+//	then for each arc, sorted by label:
+//	label  uint32  the character
+//	target uint32  offset of the node it leads to
+//	output uint32
+type fst struct {
+	data []byte
+	root uint32
+}
+
+const (
+	fstHeaderSize = 12
+	fstArcSize    = 12
+)
+
+func (f fst) uint32(offset uint32) uint32 {
+	return binary.LittleEndian.Uint32(f.data[offset:])
+}
+
+// final reports whether a key ends at node.
+func (f fst) final(node uint32) bool {
+	return f.uint32(node)&1 != 0
+}
+
+// arcs returns the number of arcs leaving node.
+func (f fst) arcs(node uint32) int {
+	return int(f.uint32(node + 8))
+}
+
+// arc returns the label, target and output of the i'th arc of node.
+func (f fst) arc(node uint32, i int) (rune, uint32, uint32) {
+	offset := node + fstHeaderSize + uint32(i)*fstArcSize
+	return rune(f.uint32(offset)), f.uint32(offset + 4), f.uint32(offset + 8)
+}
+
+// child follows the arc labelled char from node, returning its target and
+// output.
+func (f fst) child(node uint32, char rune) (uint32, uint32, bool) {
+	// arcs are sorted by label, so binary search them
+	low, high := 0, f.arcs(node)
+	for low < high {
+		mid := (low + high) / 2
+		label, target, output := f.arc(node, mid)
+		switch {
+		case label == char:
+			return target, output, true
+		case label < char:
+			low = mid + 1
+		default:
+			high = mid
+		}
+	}
+	return 0, 0, false
+}
+
+// walk follows key from the root, returning the node it ends at and the sum of
+// the outputs on the way.
+func (f fst) walk(key string) (uint32, uint32, bool) {
+	node, ordinal := f.root, uint32(0)
+
+	for _, char := range key {
+		target, output, found := f.child(node, char)
+		if !found {
+			return 0, 0, false
+		}
+		node, ordinal = target, ordinal+output
+	}
+
+	return node, ordinal, true
+}
+
+// check makes sure that every node is within the data and every arc leads to
+// an earlier node, so there are no cycles, and that the outputs can't add up
+// to an ordinal of keys or more. Nodes are read in the order they were
+// written, children first.
+func (f fst) check(keys uint32) error {
+	var nodes, counts []uint32 // offset and number of keys of each node so far
+	for offset := uint64(0); offset < uint64(len(f.data)); {
+		left := uint64(len(f.data)) - offset
+		if left < fstHeaderSize {
+			return fmt.Errorf("node at %d is cut off", offset)
+		}
+		node := uint32(offset)
+		arcs := uint64(f.arcs(node))
+		if arcs > (left-fstHeaderSize)/fstArcSize {
+			return fmt.Errorf("node at %d is cut off", offset)
+		}
+
+		count := f.uint32(node + 4)
+		if f.final(node) && count == 0 {
+			return fmt.Errorf("node at %d is final without counting its key", offset)
+		}
+		for i := 0; i < int(arcs); i++ {
+			_, target, output := f.arc(node, i)
+			j := sort.Search(len(nodes), func(j int) bool { return nodes[j] >= target })
+			if j == len(nodes) || nodes[j] != target {
+				return fmt.Errorf("arc %d of node at %d doesn't lead to an earlier node", i, offset)
+			}
+			if uint64(output)+uint64(counts[j]) > uint64(count) {
+				return fmt.Errorf("arc %d of node at %d counts more keys than its node", i, offset)
+			}
+		}
+
+		nodes = append(nodes, node)
+		counts = append(counts, count)
+		offset += fstHeaderSize + arcs*fstArcSize
+	}
+
+	i := sort.Search(len(nodes), func(i int) bool { return nodes[i] >= f.root })
+	if i == len(nodes) || nodes[i] != f.root {
+		return fmt.Errorf("root %d isn't a node", f.root)
+	}
+	if counts[i] > keys {
+		return fmt.Errorf("%d keys, but only %d have postings", counts[i], keys)
+	}
+	return nil
+}
+
+// An fstBuilder builds an fst from keys added in sorted order, using Daciuk's
+// algorithm: the path for the previous key is kept unfinished, and each node on
+// it is written (or replaced by an identical node that was already written)
+// once no later key can pass through it.
+type fstBuilder struct {
+	data     []byte
+	register map[string]uint32 // encoded node -> offset
+	path     []*fstPending
+	previous string
+	started  bool
+}
+
+type fstPending struct {
+	final bool
+	arcs  []fstPendingArc
+}
+
+type fstPendingArc struct {
+	label  rune
+	target uint32 // only set once the target has been written
+	count  uint32 // keys through the arc, also set when the target is written
+}
+
+func newFSTBuilder() *fstBuilder {
+	return &fstBuilder{
+		register: make(map[string]uint32),
+		path:     []*fstPending{{}},
+	}
+}
+
+// Add adds the next key, which has to sort after every key added so far.
+func (builder *fstBuilder) Add(key string) error {
+	if builder.started && key <= builder.previous {
+		return fmt.Errorf("fst keys out of order: %q after %q", key, builder.previous)
+	}
+
+	// the nodes past the prefix shared with the previous key are finished
+	common := 0
+	for common < len(key) && common < len(builder.previous) && key[common] == builder.previous[common] {
+		common++
+	}
+	// don't split a character in two
+	for common > 0 && common < len(key) && !utf8.RuneStart(key[common]) {
+		common--
+	}
+	depth := utf8.RuneCountInString(key[:common])
+	builder.freeze(depth)
+
+	for _, char := range key[common:] {
+		parent := builder.path[len(builder.path)-1]
+		parent.arcs = append(parent.arcs, fstPendingArc{label: char})
+		builder.path = append(builder.path, &fstPending{})
+	}
+	builder.path[len(builder.path)-1].final = true
+
+	builder.previous, builder.started = key, true
+	return nil
+}
+
+// Finish writes the remaining nodes and returns the transducer.
+func (builder *fstBuilder) Finish() fst {
+	builder.freeze(0)
+	root, _ := builder.write(builder.path[0])
+	return fst{data: builder.data, root: root}
+}
+
+// freeze writes the nodes on the path deeper than depth.
+func (builder *fstBuilder) freeze(depth int) {
+	for i := len(builder.path) - 1; i > depth; i-- {
+		offset, count := builder.write(builder.path[i])
+		parent := builder.path[i-1]
+		parent.arcs[len(parent.arcs)-1].target = offset
+		parent.arcs[len(parent.arcs)-1].count = count
+	}
+	builder.path = builder.path[:depth+1]
+}
+
+// write encodes a node, reusing an identical node if there is one, and returns
+// its offset and the number of keys from it on.
+func (builder *fstBuilder) write(node *fstPending) (uint32, uint32) {
+	encoded := make([]byte, fstHeaderSize+len(node.arcs)*fstArcSize)
+
+	flags, count := uint32(0), uint32(0)
+	if node.final {
+		flags, count = 1, 1
+	}
+	for i, arc := range node.arcs {
+		offset := fstHeaderSize + i*fstArcSize
+		binary.LittleEndian.PutUint32(encoded[offset:], uint32(arc.label))
+		binary.LittleEndian.PutUint32(encoded[offset+4:], arc.target)
+		binary.LittleEndian.PutUint32(encoded[offset+8:], count)
+		count += arc.count
+	}
+	binary.LittleEndian.PutUint32(encoded[0:], flags)
+	binary.LittleEndian.PutUint32(encoded[4:], count)
+	binary.LittleEndian.PutUint32(encoded[8:], uint32(len(node.arcs)))
+
+	if offset, found := builder.register[string(encoded)]; found {
+		return offset, count
+	}
+
+	offset := uint32(len(builder.data))
+	builder.data = append(builder.data, encoded...)
+	builder.register[string(encoded)] = offset
+	return offset, count
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestFST_Ordinals(t *testing.T) {
+	keys := []string{"", "a", "ab", "abc", "b", "bc", "quebec city", "québec", "xbc"}
+
+	builder := newFSTBuilder()
+	for _, key := range keys {
+		if err := builder.Add(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transducer := builder.Finish()
+
+	for ordinal, key := range keys {
+		node, actual, found := transducer.walk(key)
+		if !found || !transducer.final(node) || actual != uint32(ordinal) {
+			t.Errorf("%q: %#v != %#v", key, actual, ordinal)
+		}
+	}
+
+	for _, key := range []string{"abcd", "c", "québe"} {
+		if node, _, found := transducer.walk(key); found && transducer.final(node) {
+			t.Errorf("%q should not be a key", key)
+		}
+	}
+}
+
+func TestFST_SharesSuffixes(t *testing.T) {
+	builder := newFSTBuilder()
+	builder.Add("abcville")
+	builder.Add("xyzville")
+	transducer := builder.Finish()
+
+	// root, "a", "ab", "x", "xy", then the 6 shared from "abc" and "xyz" on
+	nodes := 0
+	for offset := 0; offset < len(transducer.data); offset += fstHeaderSize + transducer.arcs(uint32(offset))*fstArcSize {
+		nodes++
+	}
+	if nodes != 11 {
+		t.Errorf("%#v != %#v", nodes, 11)
+	}
+}
+
+func TestFSTBuilder_Order(t *testing.T) {
+	builder := newFSTBuilder()
+	builder.Add("b")
+	if err := builder.Add("a"); err == nil {
+		t.Errorf("keys out of order should fail")
+	}
+	if err := builder.Add("b"); err == nil {
+		t.Errorf("duplicate keys should fail")
+	}
+}
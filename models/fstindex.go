@@ -0,0 +1,724 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// An FSTIndex is an immutable index for read-mostly deployments. It's built
+// once from a loaded Trie with WriteFSTIndex, and OpenFSTIndex memory-maps the
+// file, so startup doesn't depend on the size of the data (unless the file is
+// verified) and processes serving the same file share its memory. Every query
+// runs directly over the mapped bytes.
+//
+// The file holds the locations (as fixed size records), the synonyms it was
+// built with, and a transducer from every key (each name, and each name from
+// every later word, like the Trie) to the postings for that key. A second
+// transducer holds phonetic keys, if they were enabled when it was built.
+type FSTIndex struct {
+	data     []byte
+	unmap    func() error
+	sections [numSections][]byte
+	synonyms *Synonyms
+
+	names, phonetic fst
+	hasPhonetic     bool
+	slack           int
+
+	// indexes refs by where they are, built on the first area lookup
+	grid     *GeoGrid
+	gridOnce sync.Once
+}
+
+// The file starts with a fixed header, then a table of sections.
+//
+//	magic         [8]byte
+//	flags         uint32   fstIndexPhonetic
+//	name root     uint32   offset of the root node in sectionNameFST
+//	phonetic root uint32
+//	slack         uint32   like the Trie's, for the name keys
+//	sections      [numSections]{offset, length uint64}
+const (
+	fstIndexMagic      = "GEOFST01"
+	fstIndexHeaderSize = 24
+	fstIndexPhonetic   = 1
+)
+
+const (
+	sectionStrings          = iota // interned strings: count, count+1 offsets, bytes
+	sectionSynonyms                // in the format of a synonyms file
+	sectionLocations               // locationRecordSize bytes per location
+	sectionNames                   // every name, packed
+	sectionIDs                     // refs sorted by ID
+	sectionNameFST                 // keys to ordinals
+	sectionNamePostings            // ordinals to refs: count, count+1 offsets, refs, tokens
+	sectionPhoneticFST             // phonetic keys to ordinals
+	sectionPhoneticPostings        // ordinals to refs
+	numSections
+)
+
+// Each location is a fixed size record:
+//
+//	id uint32, name offset uint32, name length uint16, days uint16,
+//	lat float32, long float32, then symbols for the country, region,
+//	display name suffix and feature code, and population (uint32 each)
+const locationRecordSize = 40
+
+var errNotFSTIndex = errors.New("not an index file")
+
+// WriteFSTIndex writes an index of the locations in tree. Keys are built from
+// the location names with the tree's synonyms, and phonetic keys are included
+// if they're enabled for the tree. Locations that have been removed are left
+// out.
+func WriteFSTIndex(file io.Writer, tree *Trie) error {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	var sections [numSections][]byte
+	table := tree.locations
+
+	// number the locations that are left from 0
+	refs := []LocationRef{}
+	for ref := LocationRef(0); int(ref) < table.Len(); ref++ {
+		if !table.Deleted(ref) {
+			refs = append(refs, ref)
+		}
+	}
+
+	pool := NewStringPool()
+	var locations, names []byte
+	for _, ref := range refs {
+		location := table.Location(ref)
+		suffix := table.strings.String(table.suffix[ref])
+
+		var record [locationRecordSize]byte
+		binary.LittleEndian.PutUint32(record[0:], table.ids[ref])
+		binary.LittleEndian.PutUint32(record[4:], uint32(len(names)))
+		binary.LittleEndian.PutUint16(record[8:], table.nameLen[ref])
+		binary.LittleEndian.PutUint16(record[10:], table.days[ref])
+		binary.LittleEndian.PutUint32(record[12:], math.Float32bits(table.lat[ref]))
+		binary.LittleEndian.PutUint32(record[16:], math.Float32bits(table.long[ref]))
+		binary.LittleEndian.PutUint32(record[20:], pool.Intern(location.Country))
+		binary.LittleEndian.PutUint32(record[24:], pool.Intern(location.Region))
+		binary.LittleEndian.PutUint32(record[28:], pool.Intern(suffix))
+		binary.LittleEndian.PutUint32(record[32:], pool.Intern(location.FeatureCode))
+		binary.LittleEndian.PutUint32(record[36:], table.pop[ref])
+		locations = append(locations, record[:]...)
+		names = append(names, location.Name...)
+	}
+	sections[sectionLocations] = locations
+	sections[sectionNames] = names
+
+	sections[sectionStrings] = appendUint32(nil, uint32(pool.Len()))
+	var values []byte
+	for symbol := 0; symbol < pool.Len(); symbol++ {
+		sections[sectionStrings] = appendUint32(sections[sectionStrings], uint32(len(values)))
+		values = append(values, pool.String(uint32(symbol))...)
+	}
+	sections[sectionStrings] = appendUint32(sections[sectionStrings], uint32(len(values)))
+	sections[sectionStrings] = append(sections[sectionStrings], values...)
+
+	var synonyms bytes.Buffer
+	if tree.synonyms != nil {
+		if err := tree.synonyms.Write(&synonyms); err != nil {
+			return err
+		}
+	}
+	sections[sectionSynonyms] = synonyms.Bytes()
+
+	ids := make([]uint32, len(refs))
+	for i := range ids {
+		ids[i] = uint32(i)
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return table.ids[refs[ids[i]]] < table.ids[refs[ids[j]]]
+	})
+	for _, ref := range ids {
+		sections[sectionIDs] = appendUint32(sections[sectionIDs], ref)
+	}
+
+	var flags uint32
+	nameKeys, nameRoot, err := buildKeyIndex(tree.synonyms, table, refs, false)
+	if err != nil {
+		return err
+	}
+	sections[sectionNameFST], sections[sectionNamePostings] = nameKeys.data, nameKeys.postings
+
+	phonetic, phoneticRoot := keyIndex{}, uint32(0)
+	if tree.phonetic != nil {
+		flags |= fstIndexPhonetic
+		if phonetic, phoneticRoot, err = buildKeyIndex(tree.synonyms, table, refs, true); err != nil {
+			return err
+		}
+	}
+	sections[sectionPhoneticFST], sections[sectionPhoneticPostings] = phonetic.data, phonetic.postings
+
+	// header and section table, then the sections
+	header := []byte(fstIndexMagic)
+	header = appendUint32(header, flags)
+	header = appendUint32(header, nameRoot)
+	header = appendUint32(header, phoneticRoot)
+	header = appendUint32(header, uint32(nameKeys.slack))
+	offset := uint64(fstIndexHeaderSize + numSections*16)
+	for _, section := range sections {
+		header = appendUint64(header, offset)
+		header = appendUint64(header, uint64(len(section)))
+		offset += uint64(len(section))
+	}
+
+	w := bufio.NewWriter(file)
+	w.Write(header)
+	for _, section := range sections {
+		w.Write(section)
+	}
+	return w.Flush()
+}
+
+// A keyIndex is an encoded transducer and the postings for its ordinals.
+type keyIndex struct {
+	data, postings []byte
+	slack          int
+}
+
+// buildKeyIndex encodes the keys for the names of refs, numbering each
+// location by its position in refs.
+func buildKeyIndex(synonyms *Synonyms, table *LocationTable, refs []LocationRef, phonetic bool) (keyIndex, uint32, error) {
+	postings := make(map[string][]posting)
+	slack := 0
+	for i, ref := range refs {
+		keys := indexKeys(synonyms, table.Name(ref), phonetic)
+		for k, key := range keys {
+			slack = max(slack, utf8.RuneCountInString(key)-len(table.Name(ref)))
+
+			for token, start := range tokenStarts(key) {
+				// like the Trie, words shared by the alternate phonetic key
+				// are only indexed once
+				if k > 0 && isWordSuffix(keys[0], key[start:]) {
+					continue
+				}
+				postings[key[start:]] = append(postings[key[start:]], posting{ref: LocationRef(i), token: uint8(token)})
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(postings))
+	for key := range postings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	builder := newFSTBuilder()
+	var offsets, refsColumn, tokens []byte
+	offsets = appendUint32(offsets, uint32(len(keys)))
+	total := uint32(0)
+	for _, key := range keys {
+		if err := builder.Add(key); err != nil {
+			return keyIndex{}, 0, err
+		}
+		offsets = appendUint32(offsets, total)
+		for _, p := range postings[key] {
+			refsColumn = appendUint32(refsColumn, uint32(p.ref))
+			tokens = append(tokens, p.token)
+			total++
+		}
+	}
+	offsets = appendUint32(offsets, total)
+	transducer := builder.Finish()
+
+	encoded := append(append(offsets, refsColumn...), tokens...)
+	return keyIndex{data: transducer.data, postings: encoded, slack: slack}, transducer.root, nil
+}
+
+// OpenFSTIndex memory-maps an index file written by WriteFSTIndex. Only the
+// header and the section table are read, so a damaged file can still make
+// queries panic: Verify checks the rest. The index has to be closed to unmap
+// it.
+func OpenFSTIndex(path string) (*FSTIndex, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := readFSTIndex(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	index.unmap = unmap
+	return index, nil
+}
+
+// readFSTIndex checks the header of an index file and finds its sections.
+func readFSTIndex(data []byte) (*FSTIndex, error) {
+	if len(data) < fstIndexHeaderSize+numSections*16 || string(data[:8]) != fstIndexMagic {
+		return nil, errNotFSTIndex
+	}
+
+	index := &FSTIndex{data: data, unmap: func() error { return nil }}
+	for i := range index.sections {
+		entry := data[fstIndexHeaderSize+i*16:]
+		offset, length := binary.LittleEndian.Uint64(entry), binary.LittleEndian.Uint64(entry[8:])
+		if offset > uint64(len(data)) || length > uint64(len(data))-offset {
+			return nil, errNotFSTIndex
+		}
+		index.sections[i] = data[offset : offset+length]
+	}
+	if len(index.sections[sectionLocations])%locationRecordSize != 0 {
+		return nil, errNotFSTIndex
+	}
+
+	flags := binary.LittleEndian.Uint32(data[8:])
+	index.names = fst{data: index.sections[sectionNameFST], root: binary.LittleEndian.Uint32(data[12:])}
+	index.phonetic = fst{data: index.sections[sectionPhoneticFST], root: binary.LittleEndian.Uint32(data[16:])}
+	index.hasPhonetic = flags&fstIndexPhonetic != 0
+	index.slack = int(binary.LittleEndian.Uint32(data[20:]))
+
+	index.synonyms = NewSynonyms()
+	if err := index.synonyms.Read(bytes.NewReader(index.sections[sectionSynonyms])); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Verify checks every offset in the index, so that a damaged file is an error
+// here rather than a panic in a query. It reads the whole file.
+func (index *FSTIndex) Verify() error {
+	if err := index.check(); err != nil {
+		return fmt.Errorf("damaged index file: %s", err)
+	}
+	return nil
+}
+
+// check makes sure that everything the sections point to is in them.
+func (index *FSTIndex) check() error {
+	symbols, err := checkOffsets(index.sections[sectionStrings])
+	if err != nil {
+		return fmt.Errorf("strings: %s", err)
+	}
+
+	names := uint64(len(index.sections[sectionNames]))
+	for ref := LocationRef(0); int(ref) < index.Len(); ref++ {
+		record := index.record(ref)
+		if uint64(binary.LittleEndian.Uint32(record[4:]))+uint64(binary.LittleEndian.Uint16(record[8:])) > names {
+			return fmt.Errorf("location %d: name out of range", ref)
+		}
+		for _, symbol := range [][]byte{record[20:], record[24:], record[28:], record[32:]} {
+			if binary.LittleEndian.Uint32(symbol) >= symbols {
+				return fmt.Errorf("location %d: string out of range", ref)
+			}
+		}
+	}
+
+	ids := index.sections[sectionIDs]
+	if len(ids) != 4*index.Len() {
+		return fmt.Errorf("ids: %d bytes for %d locations", len(ids), index.Len())
+	}
+	if err := index.checkRefs(ids); err != nil {
+		return fmt.Errorf("ids: %s", err)
+	}
+
+	keys, err := index.checkPostings(sectionNamePostings)
+	if err != nil {
+		return fmt.Errorf("name postings: %s", err)
+	}
+	if err := index.names.check(keys); err != nil {
+		return fmt.Errorf("names: %s", err)
+	}
+	if index.hasPhonetic {
+		keys, err := index.checkPostings(sectionPhoneticPostings)
+		if err != nil {
+			return fmt.Errorf("phonetic postings: %s", err)
+		}
+		if err := index.phonetic.check(keys); err != nil {
+			return fmt.Errorf("phonetic keys: %s", err)
+		}
+	}
+	return nil
+}
+
+// checkOffsets checks a section that starts with a count and count+1 offsets
+// into the rest of it, like the strings, and returns the count.
+func checkOffsets(section []byte) (uint32, error) {
+	if len(section) < 4 {
+		return 0, errors.New("no count")
+	}
+	count := binary.LittleEndian.Uint32(section)
+	if uint64(count)+1 > uint64(len(section)-4)/4 {
+		return 0, fmt.Errorf("%d offsets don't fit", count+1)
+	}
+
+	rest := uint64(len(section)) - 4 - 4*(uint64(count)+1)
+	previous := uint32(0)
+	for i := uint32(0); i <= count; i++ {
+		offset := binary.LittleEndian.Uint32(section[4+4*i:])
+		if offset < previous || uint64(offset) > rest {
+			return 0, fmt.Errorf("offset %d out of range", i)
+		}
+		previous = offset
+	}
+	return count, nil
+}
+
+// checkPostings checks the offsets of a postings section, that it's as long
+// as they say, and that every posting is of a location. It returns the number
+// of keys it has postings for.
+func (index *FSTIndex) checkPostings(section int) (uint32, error) {
+	data := index.sections[section]
+	count, err := checkOffsets(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// a ref and a token for each posting
+	refs := data[4+4*(uint64(count)+1):]
+	total := binary.LittleEndian.Uint32(data[4+4*count:])
+	if uint64(len(refs)) != 5*uint64(total) {
+		return 0, fmt.Errorf("%d bytes for %d postings", len(refs), total)
+	}
+	return count, index.checkRefs(refs[:4*total])
+}
+
+// checkRefs checks that each of a column of refs is of a location.
+func (index *FSTIndex) checkRefs(refs []byte) error {
+	for i := 0; i+4 <= len(refs); i += 4 {
+		if int(binary.LittleEndian.Uint32(refs[i:])) >= index.Len() {
+			return fmt.Errorf("ref %d out of range", i/4)
+		}
+	}
+	return nil
+}
+
+// Close unmaps the file. The index can't be used after that.
+func (index *FSTIndex) Close() error {
+	return index.unmap()
+}
+
+func (index *FSTIndex) Synonyms() *Synonyms {
+	return index.synonyms
+}
+
+// Len returns the number of locations in the index.
+func (index *FSTIndex) Len() int {
+	return len(index.sections[sectionLocations]) / locationRecordSize
+}
+
+// Name returns the name of a location without materializing the rest of it.
+func (index *FSTIndex) Name(ref LocationRef) string {
+	record := index.record(ref)
+	offset := binary.LittleEndian.Uint32(record[4:])
+	return string(index.sections[sectionNames][offset : offset+uint32(binary.LittleEndian.Uint16(record[8:]))])
+}
+
+// Location materializes the location stored at ref.
+func (index *FSTIndex) Location(ref LocationRef) Location {
+	record := index.record(ref)
+	lat, long := index.coordinates(ref)
+	location := Location{
+		Name:        index.Name(ref),
+		Lat:         lat,
+		Long:        long,
+		Country:     index.string(binary.LittleEndian.Uint32(record[20:])),
+		Region:      index.string(binary.LittleEndian.Uint32(record[24:])),
+		FeatureCode: index.string(binary.LittleEndian.Uint32(record[32:])),
+		Population:  int64(binary.LittleEndian.Uint32(record[36:])),
+	}
+
+	if id := binary.LittleEndian.Uint32(record[0:]); id != 0 {
+		location.ID = strconv.FormatUint(uint64(id), 10)
+	}
+
+	location.ModifiedAt = formatDays(binary.LittleEndian.Uint16(record[10:]))
+
+	suffix := index.string(binary.LittleEndian.Uint32(record[28:]))
+	if strings.HasPrefix(suffix, "\x00") {
+		location.DisplayName = suffix[1:]
+	} else {
+		location.DisplayName = location.Name + suffix
+	}
+
+	return location
+}
+
+// coordinates returns where a location is without materializing the rest of it.
+func (index *FSTIndex) coordinates(ref LocationRef) (lat, long float64) {
+	record := index.record(ref)
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(record[12:]))),
+		float64(math.Float32frombits(binary.LittleEndian.Uint32(record[16:])))
+}
+
+func (index *FSTIndex) record(ref LocationRef) []byte {
+	return index.sections[sectionLocations][int(ref)*locationRecordSize:]
+}
+
+func (index *FSTIndex) string(symbol uint32) string {
+	section := index.sections[sectionStrings]
+	offsets := section[4:]
+	values := section[4+4*(binary.LittleEndian.Uint32(section)+1):]
+	start, end := binary.LittleEndian.Uint32(offsets[4*symbol:]), binary.LittleEndian.Uint32(offsets[4*symbol+4:])
+	return string(values[start:end])
+}
+
+// Get returns the location with a GeoNames id.
+func (index *FSTIndex) Get(id string) (Location, bool) {
+	key, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || key == 0 {
+		return Location{}, false
+	}
+
+	ids := index.sections[sectionIDs]
+	refAt := func(i int) LocationRef {
+		return LocationRef(binary.LittleEndian.Uint32(ids[4*i:]))
+	}
+	i := sort.Search(len(ids)/4, func(i int) bool {
+		return binary.LittleEndian.Uint32(index.record(refAt(i))) >= uint32(key)
+	})
+	if i < len(ids)/4 && binary.LittleEndian.Uint32(index.record(refAt(i))) == uint32(key) {
+		return index.Location(refAt(i)), true
+	}
+	return Location{}, false
+}
+
+// Find checks if a key is present in the index.
+func (index *FSTIndex) Find(key string) bool {
+	node, ordinal, found := index.names.walk(index.synonyms.Normalize(strings.ToLower(key)))
+	if !found || !index.names.final(node) {
+		return false
+	}
+
+	for _, result := range index.postings(sectionNamePostings, ordinal) {
+		if result.token == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// FindMatches finds <limit> locations with names that start with <prefix>.
+func (index *FSTIndex) FindMatches(prefix string, limit int) []Location {
+	locations := []Location{}
+	for _, result := range index.search(prefix, limit, false, false) {
+		locations = append(locations, index.Location(result.ref))
+	}
+	return locations
+}
+
+func (index *FSTIndex) FindTokenMatches(prefix string, limit int) []Match {
+	matches := []Match{}
+	for _, result := range index.search(prefix, limit, true, false) {
+		matches = append(matches, Match{
+			Location: index.Location(result.ref),
+			Token:    int(result.token),
+		})
+	}
+	return matches
+}
+
+func (index *FSTIndex) FindPhoneticMatches(prefix string, limit int) []Match {
+	matches := []Match{}
+	if !index.hasPhonetic {
+		return matches
+	}
+	for _, result := range index.search(prefix, limit, true, true) {
+		matches = append(matches, Match{
+			Location: index.Location(result.ref),
+			Token:    int(result.token),
+			Phonetic: true,
+		})
+	}
+	return matches
+}
+
+// Walk works like Trie.Walk.
+func (index *FSTIndex) Walk(ctx context.Context, prefix string, visit Visitor) error {
+	return index.find(ctx, prefix, true, false, func(result posting, depth int) bool {
+		match := Match{Location: index.Location(result.ref), Token: int(result.token)}
+		return visit(match, depth-index.slack)
+	})
+}
+
+// FindInArea finds the locations in area with a word starting with prefix, by
+// checking each location in the cells of a grid that it overlaps. The grid
+// isn't stored in the file, so it's built on the first call.
+func (index *FSTIndex) FindInArea(prefix string, area Area, limit int) ([]Match, bool) {
+	index.gridOnce.Do(func() {
+		index.grid = NewGeoGrid()
+		for ref := LocationRef(0); int(ref) < index.Len(); ref++ {
+			lat, long := index.coordinates(ref)
+			index.grid.Insert(ref, lat, long)
+		}
+	})
+
+	candidates, found := index.grid.Find(area, limit)
+	if !found {
+		return nil, false
+	}
+
+	locations := []Location{}
+	for _, ref := range candidates {
+		if lat, long := index.coordinates(ref); area.Contains(lat, long) {
+			locations = append(locations, index.Location(ref))
+		}
+	}
+	return areaMatches(index.synonyms, locations, prefix), true
+}
+
+// search works like Trie.search, visiting keys and postings in the same order,
+// so it finds the same matches for any limit.
+func (index *FSTIndex) search(prefix string, limit int, tokens, phonetic bool) []posting {
+	results := []posting{}
+	index.find(context.Background(), prefix, tokens, phonetic, func(result posting, depth int) bool {
+		results = append(results, result)
+		return limit <= 0 || len(results) < limit
+	})
+	return results
+}
+
+// find works like Trie.find.
+func (index *FSTIndex) find(ctx context.Context, prefix string, tokens, phonetic bool, visit func(result posting, depth int) bool) error {
+	keys, section := index.names, sectionNamePostings
+	if phonetic {
+		keys, section = index.phonetic, sectionPhoneticPostings
+	}
+
+	variants := searchKeys(index.synonyms, prefix, phonetic)
+	roots := []fstRoot{}
+	for _, variant := range variants {
+		if node, ordinal, found := keys.walk(variant); found {
+			roots = append(roots, fstRoot{node: node, ordinal: ordinal, depth: utf8.RuneCountInString(variant)})
+		}
+	}
+
+	seen := make(map[LocationRef]bool)
+	return index.traverse(ctx, keys, section, roots, func(result posting, depth int) bool {
+		if (result.token > 0 && !tokens) || seen[result.ref] {
+			return true
+		}
+		seen[result.ref] = true
+		if tokens && (result.token > 0 || len(variants) > 1) {
+			result.token = firstToken(indexKeys(index.synonyms, index.Name(result.ref), phonetic), variants, result.token)
+		}
+
+		return visit(result, depth)
+	})
+}
+
+// An fstRoot is a node to visit the postings below, with its ordinal and the
+// length of the key that leads to it.
+type fstRoot struct {
+	node, ordinal uint32
+	depth         int
+}
+
+// traverse visits the postings below each root a level at a time, in the same
+// order as Trie.traverse.
+func (index *FSTIndex) traverse(ctx context.Context, keys fst, section int, roots []fstRoot, visit func(result posting, depth int) bool) error {
+	type state struct{ node, ordinal uint32 }
+	levels := make([][]state, len(roots))
+	for i, root := range roots {
+		levels[i] = []state{{root.node, root.ordinal}}
+	}
+
+	for {
+		next := -1
+		for i := range roots {
+			if len(levels[i]) > 0 && (next < 0 || roots[i].depth < roots[next].depth) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		children := []state{}
+		for _, current := range levels[next] {
+			if keys.final(current.node) {
+				for _, result := range index.postings(section, current.ordinal) {
+					if !visit(result, roots[next].depth) {
+						return nil
+					}
+				}
+			}
+
+			for i := 0; i < keys.arcs(current.node); i++ {
+				_, target, output := keys.arc(current.node, i)
+				children = append(children, state{target, current.ordinal + output})
+			}
+		}
+		levels[next] = children
+		roots[next].depth++
+	}
+}
+
+// postings returns the postings for an ordinal.
+func (index *FSTIndex) postings(section int, ordinal uint32) []posting {
+	data := index.sections[section]
+	count := binary.LittleEndian.Uint32(data)
+	offsets := data[4:]
+	refs := offsets[4*(count+1):]
+	tokens := refs[4*binary.LittleEndian.Uint32(offsets[4*count:]):]
+
+	start, end := binary.LittleEndian.Uint32(offsets[4*ordinal:]), binary.LittleEndian.Uint32(offsets[4*ordinal+4:])
+	results := make([]posting, 0, end-start)
+	for p := start; p < end; p++ {
+		results = append(results, posting{
+			ref:   LocationRef(binary.LittleEndian.Uint32(refs[4*p:])),
+			token: tokens[p],
+		})
+	}
+	return results
+}
+
+// Corrections works like Trie.Corrections, walking the transducer instead.
+func (index *FSTIndex) Corrections(text string, limit int, allow func(Location) bool) []string {
+	query := []rune(index.synonyms.Normalize(strings.ToLower(strings.TrimSpace(text))))
+	maxDistance := maxEditDistance(len(query))
+	if maxDistance == 0 {
+		return []string{}
+	}
+
+	keys := index.names
+	walk := &correctionWalk{
+		query:       query,
+		maxDistance: maxDistance,
+		children: func(node keyNode, visit func(char rune, child keyNode)) {
+			for i := 0; i < keys.arcs(node.node); i++ {
+				label, target, output := keys.arc(node.node, i)
+				visit(label, keyNode{node: target, ordinal: node.ordinal + output})
+			}
+		},
+		below: func(node keyNode, visit func(result posting, depth int) bool) {
+			index.traverse(context.Background(), keys, sectionNamePostings, []fstRoot{{node: node.node, ordinal: node.ordinal}}, visit)
+		},
+	}
+	distances := walk.distances(keyNode{node: keys.root})
+
+	scores := make(map[string]float64)
+	for ref, distance := range distances {
+		if location := index.Location(ref); allow == nil || allow(location) {
+			addCorrection(scores, location, distance)
+		}
+	}
+	return rankCorrections(scores, limit)
+}
+
+func appendUint32(data []byte, value uint32) []byte {
+	return binary.LittleEndian.AppendUint32(data, value)
+}
+
+func appendUint64(data []byte, value uint64) []byte {
+	return binary.LittleEndian.AppendUint64(data, value)
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFSTIndex writes an index of tree to a temporary file and opens it.
+func writeFSTIndex(t *testing.T, tree *Trie) *FSTIndex {
+	path := filepath.Join(t.TempDir(), "index.fst")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFSTIndex(f, tree); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	index, err := OpenFSTIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+	return index
+}
+
+// walk lists the matches an index walks through, with their minimum lengths.
+func walk(index Index, prefix string) []string {
+	visits := []string{}
+	index.Walk(context.Background(), prefix, func(match Match, minLength int) bool {
+		visits = append(visits, fmt.Sprint(match.ID, match.Token, minLength))
+		return true
+	})
+	return visits
+}
+
+func TestFSTIndex(t *testing.T) {
+	f, err := os.Open(testDataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tree, err := LoadTrie(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.EnablePhonetic()
+	// removed locations are left out of the index
+	tree.Remove("6167865") // Toronto
+
+	index := writeFSTIndex(t, tree)
+
+	if index.Len() != tree.Locations().Len()-1 {
+		t.Errorf("%#v != %#v", index.Len(), tree.Locations().Len()-1)
+	}
+
+	queries := []string{"", "a", "mont", "Montréal", "new y", "st. j", "york", "ville", "toronto", "shyenne", "albuk", "zzz"}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			for _, limit := range []int{0, 3} {
+				if actual, expected := index.FindTokenMatches(query, limit), tree.FindTokenMatches(query, limit); !reflect.DeepEqual(actual, expected) {
+					t.Errorf("token matches, limit %d: %d != %d", limit, len(actual), len(expected))
+				}
+				if actual, expected := index.FindPhoneticMatches(query, limit), tree.FindPhoneticMatches(query, limit); !reflect.DeepEqual(actual, expected) {
+					t.Errorf("phonetic matches, limit %d: %d != %d", limit, len(actual), len(expected))
+				}
+				if actual, expected := index.FindMatches(query, limit), tree.FindMatches(query, limit); !reflect.DeepEqual(actual, expected) {
+					t.Errorf("matches, limit %d: %d != %d", limit, len(actual), len(expected))
+				}
+			}
+
+			if actual, expected := walk(index, query), walk(tree, query); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("walk: %d != %d", len(actual), len(expected))
+			}
+
+			if actual, expected := index.Find(query), tree.Find(query); actual != expected {
+				t.Errorf("find: %#v != %#v", actual, expected)
+			}
+		})
+	}
+
+	for _, query := range []string{"torotno", "montreall", "new yrok", "vancuver"} {
+		if actual, expected := index.Corrections(query, 3, nil), tree.Corrections(query, 3, nil); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("corrections for %q: %#v != %#v", query, actual, expected)
+		}
+	}
+
+	for _, id := range []string{"6077243", "5128581", "6167865", "1", "nope"} {
+		actual, actualFound := index.Get(id)
+		expected, expectedFound := tree.Get(id)
+		if actual != expected || actualFound != expectedFound {
+			t.Errorf("get %s: %#v != %#v", id, actual, expected)
+		}
+	}
+
+	if !reflect.DeepEqual(index.Synonyms(), tree.Synonyms()) {
+		t.Errorf("%#v != %#v", index.Synonyms(), tree.Synonyms())
+	}
+}
+
+func TestOpenFSTIndex_NotAnIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cities.tsv")
+	if err := os.WriteFile(path, []byte(strings.Repeat("id\tname\n", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFSTIndex(path); err != errNotFSTIndex {
+		t.Errorf("%#v != %#v", err, errNotFSTIndex)
+	}
+}
+
+func TestReadFSTIndex_Damaged(t *testing.T) {
+	tree := NewTrie()
+	tree.EnablePhonetic()
+	tree.Insert("Montreal", Location{ID: "6077243", Name: "Montreal", Country: "CA", Region: "10"})
+	tree.Insert("Montmagny", Location{ID: "6077246", Name: "Montmagny", Country: "CA", Region: "10"})
+	tree.Insert("St. Louis", Location{ID: "4407066", Name: "St. Louis", Country: "US", Region: "MO"})
+
+	var buffer bytes.Buffer
+	if err := WriteFSTIndex(&buffer, tree); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	if index, err := readFSTIndex(data); err != nil {
+		t.Fatal(err)
+	} else if err := index.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// whatever byte is damaged, the index either doesn't open or verify, or
+	// can be searched without panicking
+	damaged := make([]byte, len(data))
+	for i := range data {
+		copy(damaged, data)
+		damaged[i] ^= 0xff
+		index, err := readFSTIndex(damaged)
+		if err != nil {
+			continue
+		}
+		if err := index.Verify(); err != nil {
+			continue
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("byte %d: %v", i, r)
+				}
+			}()
+			for _, query := range []string{"", "mont", "saint l", "montrel"} {
+				index.FindTokenMatches(query, 0)
+				index.FindPhoneticMatches(query, 0)
+				index.Corrections(query, 3, nil)
+				walk(index, query)
+			}
+			index.Get("6077243")
+		}()
+	}
+}
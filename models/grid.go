@@ -0,0 +1,90 @@
+package models
+
+import "math"
+
+// A GeoGrid indexes locations by where they are, in cells of gridDegrees of
+// latitude and longitude, so the locations in a small area can be found without
+// looking at the rest.
+type GeoGrid struct {
+	cells map[uint32][]LocationRef
+}
+
+// Cells are about 110 km tall, and narrower further from the equator, so a city
+// or a map of one is a few cells.
+const gridDegrees = 1.0
+
+const (
+	gridRows    = int(180 / gridDegrees)
+	gridColumns = int(360 / gridDegrees)
+)
+
+func NewGeoGrid() *GeoGrid {
+	return &GeoGrid{cells: make(map[uint32][]LocationRef)}
+}
+
+// Insert adds a location to the cell it's in.
+func (grid *GeoGrid) Insert(ref LocationRef, lat, long float64) {
+	cell := gridCell(gridRow(lat), gridColumn(long))
+	grid.cells[cell] = append(grid.cells[cell], ref)
+}
+
+// Find returns the locations in the cells that area's bounds overlap, which
+// can include some just outside it. If there are more than limit, it gives up
+// and returns false.
+func (grid *GeoGrid) Find(area Area, limit int) ([]LocationRef, bool) {
+	var cells []uint32
+	for _, box := range area.Bounds() {
+		minRow, maxRow := gridRow(box.MinLat), gridRow(box.MaxLat)
+		minColumn, maxColumn := gridColumn(box.MinLong), gridColumn(box.MaxLong)
+
+		// a large area has more cells than there are occupied ones to check
+		if (maxRow-minRow+1)*(maxColumn-minColumn+1) > len(grid.cells) {
+			for cell := range grid.cells {
+				row, column := int(cell)/gridColumns, int(cell)%gridColumns
+				if row >= minRow && row <= maxRow && column >= minColumn && column <= maxColumn {
+					cells = append(cells, cell)
+				}
+			}
+			continue
+		}
+
+		for row := minRow; row <= maxRow; row++ {
+			for column := minColumn; column <= maxColumn; column++ {
+				if _, found := grid.cells[gridCell(row, column)]; found {
+					cells = append(cells, gridCell(row, column))
+				}
+			}
+		}
+	}
+
+	n := 0
+	for _, cell := range cells {
+		if n += len(grid.cells[cell]); n > limit {
+			return nil, false
+		}
+	}
+
+	refs := make([]LocationRef, 0, n)
+	for _, cell := range cells {
+		refs = append(refs, grid.cells[cell]...)
+	}
+	return refs, true
+}
+
+func gridRow(lat float64) int {
+	return clampCell(int(math.Floor((lat+90)/gridDegrees)), gridRows)
+}
+
+func gridColumn(long float64) int {
+	return clampCell(int(math.Floor((long+180)/gridDegrees)), gridColumns)
+}
+
+// clampCell keeps the poles and the antimeridian (180° east, the same as 180°
+// west) in the last row and column.
+func clampCell(i, n int) int {
+	return min(max(i, 0), n-1)
+}
+
+func gridCell(row, column int) uint32 {
+	return uint32(row*gridColumns + column)
+}
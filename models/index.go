@@ -0,0 +1,301 @@
+package models
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// An Index finds locations by name. The Trie is the one to serve from (or the
+// FSTIndex, when the data doesn't change), and the LinearIndex is a reference
+// to test them against.
+//
+// With a limit, which matches are returned is up to the implementation (the
+// Trie returns the shortest names first). With no limit (0), every
+// implementation returns the same matches, in any order.
+type Index interface {
+	// FindTokenMatches finds locations with any word that starts with prefix.
+	FindTokenMatches(prefix string, limit int) []Match
+
+	// FindPhoneticMatches finds locations with any word that sounds like the
+	// start of prefix, if phonetic matching is enabled.
+	FindPhoneticMatches(prefix string, limit int) []Match
+
+	// Walk calls visit with each location that FindTokenMatches finds with no
+	// limit, in order of the length of the key it was found under (the name
+	// from the word that matched), until visit returns false or ctx is done.
+	Walk(ctx context.Context, prefix string, visit Visitor) error
+
+	// FindInArea finds the locations in area that FindTokenMatches would,
+	// by checking every location in it. If there are more than limit
+	// locations around the area, it gives up and returns false, since walking
+	// the matches for the prefix is likely to be quicker.
+	FindInArea(prefix string, area Area, limit int) ([]Match, bool)
+
+	// Get returns the current location with a GeoNames id.
+	Get(id string) (Location, bool)
+
+	// Corrections finds names that text is probably a misspelling of.
+	Corrections(text string, limit int, allow func(Location) bool) []string
+
+	// Synonyms returns the dictionary used to normalize names and queries.
+	Synonyms() *Synonyms
+}
+
+// A Visitor is called with each match found by a walk, and the shortest that
+// the name of this or any later match in the walk can be, in bytes. It returns
+// false to stop the walk, e.g. once that's too long for any later match to make
+// the results.
+type Visitor func(match Match, minLength int) bool
+
+var (
+	_ Index = (*Trie)(nil)
+	_ Index = (*LinearIndex)(nil)
+	_ Index = (*FSTIndex)(nil)
+)
+
+// A LinearIndex checks every location on every query. It's far too slow to
+// serve from, but simple enough to be obviously correct, which is what the
+// other implementations are tested against.
+type LinearIndex struct {
+	entries  []linearEntry
+	synonyms *Synonyms
+	phonetic bool
+	slack    int // like the Trie's
+}
+
+type linearEntry struct {
+	key      string // normalized when inserted
+	location Location
+}
+
+func NewLinearIndex() *LinearIndex {
+	return &LinearIndex{synonyms: DefaultSynonyms()}
+}
+
+func (index *LinearIndex) Synonyms() *Synonyms {
+	return index.synonyms
+}
+
+// SetSynonyms replaces the built-in synonyms dictionary. Like Trie.SetSynonyms,
+// it has to be called before inserting anything.
+func (index *LinearIndex) SetSynonyms(synonyms *Synonyms) {
+	index.synonyms = synonyms
+}
+
+// EnablePhonetic turns on FindPhoneticMatches.
+func (index *LinearIndex) EnablePhonetic() {
+	index.phonetic = true
+}
+
+// Insert adds a location under key.
+func (index *LinearIndex) Insert(key string, value Location) {
+	key = index.synonyms.Normalize(strings.ToLower(key))
+	index.entries = append(index.entries, linearEntry{key: key, location: value})
+	index.slack = max(index.slack, utf8.RuneCountInString(key)-len(value.Name))
+}
+
+func (index *LinearIndex) Get(id string) (Location, bool) {
+	for _, entry := range index.entries {
+		if entry.location.ID == id {
+			return entry.location, true
+		}
+	}
+	return Location{}, false
+}
+
+func (index *LinearIndex) FindTokenMatches(prefix string, limit int) []Match {
+	variants := index.synonyms.Variants(strings.ToLower(prefix))
+	return index.find(variants, limit, false, func(key string) []string {
+		return []string{key}
+	})
+}
+
+func (index *LinearIndex) FindPhoneticMatches(prefix string, limit int) []Match {
+	if !index.phonetic {
+		return []Match{}
+	}
+
+	var variants []string
+	for _, variant := range index.synonyms.Variants(strings.ToLower(prefix)) {
+		variants = append(variants, PhoneticKeys(variant)...)
+	}
+	return index.find(variants, limit, true, PhoneticKeys)
+}
+
+// find returns the locations with a word in any of their keys that starts with
+// a variant, checking the variants in order. encode turns a normalized key
+// into the keys to check.
+func (index *LinearIndex) find(variants []string, limit int, phonetic bool, encode func(string) []string) []Match {
+	matches := []Match{}
+
+	for _, entry := range index.entries {
+		keys := encode(entry.key)
+		for _, variant := range variants {
+			token, found := firstTokenWithPrefix(keys, variant)
+			if !found {
+				continue
+			}
+
+			matches = append(matches, Match{Location: entry.location, Token: token, Phonetic: phonetic})
+			break
+		}
+
+		if limit > 0 && len(matches) >= limit {
+			break
+		}
+	}
+
+	return matches
+}
+
+// Walk finds every match first, then sorts them by the length of the shortest
+// key (the rest of the name from a word) that starts with a variant of prefix.
+func (index *LinearIndex) Walk(ctx context.Context, prefix string, visit Visitor) error {
+	variants := index.synonyms.Variants(strings.ToLower(prefix))
+
+	type walkMatch struct {
+		match Match
+		depth int
+	}
+	matches := []walkMatch{}
+	for _, entry := range index.entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		for _, variant := range variants {
+			if token, found := firstTokenWithPrefix([]string{entry.key}, variant); found {
+				match := Match{Location: entry.location, Token: token}
+				matches = append(matches, walkMatch{match, shortestKey(entry.key, variants)})
+				break
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].depth < matches[j].depth
+	})
+
+	for _, m := range matches {
+		if !visit(m.match, m.depth-index.slack) {
+			break
+		}
+	}
+	return nil
+}
+
+// FindInArea checks every location, and gives up if more than limit of them
+// are in the area.
+func (index *LinearIndex) FindInArea(prefix string, area Area, limit int) ([]Match, bool) {
+	variants := index.synonyms.Variants(strings.ToLower(prefix))
+
+	n := 0
+	matches := []Match{}
+	for _, entry := range index.entries {
+		if !area.Contains(entry.location.Lat, entry.location.Long) {
+			continue
+		}
+		if n++; n > limit {
+			return nil, false
+		}
+
+		for _, variant := range variants {
+			if token, found := firstTokenWithPrefix([]string{entry.key}, variant); found {
+				matches = append(matches, Match{Location: entry.location, Token: token})
+				break
+			}
+		}
+	}
+	return matches, true
+}
+
+// areaMatches checks each of the locations for a word that starts with a
+// variant of prefix, like LinearIndex.FindTokenMatches.
+func areaMatches(synonyms *Synonyms, locations []Location, prefix string) []Match {
+	variants := searchKeys(synonyms, prefix, false)
+
+	matches := []Match{}
+	for _, location := range locations {
+		keys := indexKeys(synonyms, location.Name, false)
+		for _, variant := range variants {
+			if token, found := firstTokenWithPrefix(keys, variant); found {
+				matches = append(matches, Match{Location: location, Token: token})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// shortestKey returns the length in characters of the shortest part of key
+// from a word that starts with any of the variants.
+func shortestKey(key string, variants []string) int {
+	shortest := -1
+	for _, start := range tokenStarts(key) {
+		for _, variant := range variants {
+			if n := utf8.RuneCountInString(key[start:]); strings.HasPrefix(key[start:], variant) && (shortest < 0 || n < shortest) {
+				shortest = n
+			}
+		}
+	}
+	return shortest
+}
+
+// firstTokenWithPrefix returns the first word of any of the keys that starts
+// with prefix.
+func firstTokenWithPrefix(keys []string, prefix string) (int, bool) {
+	token, found := 0, false
+	for _, key := range keys {
+		for i, start := range tokenStarts(key) {
+			if found && i >= token {
+				break
+			}
+			if strings.HasPrefix(key[start:], prefix) {
+				token, found = i, true
+				break
+			}
+		}
+	}
+	return token, found
+}
+
+func (index *LinearIndex) Corrections(text string, limit int, allow func(Location) bool) []string {
+	query := []rune(index.synonyms.Normalize(strings.ToLower(strings.TrimSpace(text))))
+	maxDistance := maxEditDistance(len(query))
+	if maxDistance == 0 {
+		return []string{}
+	}
+
+	scores := make(map[string]float64)
+	for _, entry := range index.entries {
+		if allow != nil && !allow(entry.location) {
+			continue
+		}
+		if distance := prefixEditDistance(query, entry.key, maxDistance); distance <= maxDistance {
+			addCorrection(scores, entry.location, distance)
+		}
+	}
+	return rankCorrections(scores, limit)
+}
+
+// prefixEditDistance returns the smallest edit distance between the query and
+// the start of key, giving up once it can't be within maxDistance.
+func prefixEditDistance(query []rune, key string, maxDistance float64) float64 {
+	distance := math.Inf(1)
+	var prevRow []float64
+	row := firstEditRow(query)
+	char := rune(0)
+
+	for _, next := range key {
+		nextRow, best := nextEditRow(query, prevRow, row, char, next)
+		distance = math.Min(distance, nextRow[len(query)])
+		if best > maxDistance {
+			break
+		}
+		prevRow, row, char = row, nextRow, next
+	}
+
+	return distance
+}
@@ -0,0 +1,281 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// A Selection is a suggestion that a user picked, as the server logs it for
+// learning to rank.
+type Selection struct {
+	Time  time.Time `json:"time"`
+	Query string    `json:"q"`
+	ID    string    `json:"id"`
+	Lat   *float64  `json:"latitude,omitempty"`
+	Long  *float64  `json:"longitude,omitempty"`
+}
+
+// ReadSelections reads a log of selections, one JSON object per line.
+func ReadSelections(r io.Reader) ([]Selection, error) {
+	selections := []Selection{}
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	for decoder.More() {
+		selection := Selection{}
+		if err := decoder.Decode(&selection); err != nil {
+			return nil, fmt.Errorf("invalid selection %d: %s", len(selections)+1, err)
+		}
+		selections = append(selections, selection)
+	}
+	return selections, nil
+}
+
+// The features a LearnedScorer weighs, in the order their values are listed.
+// "text", "distance" and "population" are the scores of the scorers of those
+// profile functions (distance is 0 without coordinates), and the feature codes
+// are 1 for a location with that code and 0 otherwise.
+var LearnedFeatures = []string{
+	"text", "distance", "population",
+	"feature:PPLC", "feature:PPLA", "feature:PPLA2", "feature:PPLA3", "feature:PPLA4",
+}
+
+// The index of the first feature code in LearnedFeatures
+const firstFeatureCode = 3
+
+// A featureScorer computes the features of locations for a query.
+type featureScorer struct {
+	text       *RelativeLengthScorer
+	distance   *GeoDistanceScorer // nil without coordinates
+	population *PopulationScorer
+}
+
+func newFeatureScorer(text string, lat, long *float64) *featureScorer {
+	scorer := &featureScorer{text: NewRelativeLengthScorer(text), population: &PopulationScorer{}}
+	if lat != nil && long != nil {
+		scorer.distance = NewGeoDistanceScorer(*lat, *long)
+	}
+	return scorer
+}
+
+// features returns the value of each of the LearnedFeatures for a location.
+func (scorer *featureScorer) features(location Location) []float64 {
+	features := make([]float64, len(LearnedFeatures))
+	features[0] = scorer.text.Score(location)
+	if scorer.distance != nil {
+		features[1] = scorer.distance.Score(location)
+	}
+	features[2] = scorer.population.Score(location)
+	for i := firstFeatureCode; i < len(LearnedFeatures); i++ {
+		if LearnedFeatures[i] == "feature:"+location.FeatureCode {
+			features[i] = 1
+		}
+	}
+	return features
+}
+
+// SelectionPairs returns the difference between the features of a selected
+// location and each of the other suggestions it was picked from, up to
+// candidates of them.
+func SelectionPairs(selection Selection, selected Location, suggested []ScoredLocation, candidates int) [][]float64 {
+	features := newFeatureScorer(ParseQuery(selection.Query).Text, selection.Lat, selection.Long)
+	chosen := features.features(selected)
+	pairs := [][]float64{}
+	for _, other := range suggested {
+		if other.ID == selected.ID {
+			continue
+		}
+		if len(pairs) == candidates {
+			break
+		}
+		pair := features.features(other.Location)
+		for i := range pair {
+			pair[i] = chosen[i] - pair[i]
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// Weights are how much each of the LearnedFeatures counts for, learned from
+// which suggestions users select. They're read from a JSON file like:
+//
+//	{"weights": {"text": 2.5, "distance": 1.2, "population": 3.1, "feature:PPLC": 0.4}}
+//
+// Features that aren't listed count for nothing.
+type Weights struct {
+	Weights map[string]float64 `json:"weights"`
+}
+
+// ReadWeights parses and checks a JSON weights file.
+func ReadWeights(r io.Reader) (*Weights, error) {
+	weights := &Weights{}
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(weights); err != nil {
+		return nil, fmt.Errorf("invalid weights file: %s", err)
+	}
+
+	for feature, weight := range weights.Weights {
+		if featureIndex(feature) < 0 {
+			return nil, fmt.Errorf("invalid weights file: unknown feature %q", feature)
+		}
+		if math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("invalid weights file: bad weight for %q", feature)
+		}
+	}
+	return weights, nil
+}
+
+// Write writes the weights out as JSON.
+func (weights *Weights) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(weights)
+}
+
+// String lists the weights in the order of LearnedFeatures.
+func (weights *Weights) String() string {
+	parts := []string{}
+	for _, feature := range LearnedFeatures {
+		parts = append(parts, fmt.Sprintf("%s=%.3g", feature, weights.Weights[feature]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// vector returns the weights in the order of LearnedFeatures.
+func (weights *Weights) vector() []float64 {
+	vector := make([]float64, len(LearnedFeatures))
+	for i, feature := range LearnedFeatures {
+		vector[i] = weights.Weights[feature]
+	}
+	return vector
+}
+
+// featureIndex returns where a feature is in LearnedFeatures, or -1.
+func featureIndex(feature string) int {
+	for i, name := range LearnedFeatures {
+		if name == feature {
+			return i
+		}
+	}
+	return -1
+}
+
+// TrainWeights fits a pairwise logistic model to the differences in features
+// between selected locations and the ones that weren't selected: the
+// probability that a user prefers one location to another is
+// 1 / (1 + e^-(w·(a - b))). It minimizes the log loss by gradient descent for
+// a number of epochs, with L2 regularization to keep weights of features that
+// rarely differ (like the rarer feature codes) from growing without bound.
+func TrainWeights(pairs [][]float64, epochs int, rate, l2 float64) *Weights {
+	w := make([]float64, len(LearnedFeatures))
+	gradient := make([]float64, len(w))
+	for epoch := 0; epoch < epochs && len(pairs) > 0; epoch++ {
+		for i := range gradient {
+			gradient[i] = l2 * w[i]
+		}
+		for _, pair := range pairs {
+			// d/dw log(1 + e^-(w·x)) = -x (1 - sigmoid(w·x))
+			residual := 1 - sigmoid(dot(w, pair))
+			for i, x := range pair {
+				gradient[i] -= x * residual / float64(len(pairs))
+			}
+		}
+		for i := range w {
+			w[i] -= rate * gradient[i]
+		}
+	}
+
+	weights := &Weights{Weights: make(map[string]float64)}
+	for i, feature := range LearnedFeatures {
+		weights.Weights[feature] = w[i]
+	}
+	return weights
+}
+
+// Evaluate returns the mean log loss of the weights on pairs, and the fraction
+// of pairs they rank the selected location of first.
+func (weights *Weights) Evaluate(pairs [][]float64) (loss, accuracy float64) {
+	w := weights.vector()
+	for _, pair := range pairs {
+		margin := dot(w, pair)
+		loss += math.Log1p(math.Exp(-margin))
+		if margin > 0 {
+			accuracy++
+		}
+	}
+	if len(pairs) == 0 {
+		return 0, 0
+	}
+	return loss / float64(len(pairs)), accuracy / float64(len(pairs))
+}
+
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+// A LearnedScorer scores results by the learned weights of their features,
+// squashed between 0 and 1.
+type LearnedScorer struct {
+	weights  []float64
+	features *featureScorer
+}
+
+func NewLearnedScorer(weights *Weights, text string, lat, long *float64) *LearnedScorer {
+	return &LearnedScorer{weights: weights.vector(), features: newFeatureScorer(text, lat, long)}
+}
+
+func (scorer *LearnedScorer) Score(location Location) float64 {
+	return sigmoid(dot(scorer.weights, scorer.features.features(location)))
+}
+
+func (scorer *LearnedScorer) Explain(location Location) Explanation {
+	features := scorer.features.features(location)
+	details := []Explanation{}
+	for i, feature := range features {
+		if feature == 0 || scorer.weights[i] == 0 {
+			continue
+		}
+		details = append(details, Explanation{
+			Value:       scorer.weights[i] * feature,
+			Description: fmt.Sprintf("%s %g, weighted %g", LearnedFeatures[i], feature, scorer.weights[i]),
+		})
+	}
+	return Explanation{
+		Value:       scorer.Score(location),
+		Description: "1 / (1 + e^-sum), for the sum of:",
+		Details:     details,
+	}
+}
+
+// MaxScore adds up the most each feature can add for a name of at least
+// minLength: the text score is bounded by the length, distance is 0 without
+// coordinates, and the rest are between 0 and 1.
+func (scorer *LearnedScorer) MaxScore(minLength int) float64 {
+	sum := 0.0
+	for i, weight := range scorer.weights {
+		highest := 1.0
+		switch {
+		case i == 0:
+			highest = scorer.features.text.MaxScore(minLength)
+		case i == 1 && scorer.features.distance == nil:
+			highest = 0
+		}
+		sum += math.Max(0, weight*highest)
+	}
+	return sigmoid(sum)
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestReadSelections(t *testing.T) {
+	log := `{"time": "2026-01-01T00:00:00Z", "q": "spr", "id": "1"}
+{"time": "2026-01-01T00:01:00Z", "q": "lon", "id": "2", "latitude": 43.7, "longitude": -79.4}
+`
+	selections, err := ReadSelections(bytes.NewBufferString(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selections) != 2 || selections[1].ID != "2" || selections[1].Lat == nil || *selections[1].Long != -79.4 {
+		t.Errorf("unexpected selections: %#v", selections)
+	}
+
+	if _, err := ReadSelections(bytes.NewBufferString(log + `{"query": "x"}`)); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
+
+func TestReadWeights(t *testing.T) {
+	tests := map[string]struct {
+		file  string
+		valid bool
+	}{
+		"weights":         {`{"weights": {"text": 1.5, "feature:PPLC": -0.5}}`, true},
+		"none":            {`{}`, true},
+		"unknown feature": {`{"weights": {"popularity": 1}}`, false},
+		"unknown field":   {`{"bias": 1}`, false},
+		"not JSON":        {`text=1`, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ReadWeights(bytes.NewBufferString(tt.file)); (err == nil) != tt.valid {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTrainWeights(t *testing.T) {
+	// users always pick the more populous place, whatever the other features
+	pairs := [][]float64{}
+	for i := 0; i < 20; i++ {
+		text := float64(i%5)/4 - 0.5
+		pairs = append(pairs,
+			[]float64{text, 0, 0.3, 0, 0, 0, 0, 0},
+			[]float64{-text, 0, 0.1, 0, 0, 0, 0, 0},
+		)
+	}
+
+	weights := TrainWeights(pairs, 1000, 1, 0.001)
+	if weights.Weights["population"] <= 0 {
+		t.Errorf("population should be weighted up: %s", weights)
+	}
+	if math.Abs(weights.Weights["text"]) >= weights.Weights["population"] {
+		t.Errorf("text shouldn't matter as much as population: %s", weights)
+	}
+	if loss, accuracy := weights.Evaluate(pairs); accuracy != 1 || loss >= math.Ln2 {
+		t.Errorf("loss %g and accuracy %g, should do better than chance", loss, accuracy)
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := weights.Write(buffer); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadWeights(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, weights) {
+		t.Errorf("%#v != %#v", read, weights)
+	}
+}
+
+func TestSelectionPairs(t *testing.T) {
+	illinois := Location{ID: "1", Name: "Springfield", Country: "US", Region: "IL", FeatureCode: "PPLA", Population: 116250}
+	suggested := []ScoredLocation{
+		{Location: Location{ID: "3", Name: "Spring", Country: "US", Region: "TX", FeatureCode: "PPL", Population: 54298}},
+		{Location: illinois},
+		{Location: Location{ID: "2", Name: "Springfield", Country: "US", Region: "MO", FeatureCode: "PPLA2", Population: 159498}},
+	}
+
+	pairs := SelectionPairs(Selection{Query: "spring", ID: "1"}, illinois, suggested, 10)
+	if len(pairs) != 2 {
+		t.Fatalf("expected a pair with each other suggestion: %#v", pairs)
+	}
+	expected := []float64{
+		InverseLengthScore(5) - 1, 0,
+		(&PopulationScorer{}).Score(Location{Population: 116250}) - (&PopulationScorer{}).Score(Location{Population: 54298}),
+		0, 1, 0, 0, 0,
+	}
+	if !reflect.DeepEqual(pairs[0], expected) {
+		t.Errorf("%#v != %#v", pairs[0], expected)
+	}
+
+	if pairs := SelectionPairs(Selection{Query: "spring", ID: "1"}, illinois, suggested, 1); len(pairs) != 1 {
+		t.Errorf("%#v != %#v", len(pairs), 1)
+	}
+}
+
+func TestLearnedScorer(t *testing.T) {
+	weights := &Weights{Weights: map[string]float64{"text": 2, "population": 4, "feature:PPLA": 1, "distance": -1}}
+	scorer := NewLearnedScorer(weights, "spring", nil, nil)
+
+	capital := Location{Name: "Springfield", FeatureCode: "PPLA", Population: 116250}
+	if actual, expected := scorer.Score(capital), sigmoid(2*InverseLengthScore(5)+4*(&PopulationScorer{}).Score(capital)+1); math.Abs(actual-expected) > 1e-12 {
+		t.Errorf("%#v != %#v", actual, expected)
+	}
+	if explanation := scorer.Explain(capital); explanation.Value != scorer.Score(capital) || len(explanation.Details) != 3 {
+		t.Errorf("unexpected explanation: %#v", explanation)
+	}
+
+	// no name of at least minLength can score higher than MaxScore
+	for _, location := range []Location{
+		capital,
+		{Name: "Spring", FeatureCode: "PPLA", Population: 100000000},
+		{Name: "Springdale", Population: 81125},
+	} {
+		if score, max := scorer.Score(location), scorer.MaxScore(len(location.Name)); score > max {
+			t.Errorf("%s: %g > %g", location.Name, score, max)
+		}
+	}
+
+	profile := &Profile{Functions: []*ScoreFunction{{Type: FunctionLearned}}}
+	if err := profile.check(); err != nil {
+		t.Fatal(err)
+	}
+	if actual := profile.Scorer("spring", ScoringContext{Weights: weights}).Score(capital); actual != scorer.Score(capital) {
+		t.Errorf("%#v != %#v", actual, scorer.Score(capital))
+	}
+	if actual := profile.Scorer("spring", ScoringContext{}).Score(capital); actual != 1 {
+		t.Errorf("the learned function shouldn't apply without weights: %#v", actual)
+	}
+}
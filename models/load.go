@@ -0,0 +1,23 @@
+package models
+
+import "io"
+
+// LoadTrie streams GeoNames records from file directly into a new tree, without
+// holding the intermediate list of locations in memory. Rows rejected by the
+// filter (which may be nil) are never inserted.
+func LoadTrie(file io.Reader, filter *Filter) (*Trie, error) {
+	tree := NewTrie()
+
+	err := ScanCityData(file, func(location Location) error {
+		if !filter.Allow(location) {
+			return nil
+		}
+		tree.Insert(location.Name, location)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
@@ -19,6 +19,10 @@ type Location struct {
 	Lat         float64 `json:"lat"`
 	Long        float64 `json:"long"`
 	Country     string  `json:"country"`
+	Region      string  `json:"region"`
+	FeatureCode string  `json:"feature_code"`
+	Population  int64   `json:"population"`
+	ModifiedAt  string  `json:"modified_at"` // yyyy-MM-dd
 }
 
 type ByName []Location
@@ -27,57 +31,111 @@ func (a ByName) Len() int           { return len(a) }
 func (a ByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
 
-func ReadCityData(file io.Reader) (results []Location, err error) {
+// Lines in the full GeoNames dump can be long (alternate names alone can be
+// 5000 characters), so allow for more than bufio's default token size.
+const maxLineLength = 1024 * 1024
+
+// ScanCityData reads GeoNames records one at a time, calling fn for each
+// location. Nothing is retained between records, so this can be used to load
+// files much larger than the resulting index. Loading stops at the first error
+// returned by fn.
+func ScanCityData(file io.Reader, fn func(Location) error) error {
+	return scanRecords(file, func(record []string) error {
+		location, err := parseCityRecord(record)
+		if err != nil {
+			return err
+		}
+		return fn(location)
+	})
+}
+
+// scanRecords splits each line of a TSV file into fields, skipping the header.
+func scanRecords(file io.Reader, fn func(record []string) error) error {
 	scanner := bufio.NewScanner(file)
-	scanner.Scan() // skip first header line
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		record := strings.Split(line, "\t")
+	for line := 1; scanner.Scan(); line++ {
+		record := strings.Split(scanner.Text(), "\t")
 
-		// Translate region codes into human names, falling back on original value
-		regionName := record[10]
-		regionCode := record[8] + record[10]
-		if name, found := REGION_CODES[regionCode]; found {
-			regionName = name
+		// skip the header line, if there is one (the full dump doesn't have one)
+		if line == 1 && record[0] == "id" {
+			continue
 		}
 
-		location := Location{
-			ID:          record[0],
-			Name:        record[1],
-			DisplayName: fmt.Sprintf("%s, %s, %s", record[1], regionName, record[8]),
-			Country:     record[8],
-		}
-		if location.Lat, err = strconv.ParseFloat(record[4], 32); err != nil {
-			return nil, err
+		if err := fn(record); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
 		}
-		if location.Long, err = strconv.ParseFloat(record[5], 32); err != nil {
-			return nil, err
-		}
-
-		results = append(results, location)
 	}
 
-	if scanner.Err() != nil {
+	return scanner.Err()
+}
+
+// ReadCityData reads all locations into memory. Prefer ScanCityData for large
+// files.
+func ReadCityData(file io.Reader) (results []Location, err error) {
+	err = ScanCityData(file, func(location Location) error {
+		results = append(results, location)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return results, nil
 }
 
-// Mapping FIPS region codes to provinces/states
-var REGION_CODES = map[string]string{
-	"CA01": "Alberta",
-	"CA02": "British Columbia",
-	"CA03": "Manitoba",
-	"CA04": "New Brunswick",
-	"CA05": "Newfoundland and Labrador",
-	"CA07": "Nova Scotia",
-	"CA08": "Ontario",
-	"CA09": "Prince Edward Island",
-	"CA10": "Quebec",
-	"CA11": "Saskatchewan",
-	"CA12": "Yukon",
-	"CA13": "Northwest Territories",
-	"CA14": "Nunavut",
+func parseCityRecord(record []string) (location Location, err error) {
+	if len(record) < 11 {
+		return location, fmt.Errorf("expected at least 11 fields, found %d", len(record))
+	}
+	// the LocationTable stores IDs as 32 bit numbers, with 0 for none
+	if id, err := strconv.ParseUint(record[0], 10, 32); err != nil || id == 0 {
+		return location, fmt.Errorf("invalid id %q", record[0])
+	}
+
+	// Translate region codes into human names, falling back on original value
+	regionName := record[10]
+	regionCode := record[8] + record[10]
+	if name, found := REGION_CODES[regionCode]; found {
+		regionName = name
+	}
+
+	location = Location{
+		ID:          record[0],
+		Name:        record[1],
+		DisplayName: fmt.Sprintf("%s, %s, %s", record[1], regionName, record[8]),
+		Country:     record[8],
+		Region:      record[10],
+		FeatureCode: record[7],
+	}
+	if len(record) > 14 && record[14] != "" {
+		if location.Population, err = strconv.ParseInt(record[14], 10, 64); err != nil {
+			return location, err
+		}
+	}
+	if len(record) > 18 {
+		location.ModifiedAt = record[18]
+	}
+	if location.Lat, err = strconv.ParseFloat(record[4], 32); err != nil {
+		return location, err
+	}
+	if location.Long, err = strconv.ParseFloat(record[5], 32); err != nil {
+		return location, err
+	}
+
+	return location, nil
+}
+
+// Mapping FIPS region codes to provinces/states, for the regions in REGIONS
+// whose codes aren't the abbreviations people know them by
+var REGION_CODES = regionCodes()
+
+func regionCodes() map[string]string {
+	codes := make(map[string]string)
+	for _, region := range REGIONS {
+		if region.Code != region.Abbrev {
+			codes[region.Country+region.Code] = region.Name
+		}
+	}
+	return codes
 }
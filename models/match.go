@@ -0,0 +1,17 @@
+package models
+
+// A Match is a location found by a query, along with which word of its name
+// the query matched and how.
+type Match struct {
+	Location
+	Token    int  // 0 when the query matched the start of the name
+	Phonetic bool // whether the name only sounds like the query
+}
+
+// Weight scales the score of a match by how closely it matched the query.
+func (match Match) Weight() float64 {
+	if match.Phonetic {
+		return TokenWeight(match.Token) * PhoneticWeight
+	}
+	return TokenWeight(match.Token)
+}
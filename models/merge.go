@@ -0,0 +1,206 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A Merger loads several sources into one tree, in order. Rows are keyed by ID,
+// so a row in a later source overrides any earlier row with the same ID. This
+// lets local additions and overrides live in their own file next to the
+// GeoNames data.
+//
+// A row whose ID starts with "-" is a tombstone: "-5881791" removes location
+// 5881791 loaded from an earlier source. Any other fields on the row are
+// ignored.
+type Merger struct {
+	tree   *Trie
+	filter *Filter
+	report MergeReport
+
+	// origin holds the index of the source each location was loaded from,
+	// by ref. It's only needed while merging.
+	origin []uint16
+}
+
+// A MergeReport summarizes each source and lists every override.
+type MergeReport struct {
+	Sources   []SourceStats
+	Conflicts []MergeConflict
+}
+
+type SourceStats struct {
+	Name       string
+	Rows       int
+	Inserted   int // new IDs
+	Overrides  int // IDs already loaded, replaced by this source
+	Tombstones int // IDs removed by this source
+	Missing    int // tombstones for IDs that weren't loaded
+	Filtered   int // rows rejected by the load filter
+}
+
+// A MergeConflict is a location that was loaded more than once.
+type MergeConflict struct {
+	ID           string
+	Name         string // the name it was first loaded with
+	Source       string // where it was first loaded from
+	Override     string // the source that replaced or removed it
+	OverrideName string // the replacement's name, or empty for a tombstone or a filtered row
+}
+
+func (conflict MergeConflict) String() string {
+	if conflict.OverrideName == "" {
+		return fmt.Sprintf("%s %q (%s) removed by %s", conflict.ID, conflict.Name, conflict.Source, conflict.Override)
+	}
+	return fmt.Sprintf("%s %q (%s) replaced by %q (%s)", conflict.ID, conflict.Name, conflict.Source, conflict.OverrideName, conflict.Override)
+}
+
+// NewMerger creates a merger that loads into a new tree. Rows rejected by the
+// filter (which may be nil) are never inserted.
+func NewMerger(filter *Filter) *Merger {
+	return &Merger{
+		tree:   NewTrie(),
+		filter: filter,
+	}
+}
+
+// Merge loads one source, overriding anything loaded from previous sources.
+func (merger *Merger) Merge(name string, file io.Reader) error {
+	source := uint16(len(merger.report.Sources))
+	merger.report.Sources = append(merger.report.Sources, SourceStats{Name: name})
+	stats := &merger.report.Sources[source]
+
+	err := scanRecords(file, func(record []string) error {
+		stats.Rows++
+
+		if strings.HasPrefix(record[0], "-") {
+			merger.tombstone(strings.TrimPrefix(record[0], "-"), stats)
+			return nil
+		}
+
+		location, err := parseCityRecord(record)
+		if err != nil {
+			return err
+		}
+
+		// an override that fails the filter still removes the earlier row
+		if !merger.filter.Allow(location) {
+			stats.Filtered++
+			if old, found := merger.tree.Lookup(location.ID); found {
+				merger.conflict(old, "", stats)
+				merger.tree.Remove(location.ID)
+			}
+			return nil
+		}
+
+		if old, found := merger.tree.Lookup(location.ID); found {
+			merger.conflict(old, location.Name, stats)
+			stats.Overrides++
+		} else {
+			stats.Inserted++
+		}
+
+		ref, _ := merger.tree.Replace(location.Name, location)
+		for len(merger.origin) <= int(ref) {
+			merger.origin = append(merger.origin, 0)
+		}
+		merger.origin[ref] = source
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	return nil
+}
+
+// Tree returns the merged tree.
+func (merger *Merger) Tree() *Trie {
+	return merger.tree
+}
+
+// Report returns the summary of everything merged so far.
+func (merger *Merger) Report() MergeReport {
+	return merger.report
+}
+
+func (merger *Merger) tombstone(id string, stats *SourceStats) {
+	old, found := merger.tree.Lookup(id)
+	if !found {
+		stats.Missing++
+		return
+	}
+
+	merger.conflict(old, "", stats)
+	merger.tree.Remove(id)
+	stats.Tombstones++
+}
+
+func (merger *Merger) conflict(old LocationRef, overrideName string, stats *SourceStats) {
+	location := merger.tree.Locations().Location(old)
+	merger.report.Conflicts = append(merger.report.Conflicts, MergeConflict{
+		ID:           location.ID,
+		Source:       merger.report.Sources[merger.origin[old]].Name,
+		Name:         location.Name,
+		Override:     stats.Name,
+		OverrideName: overrideName,
+	})
+}
+
+// MergeFile loads the source at path.
+func (merger *Merger) MergeFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return merger.Merge(path, f)
+}
+
+// LoadOptions are how LoadSources builds its tree.
+type LoadOptions struct {
+	Filter   *Filter   // rows to leave out, if not nil
+	Synonyms *Synonyms // the built-in synonyms if nil
+	Phonetic bool      // also index names by sound
+}
+
+// A Source is a named CSV source for LoadSources.
+type Source struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// FileSource is the source in the file at path, named by its path.
+func FileSource(path string) Source {
+	return Source{Name: path, Open: func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}}
+}
+
+// LoadSources merges sources, in order, into a new tree.
+func LoadSources(sources []Source, options LoadOptions) (*Trie, MergeReport, error) {
+	merger := NewMerger(options.Filter)
+	if options.Synonyms != nil {
+		merger.Tree().SetSynonyms(options.Synonyms)
+	}
+	if options.Phonetic {
+		merger.Tree().EnablePhonetic()
+	}
+
+	for _, source := range sources {
+		r, err := source.Open()
+		if err != nil {
+			return nil, merger.Report(), err
+		}
+		err = merger.Merge(source.Name, r)
+		r.Close()
+		if err != nil {
+			return nil, merger.Report(), err
+		}
+	}
+
+	return merger.Tree(), merger.Report(), nil
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMerger_Merge(t *testing.T) {
+	merger := NewMerger(nil)
+
+	base := geonamesRow("1", "Montreal", "2016-01-01") +
+		geonamesRow("2", "Montmagny", "2016-01-01") +
+		geonamesRow("3", "Montebello", "2016-01-01")
+	local := geonamesRow("2", "Montmagny (local)", "2016-01-01") +
+		"-3\n" +
+		"-4\tNowhere\n" +
+		geonamesRow("5", "Montcalm", "2016-01-01")
+
+	if err := merger.Merge("base", strings.NewReader(base)); err != nil {
+		t.Fatal(err)
+	}
+	if err := merger.Merge("local", strings.NewReader(local)); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedNames := []string{"Montcalm", "Montreal", "Montmagny (local)"}
+	if actual := names(merger.Tree().FindMatches("mont", 0)); !reflect.DeepEqual(actual, expectedNames) {
+		t.Errorf("%#v != %#v", actual, expectedNames)
+	}
+
+	report := merger.Report()
+	expectedSources := []SourceStats{
+		{Name: "base", Rows: 3, Inserted: 3},
+		{Name: "local", Rows: 4, Inserted: 1, Overrides: 1, Tombstones: 1, Missing: 1},
+	}
+	if !reflect.DeepEqual(report.Sources, expectedSources) {
+		t.Errorf("%#v != %#v", report.Sources, expectedSources)
+	}
+
+	expectedConflicts := []MergeConflict{
+		{ID: "2", Name: "Montmagny", Source: "base", Override: "local", OverrideName: "Montmagny (local)"},
+		{ID: "3", Name: "Montebello", Source: "base", Override: "local"},
+	}
+	if !reflect.DeepEqual(report.Conflicts, expectedConflicts) {
+		t.Errorf("%#v != %#v", report.Conflicts, expectedConflicts)
+	}
+}
+
+func TestMerger_MergeDuplicatesWithinSource(t *testing.T) {
+	merger := NewMerger(nil)
+
+	data := geonamesRow("1", "Montreal", "2016-01-01") + geonamesRow("1", "Montréal", "2016-01-01")
+	if err := merger.Merge("base", strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := names(merger.Tree().FindMatches("mont", 0)); !reflect.DeepEqual(actual, []string{"Montréal"}) {
+		t.Errorf("%#v", actual)
+	}
+	if len(merger.Report().Conflicts) != 1 {
+		t.Errorf("%#v", merger.Report().Conflicts)
+	}
+}
+
+func TestMerger_MergeFilteredOverride(t *testing.T) {
+	merger := NewMerger(&Filter{Rules: []*FilterRule{{Countries: []string{"CA"}}}})
+
+	base := geonamesRow("1", "Montreal", "2016-01-01")
+	local := strings.Replace(geonamesRow("1", "Montreal", "2016-01-01"), "\tCA\t", "\tUS\t", 1)
+
+	merger.Merge("base", strings.NewReader(base))
+	if err := merger.Merge("local", strings.NewReader(local)); err != nil {
+		t.Fatal(err)
+	}
+
+	if merger.Tree().Find("montreal") {
+		t.Errorf("filtered override should remove the earlier row")
+	}
+	if merger.Report().Sources[1].Filtered != 1 {
+		t.Errorf("%#v", merger.Report().Sources[1])
+	}
+	expected := []MergeConflict{{ID: "1", Name: "Montreal", Source: "base", Override: "local"}}
+	if !reflect.DeepEqual(merger.Report().Conflicts, expected) {
+		t.Errorf("%#v != %#v", merger.Report().Conflicts, expected)
+	}
+}
+
+func TestMerger_MergeInvalidID(t *testing.T) {
+	for _, id := range []string{"abc", "4294967296", "0", "", "1x"} {
+		merger := NewMerger(nil)
+		err := merger.Merge("base", strings.NewReader(geonamesRow("1", "Montreal", "2016-01-01")+geonamesRow(id, "Montmagny", "2016-01-01")))
+		if expected := fmt.Sprintf("base: line 2: invalid id %q", id); err == nil || err.Error() != expected {
+			t.Errorf("%#v != %#v", err, expected)
+		}
+	}
+}
+
+func TestLoadSources(t *testing.T) {
+	base := Source{Name: "base", Open: func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(geonamesRow("3", "Toronto", "2016-01-01"))), nil
+	}}
+	tree, report, err := LoadSources([]Source{base}, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Sources) != 1 || report.Sources[0].Name != "base" || !tree.Find("toronto") {
+		t.Errorf("expected the base source: %#v", report.Sources)
+	}
+
+	path := filepath.Join(t.TempDir(), "local.tsv")
+	rows := geonamesRow("1", "Montreal", "2016-01-01") + strings.Replace(geonamesRow("2", "Shyenne", "2016-01-01"), "\tCA\t", "\tUS\t", 1)
+	if err := os.WriteFile(path, []byte(rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tree, report, err = LoadSources([]Source{FileSource(path)}, LoadOptions{
+		Filter:   &Filter{Rules: []*FilterRule{{Countries: []string{"US"}}}},
+		Phonetic: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Sources) != 1 || report.Sources[0].Filtered != 1 {
+		t.Errorf("expected only the local source, filtered: %#v", report.Sources)
+	}
+	if matches := tree.FindPhoneticMatches("cheyenne", 0); len(matches) != 1 || matches[0].Name != "Shyenne" {
+		t.Errorf("expected a phonetic match: %#v", matches)
+	}
+}
@@ -0,0 +1,646 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+)
+
+// This is an implementation of Lawrence Philips' Double Metaphone algorithm,
+// following the rules (and rule names) of the reference implementation. It
+// encodes a word as a primary and an alternate phonetic key, so that names
+// that sound alike, like "Cheyenne" and "Shyenne", get the same key.
+
+// Keys are cut off at this length. The reference implementation uses 4, but
+// longer keys are more useful for prefix matching.
+const maxMetaphoneLength = 32
+
+// DoubleMetaphone returns the primary and alternate keys for a single word.
+func DoubleMetaphone(word string) (primary, alternate string) {
+	m := &metaphone{value: foldASCII(word)}
+	m.slavoGermanic = strings.ContainsAny(m.value, "WK") || strings.Contains(m.value, "CZ") || strings.Contains(m.value, "WITZ")
+	m.encode()
+	return m.primary.String(), m.alternate.String()
+}
+
+// PhoneticKeys encodes each word of a key, returning the primary keys of its
+// words joined by spaces, and the alternate keys if they differ. Keys are
+// lower-cased like every other key in a Trie.
+// Words that have no sounds (like numbers) are left out, and nil is returned
+// if no word has any.
+func PhoneticKeys(key string) []string {
+	var primaries, alternates []string
+	for _, word := range strings.FieldsFunc(key, isSeparator) {
+		primary, alternate := DoubleMetaphone(word)
+		if primary == "" && alternate == "" {
+			continue
+		}
+		primaries = append(primaries, primary)
+		alternates = append(alternates, alternate)
+	}
+
+	if len(primaries) == 0 {
+		return nil
+	}
+	primary := strings.ToLower(strings.Join(primaries, " "))
+	alternate := strings.ToLower(strings.Join(alternates, " "))
+	if alternate == primary {
+		return []string{primary}
+	}
+	return []string{primary, alternate}
+}
+
+type metaphone struct {
+	value              string
+	slavoGermanic      bool
+	primary, alternate strings.Builder
+}
+
+func (m *metaphone) complete() bool {
+	return m.primary.Len() >= maxMetaphoneLength && m.alternate.Len() >= maxMetaphoneLength
+}
+
+// add appends to both keys.
+func (m *metaphone) add(s string) {
+	m.addBoth(s, s)
+}
+
+// addBoth appends different strings to the primary and alternate keys.
+func (m *metaphone) addBoth(primary, alternate string) {
+	if m.primary.Len() < maxMetaphoneLength {
+		m.primary.WriteString(primary)
+	}
+	if m.alternate.Len() < maxMetaphoneLength {
+		m.alternate.WriteString(alternate)
+	}
+}
+
+// at returns the character at i, or 0 if i is out of range.
+func (m *metaphone) at(i int) byte {
+	if i < 0 || i >= len(m.value) {
+		return 0
+	}
+	return m.value[i]
+}
+
+// contains checks whether the substring at start is any of the candidates,
+// which must all be the same length.
+func (m *metaphone) contains(start int, candidates ...string) bool {
+	length := len(candidates[0])
+	if start < 0 || start+length > len(m.value) {
+		return false
+	}
+	target := m.value[start : start+length]
+	for _, candidate := range candidates {
+		if target == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *metaphone) vowel(i int) bool {
+	return strings.IndexByte("AEIOUY", m.at(i)) >= 0 && m.at(i) != 0
+}
+
+func (m *metaphone) germanic() bool {
+	return m.contains(0, "VAN ", "VON ") || m.contains(0, "SCH")
+}
+
+func (m *metaphone) encode() {
+	index := 0
+	if m.contains(0, "GN", "KN", "PN", "WR", "PS") {
+		// skip silent letters at the start of a word
+		index = 1
+	}
+
+	for !m.complete() && index < len(m.value) {
+		switch m.at(index) {
+		case 'A', 'E', 'I', 'O', 'U', 'Y':
+			// vowels are only kept at the start
+			if index == 0 {
+				m.add("A")
+			}
+			index++
+		case 'B':
+			m.add("P")
+			index = m.skipDouble(index, 'B')
+		case 'C':
+			index = m.handleC(index)
+		case 'D':
+			index = m.handleD(index)
+		case 'F':
+			m.add("F")
+			index = m.skipDouble(index, 'F')
+		case 'G':
+			index = m.handleG(index)
+		case 'H':
+			index = m.handleH(index)
+		case 'J':
+			index = m.handleJ(index)
+		case 'K':
+			m.add("K")
+			index = m.skipDouble(index, 'K')
+		case 'L':
+			index = m.handleL(index)
+		case 'M':
+			m.add("M")
+			if m.conditionM0(index) {
+				index += 2
+			} else {
+				index++
+			}
+		case 'N':
+			m.add("N")
+			index = m.skipDouble(index, 'N')
+		case 'P':
+			index = m.handleP(index)
+		case 'Q':
+			m.add("K")
+			index = m.skipDouble(index, 'Q')
+		case 'R':
+			index = m.handleR(index)
+		case 'S':
+			index = m.handleS(index)
+		case 'T':
+			index = m.handleT(index)
+		case 'V':
+			m.add("F")
+			index = m.skipDouble(index, 'V')
+		case 'W':
+			index = m.handleW(index)
+		case 'X':
+			index = m.handleX(index)
+		case 'Z':
+			index = m.handleZ(index)
+		default:
+			index++
+		}
+	}
+}
+
+func (m *metaphone) skipDouble(index int, c byte) int {
+	if m.at(index+1) == c {
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleC(index int) int {
+	switch {
+	case m.conditionC0(index):
+		// various germanic
+		m.add("K")
+		return index + 2
+	case index == 0 && m.contains(index, "CAESAR"):
+		m.add("S")
+		return index + 2
+	case m.contains(index, "CH"):
+		return m.handleCH(index)
+	case m.contains(index, "CZ") && !m.contains(index-2, "WICZ"):
+		// "Czerny"
+		m.addBoth("S", "X")
+		return index + 2
+	case m.contains(index+1, "CIA"):
+		// "focaccia"
+		m.add("X")
+		return index + 3
+	case m.contains(index, "CC") && !(index == 1 && m.at(0) == 'M'):
+		// double "cc" but not "McClelland"
+		return m.handleCC(index)
+	case m.contains(index, "CK", "CG", "CQ"):
+		m.add("K")
+		return index + 2
+	case m.contains(index, "CI", "CE", "CY"):
+		// Italian vs. English
+		if m.contains(index, "CIO", "CIE", "CIA") {
+			m.addBoth("S", "X")
+		} else {
+			m.add("S")
+		}
+		return index + 2
+	}
+
+	m.add("K")
+	switch {
+	case m.contains(index+1, " C", " Q", " G"):
+		// "Mac Caffrey", "Mac Gregor"
+		return index + 3
+	case m.contains(index+1, "C", "K", "Q") && !m.contains(index+1, "CE", "CI"):
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleCC(index int) int {
+	if m.contains(index+2, "I", "E", "H") && !m.contains(index+2, "HU") {
+		// "bellocchio" but not "bacchus"
+		if (index == 1 && m.at(index-1) == 'A') || m.contains(index-1, "UCCEE", "UCCES") {
+			// "accident", "accede", "succeed"
+			m.add("KS")
+		} else {
+			// "bacci", "bertucci", other Italian
+			m.add("X")
+		}
+		return index + 3
+	}
+
+	// Pierce's rule
+	m.add("K")
+	return index + 2
+}
+
+func (m *metaphone) handleCH(index int) int {
+	switch {
+	case index > 0 && m.contains(index, "CHAE"):
+		// "Michael"
+		m.addBoth("K", "X")
+	case m.conditionCH0(index), m.conditionCH1(index):
+		// Greek roots ("chemistry", "chorus"), and Germanic or other "kh" sounds
+		m.add("K")
+	case index > 0 && m.contains(0, "MC"):
+		m.add("K")
+	case index > 0:
+		m.addBoth("X", "K")
+	default:
+		m.add("X")
+	}
+	return index + 2
+}
+
+func (m *metaphone) conditionC0(index int) bool {
+	if m.contains(index, "CHIA") {
+		return true
+	} else if index <= 1 || m.vowel(index-2) || !m.contains(index-1, "ACH") {
+		return false
+	}
+	c := m.at(index + 2)
+	return (c != 'I' && c != 'E') || m.contains(index-2, "BACHER", "MACHER")
+}
+
+func (m *metaphone) conditionCH0(index int) bool {
+	if index != 0 {
+		return false
+	} else if !m.contains(index+1, "HARAC", "HARIS") && !m.contains(index+1, "HOR", "HYM", "HIA", "HEM") {
+		return false
+	}
+	return !m.contains(0, "CHORE")
+}
+
+func (m *metaphone) conditionCH1(index int) bool {
+	return m.germanic() ||
+		m.contains(index-2, "ORCHES", "ARCHIT", "ORCHID") ||
+		m.contains(index+2, "T", "S") ||
+		((m.contains(index-1, "A", "O", "U", "E") || index == 0) &&
+			(m.contains(index+2, "L", "R", "N", "M", "B", "H", "F", "V", "W", " ") || index+1 == len(m.value)-1))
+}
+
+func (m *metaphone) handleD(index int) int {
+	if m.contains(index, "DG") {
+		if m.contains(index+2, "I", "E", "Y") {
+			// "edge"
+			m.add("J")
+			return index + 3
+		}
+		// "Edgar"
+		m.add("TK")
+		return index + 2
+	} else if m.contains(index, "DT", "DD") {
+		m.add("T")
+		return index + 2
+	}
+	m.add("T")
+	return index + 1
+}
+
+func (m *metaphone) handleG(index int) int {
+	switch {
+	case m.at(index+1) == 'H':
+		return m.handleGH(index)
+	case m.at(index+1) == 'N':
+		if index == 1 && m.vowel(0) && !m.slavoGermanic {
+			m.addBoth("KN", "N")
+		} else if !m.contains(index+2, "EY") && m.at(index+1) != 'Y' && !m.slavoGermanic {
+			m.addBoth("N", "KN")
+		} else {
+			m.add("KN")
+		}
+		return index + 2
+	case m.contains(index+1, "LI") && !m.slavoGermanic:
+		m.addBoth("KL", "L")
+		return index + 2
+	case index == 0 && (m.at(index+1) == 'Y' || m.contains(index+1, "ES", "EP", "EB", "EL", "EY", "IB", "IL", "IN", "IE", "EI", "ER")):
+		// -ges-, -gep-, -gel-, -gie- at the start
+		m.addBoth("K", "J")
+		return index + 2
+	case (m.contains(index+1, "ER") || m.at(index+1) == 'Y') &&
+		!m.contains(0, "DANGER", "RANGER", "MANGER") &&
+		!m.contains(index-1, "E", "I") &&
+		!m.contains(index-1, "RGY", "OGY"):
+		// -ger-, -gy-
+		m.addBoth("K", "J")
+		return index + 2
+	case m.contains(index+1, "E", "I", "Y") || m.contains(index-1, "AGGI", "OGGI"):
+		// Italian "biaggi"
+		if m.germanic() || m.contains(index+1, "ET") {
+			m.add("K")
+		} else if m.contains(index+1, "IER") {
+			m.add("J")
+		} else {
+			m.addBoth("J", "K")
+		}
+		return index + 2
+	case m.at(index+1) == 'G':
+		m.add("K")
+		return index + 2
+	}
+	m.add("K")
+	return index + 1
+}
+
+func (m *metaphone) handleGH(index int) int {
+	switch {
+	case index > 0 && !m.vowel(index-1):
+		m.add("K")
+	case index == 0:
+		if m.at(index+2) == 'I' {
+			m.add("J")
+		} else {
+			m.add("K")
+		}
+	case m.contains(index-2, "B", "H", "D") || m.contains(index-3, "B", "H", "D") || m.contains(index-4, "B", "H"):
+		// Parker's rule, "hugh"
+	case index > 2 && m.at(index-1) == 'U' && m.contains(index-3, "C", "G", "L", "R", "T"):
+		// "laugh", "McLaughlin", "cough", "gough", "rough", "tough"
+		m.add("F")
+	case m.at(index-1) != 'I':
+		m.add("K")
+	}
+	return index + 2
+}
+
+func (m *metaphone) handleH(index int) int {
+	// only keep if first and before a vowel, or between two vowels
+	if (index == 0 || m.vowel(index-1)) && m.vowel(index+1) {
+		m.add("H")
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleJ(index int) int {
+	if m.contains(index, "JOSE") || m.contains(0, "SAN ") {
+		// obvious Spanish, "Jose", "San Jacinto"
+		if (index == 0 && m.at(index+4) == ' ') || len(m.value) == 4 || m.contains(0, "SAN ") {
+			m.add("H")
+		} else {
+			m.addBoth("J", "H")
+		}
+		return index + 1
+	}
+
+	switch {
+	case index == 0:
+		m.addBoth("J", "A")
+	case m.vowel(index-1) && !m.slavoGermanic && (m.at(index+1) == 'A' || m.at(index+1) == 'O'):
+		m.addBoth("J", "H")
+	case index == len(m.value)-1:
+		m.addBoth("J", "")
+	case !m.contains(index+1, "L", "T", "K", "S", "N", "M", "B", "Z") && !m.contains(index-1, "S", "K", "L"):
+		m.add("J")
+	}
+
+	return m.skipDouble(index, 'J')
+}
+
+func (m *metaphone) handleL(index int) int {
+	if m.at(index+1) == 'L' {
+		if m.conditionL0(index) {
+			// Spanish "cabrillo", "gallegos"
+			m.addBoth("L", "")
+		} else {
+			m.add("L")
+		}
+		return index + 2
+	}
+	m.add("L")
+	return index + 1
+}
+
+func (m *metaphone) conditionL0(index int) bool {
+	if index == len(m.value)-3 && m.contains(index-1, "ILLO", "ILLA", "ALLE") {
+		return true
+	}
+	return (m.contains(len(m.value)-2, "AS", "OS") || m.contains(len(m.value)-1, "A", "O")) && m.contains(index-1, "ALLE")
+}
+
+func (m *metaphone) conditionM0(index int) bool {
+	if m.at(index+1) == 'M' {
+		return true
+	}
+	return m.contains(index-1, "UMB") && (index+1 == len(m.value)-1 || m.contains(index+2, "ER"))
+}
+
+func (m *metaphone) handleP(index int) int {
+	if m.at(index+1) == 'H' {
+		m.add("F")
+		return index + 2
+	}
+	m.add("P")
+	if m.contains(index+1, "P", "B") {
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleR(index int) int {
+	if index == len(m.value)-1 && !m.slavoGermanic && m.contains(index-2, "IE") && !m.contains(index-4, "ME", "MA") {
+		// French "rogier"
+		m.addBoth("", "R")
+	} else {
+		m.add("R")
+	}
+	return m.skipDouble(index, 'R')
+}
+
+func (m *metaphone) handleS(index int) int {
+	switch {
+	case m.contains(index-1, "ISL", "YSL"):
+		// "island", "isle", "carlisle", "carlysle"
+		return index + 1
+	case index == 0 && m.contains(index, "SUGAR"):
+		m.addBoth("X", "S")
+		return index + 1
+	case m.contains(index, "SH"):
+		if m.contains(index+1, "HEIM", "HOEK", "HOLM", "HOLZ") {
+			// germanic
+			m.add("S")
+		} else {
+			m.add("X")
+		}
+		return index + 2
+	case m.contains(index, "SIO", "SIA") || m.contains(index, "SIAN"):
+		// Italian and Armenian
+		if m.slavoGermanic {
+			m.add("S")
+		} else {
+			m.addBoth("S", "X")
+		}
+		return index + 3
+	case (index == 0 && m.contains(index+1, "M", "N", "L", "W")) || m.contains(index+1, "Z"):
+		// German and anglicisations, "smith" matches "schmidt"
+		m.addBoth("S", "X")
+		if m.contains(index+1, "Z") {
+			return index + 2
+		}
+		return index + 1
+	case m.contains(index, "SC"):
+		return m.handleSC(index)
+	}
+
+	if index == len(m.value)-1 && m.contains(index-2, "AI", "OI") {
+		// French "resnais", "artois"
+		m.addBoth("", "S")
+	} else {
+		m.add("S")
+	}
+	if m.contains(index+1, "S", "Z") {
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleSC(index int) int {
+	if m.at(index+2) == 'H' {
+		// Schlesinger's rule
+		if m.contains(index+3, "OO", "ER", "EN", "UY", "ED", "EM") {
+			// Dutch origin, "school", "schooner"
+			if m.contains(index+3, "ER", "EN") {
+				// "schermerhorn", "schenker"
+				m.addBoth("X", "SK")
+			} else {
+				m.add("SK")
+			}
+		} else if index == 0 && !m.vowel(3) && m.at(3) != 'W' {
+			m.addBoth("X", "S")
+		} else {
+			m.add("X")
+		}
+	} else if m.contains(index+2, "I", "E", "Y") {
+		m.add("S")
+	} else {
+		m.add("SK")
+	}
+	return index + 3
+}
+
+func (m *metaphone) handleT(index int) int {
+	switch {
+	case m.contains(index, "TION"), m.contains(index, "TIA", "TCH"):
+		m.add("X")
+		return index + 3
+	case m.contains(index, "TH") || m.contains(index, "TTH"):
+		if m.contains(index+2, "OM", "AM") || m.germanic() {
+			// "thomas", "thames" or germanic
+			m.add("T")
+		} else {
+			// "0" is the "th" sound
+			m.addBoth("0", "T")
+		}
+		return index + 2
+	}
+	m.add("T")
+	if m.contains(index+1, "T", "D") {
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleW(index int) int {
+	switch {
+	case m.contains(index, "WR"):
+		// can also be in the middle of a word
+		m.add("R")
+		return index + 2
+	case index == 0 && (m.vowel(index+1) || m.contains(index, "WH")):
+		if m.vowel(index + 1) {
+			// "Wasserman" should match "Vasserman"
+			m.addBoth("A", "F")
+		} else {
+			// "Uomo" should match "Womo"
+			m.add("A")
+		}
+		return index + 1
+	case (index == len(m.value)-1 && m.vowel(index-1)) ||
+		m.contains(index-1, "EWSKI", "EWSKY", "OWSKI", "OWSKY") ||
+		m.contains(0, "SCH"):
+		// "Arnow" should match "Arnoff"
+		m.addBoth("", "F")
+		return index + 1
+	case m.contains(index, "WICZ", "WITZ"):
+		// Polish, "filipowicz"
+		m.addBoth("TS", "FX")
+		return index + 4
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleX(index int) int {
+	if index == 0 {
+		m.add("S")
+		return index + 1
+	}
+
+	if !(index == len(m.value)-1 && (m.contains(index-3, "IAU", "EAU") || m.contains(index-2, "AU", "OU"))) {
+		// not French, "breaux"
+		m.add("KS")
+	}
+	if m.contains(index+1, "C", "X") {
+		return index + 2
+	}
+	return index + 1
+}
+
+func (m *metaphone) handleZ(index int) int {
+	if m.at(index+1) == 'H' {
+		// Chinese pinyin, "zhao"
+		m.add("J")
+		return index + 2
+	}
+
+	if m.contains(index+1, "ZO", "ZI", "ZA") || (m.slavoGermanic && index > 0 && m.at(index-1) != 'T') {
+		m.addBoth("S", "TS")
+	} else {
+		m.add("S")
+	}
+	return m.skipDouble(index, 'Z')
+}
+
+// foldASCII upper-cases a word and strips the accents from Latin letters, so
+// that "Montréal" is encoded like "Montreal". Ç and Ñ keep their own sounds.
+func foldASCII(word string) string {
+	var folded strings.Builder
+	for _, r := range strings.ToUpper(word) {
+		switch {
+		case r < unicode.MaxASCII:
+			folded.WriteRune(r)
+		case r == 'Ç':
+			folded.WriteByte('S')
+		case r == 'Ñ':
+			folded.WriteByte('N')
+		default:
+			if base, found := accents[r]; found {
+				folded.WriteByte(base)
+			}
+		}
+	}
+	return folded.String()
+}
+
+var accents = map[rune]byte{
+	'À': 'A', 'Á': 'A', 'Â': 'A', 'Ã': 'A', 'Ä': 'A', 'Å': 'A',
+	'È': 'E', 'É': 'E', 'Ê': 'E', 'Ë': 'E',
+	'Ì': 'I', 'Í': 'I', 'Î': 'I', 'Ï': 'I',
+	'Ò': 'O', 'Ó': 'O', 'Ô': 'O', 'Õ': 'O', 'Ö': 'O', 'Ø': 'O',
+	'Ù': 'U', 'Ú': 'U', 'Û': 'U', 'Ü': 'U',
+	'Ý': 'Y', 'Ÿ': 'Y',
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDoubleMetaphone(t *testing.T) {
+	tests := map[string]struct {
+		primary, alternate string
+	}{
+		"Cheyenne":     {"XN", "XN"},
+		"Shyenne":      {"XN", "XN"},
+		"Albuquerque":  {"ALPKRK", "ALPKRK"},
+		"Albukerkee":   {"ALPKRK", "ALPKRK"},
+		"Philadelphia": {"FLTLF", "FLTLF"},
+		"Montréal":     {"MNTRL", "MNTRL"},
+		"Knoxville":    {"NKSFL", "NKSFL"},
+		"Smith":        {"SM0", "XMT"},
+		"Schmidt":      {"XMT", "SMT"},
+		"Michael":      {"MKL", "MXL"},
+		"Thomas":       {"TMS", "TMS"},
+		"Jose":         {"HS", "HS"},
+		"":             {"", ""},
+	}
+	for word, tt := range tests {
+		t.Run(word, func(t *testing.T) {
+			primary, alternate := DoubleMetaphone(word)
+			if primary != tt.primary || alternate != tt.alternate {
+				t.Errorf("%#v != %#v", []string{primary, alternate}, []string{tt.primary, tt.alternate})
+			}
+		})
+	}
+}
+
+func TestPhoneticKeys(t *testing.T) {
+	tests := map[string][]string{
+		"cheyenne":     {"xn"},
+		"smith falls":  {"sm0 fls", "xmt fls"},
+		"mile 100":     {"ml"},
+		"100":          nil,
+		"saint-george": {"snt jrj", "snt krk"},
+	}
+	for key, expected := range tests {
+		t.Run(key, func(t *testing.T) {
+			if actual := PhoneticKeys(key); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%#v != %#v", actual, expected)
+			}
+		})
+	}
+}
+
+func TestTrie_FindPhoneticMatches(t *testing.T) {
+	cheyenne := Location{Name: "Cheyenne", ID: "1"}
+	albuquerque := Location{Name: "Albuquerque", ID: "2"}
+	smithFalls := Location{Name: "Smiths Falls", ID: "3"}
+	fortStJohn := Location{Name: "Fort St. John", ID: "4"}
+
+	// locations inserted before and after enabling are both indexed
+	tree := makeTrie(cheyenne, albuquerque)
+	tree.EnablePhonetic()
+	tree.Insert(smithFalls.Name, smithFalls)
+	tree.Insert(fortStJohn.Name, fortStJohn)
+
+	tests := map[string][]Match{
+		"shyenne":      {{cheyenne, 0, true}},
+		"albukerkee":   {{albuquerque, 0, true}},
+		"albuk":        {{albuquerque, 0, true}},
+		"schmidt":      {{smithFalls, 0, true}},
+		"fawls":        {{smithFalls, 1, true}},
+		"ft saint jon": {{fortStJohn, 0, true}},
+		"zzz":          {},
+	}
+	for query, expected := range tests {
+		t.Run(query, func(t *testing.T) {
+			if actual := tree.FindPhoneticMatches(query, 0); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%#v != %#v", actual, expected)
+			}
+		})
+	}
+
+	tree.Remove(cheyenne.ID)
+	if actual := tree.FindPhoneticMatches("shyenne", 0); len(actual) != 0 {
+		t.Errorf("removed locations should not be found: %#v", actual)
+	}
+
+	if actual := makeTrie(cheyenne).FindPhoneticMatches("shyenne", 0); len(actual) != 0 {
+		t.Errorf("phonetic matches should only be found when enabled: %#v", actual)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package models
+
+import "os"
+
+// mapFile reads a whole file into memory, on platforms where it isn't mapped.
+func mapFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
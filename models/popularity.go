@@ -0,0 +1,263 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Popularity counts how often each location is selected from the suggestions,
+// in all and for each of the most recent queries it was selected after. Older selections count for
+// less: each one's weight halves every half-life, so a place that was popular
+// last year doesn't outrank the ones users pick now. It's safe for concurrent
+// use.
+type Popularity struct {
+	mu         sync.RWMutex
+	halfLife   time.Duration
+	counts     map[string]popularityCount
+	prefixes   map[string]map[string]popularityCount // by normalized query, then ID
+	selections uint64                                // recorded since it was created or read
+}
+
+// A popularityCount is a location's count of selections, decayed to a time.
+type popularityCount struct {
+	count float64
+	at    time.Time
+}
+
+// DefaultPopularityHalfLife is how long it takes a selection to count for half
+// as much, unless the server is told otherwise.
+const DefaultPopularityHalfLife = 30 * 24 * time.Hour
+
+// At most this many queries have their own counts. Any query can be sent, so
+// the least recently selected one is forgotten to make room for a new one.
+const maxPopularityPrefixes = 10000
+
+// Counts that have decayed below this (a single selection after about 7
+// half-lives) are forgotten when the counts are written.
+const minPopularityCount = 0.01
+
+func NewPopularity(halfLife time.Duration) *Popularity {
+	return &Popularity{
+		halfLife: halfLife,
+		counts:   make(map[string]popularityCount),
+		prefixes: make(map[string]map[string]popularityCount),
+	}
+}
+
+// Select records a selection of the location with a GeoNames id from the
+// suggestions for a query.
+func (popularity *Popularity) Select(query, id string, at time.Time) {
+	popularity.mu.Lock()
+	defer popularity.mu.Unlock()
+
+	popularity.counts[id] = popularityCount{count: popularity.decay(popularity.counts[id], at) + 1, at: at}
+	if prefix := normalizePrefix(query); prefix != "" {
+		counts := popularity.prefixes[prefix]
+		if counts == nil {
+			for len(popularity.prefixes) >= maxPopularityPrefixes {
+				popularity.evictPrefix()
+			}
+			counts = make(map[string]popularityCount)
+			popularity.prefixes[prefix] = counts
+		}
+		counts[id] = popularityCount{count: popularity.decay(counts[id], at) + 1, at: at}
+	}
+	popularity.selections++
+}
+
+// evictPrefix forgets the counts of the query that was selected after least
+// recently. It looks at every count, but only runs when a new query is
+// selected after with the prefixes full.
+func (popularity *Popularity) evictPrefix() {
+	oldest, oldestAt := "", time.Time{}
+	for prefix, counts := range popularity.prefixes {
+		at := time.Time{}
+		for _, count := range counts {
+			if count.at.After(at) {
+				at = count.at
+			}
+		}
+		if oldest == "" || at.Before(oldestAt) {
+			oldest, oldestAt = prefix, at
+		}
+	}
+	delete(popularity.prefixes, oldest)
+}
+
+// normalizePrefix is how queries are told apart when counting selections:
+// by their text without qualifiers, regardless of case and surrounding space,
+// which is what a scorer is given.
+func normalizePrefix(query string) string {
+	return strings.ToLower(strings.TrimSpace(ParseQuery(query).Text))
+}
+
+// Count returns how many times a location has been selected, with each
+// selection weighted by how long before at it was.
+func (popularity *Popularity) Count(id string, at time.Time) float64 {
+	popularity.mu.RLock()
+	defer popularity.mu.RUnlock()
+
+	return popularity.decay(popularity.counts[id], at)
+}
+
+// PrefixCount returns how many times a location has been selected after a
+// query, weighted like Count.
+func (popularity *Popularity) PrefixCount(query, id string, at time.Time) float64 {
+	popularity.mu.RLock()
+	defer popularity.mu.RUnlock()
+
+	return popularity.decay(popularity.prefixes[normalizePrefix(query)][id], at)
+}
+
+// Selections returns how many selections have been recorded since the counts
+// were created or read. It only goes up, so it shows when there are new ones.
+func (popularity *Popularity) Selections() uint64 {
+	popularity.mu.RLock()
+	defer popularity.mu.RUnlock()
+
+	return popularity.selections
+}
+
+// Len returns how many locations have been selected.
+func (popularity *Popularity) Len() int {
+	popularity.mu.RLock()
+	defer popularity.mu.RUnlock()
+
+	return len(popularity.counts)
+}
+
+// decay returns a count as of a later time. A count from later than at is
+// returned as it is, since clocks can go backwards.
+func (popularity *Popularity) decay(count popularityCount, at time.Time) float64 {
+	elapsed := at.Sub(count.at)
+	if elapsed <= 0 || count.count == 0 {
+		return count.count
+	}
+	return count.count * math.Exp2(-float64(elapsed)/float64(popularity.halfLife))
+}
+
+// popularityFile is how counts are written out: each decayed to the same time.
+// Prefixes holds the counts for each query, by ID.
+type popularityFile struct {
+	At       time.Time                     `json:"at"`
+	Counts   map[string]float64            `json:"counts"`
+	Prefixes map[string]map[string]float64 `json:"prefixes,omitempty"`
+}
+
+// Write writes out the counts as of a time, as JSON, and forgets the ones too
+// small to matter any more.
+func (popularity *Popularity) Write(w io.Writer, at time.Time) error {
+	popularity.mu.Lock()
+	file := popularityFile{
+		At:       at,
+		Counts:   popularity.decayAll(popularity.counts, at),
+		Prefixes: make(map[string]map[string]float64, len(popularity.prefixes)),
+	}
+	for prefix, counts := range popularity.prefixes {
+		if decayed := popularity.decayAll(counts, at); len(decayed) > 0 {
+			file.Prefixes[prefix] = decayed
+		} else {
+			delete(popularity.prefixes, prefix)
+		}
+	}
+	popularity.mu.Unlock()
+
+	return json.NewEncoder(w).Encode(file)
+}
+
+// decayAll returns counts as of a time, and forgets the ones too small to
+// matter any more.
+func (popularity *Popularity) decayAll(counts map[string]popularityCount, at time.Time) map[string]float64 {
+	decayed := make(map[string]float64, len(counts))
+	for id, count := range counts {
+		if count := popularity.decay(count, at); count >= minPopularityCount {
+			decayed[id] = count
+		} else {
+			delete(counts, id)
+		}
+	}
+	return decayed
+}
+
+// ReadPopularity reads counts written by Popularity.Write, which go on
+// decaying with halfLife.
+func ReadPopularity(r io.Reader, halfLife time.Duration) (*Popularity, error) {
+	file := popularityFile{}
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid popularity file: %s", err)
+	}
+
+	popularity := NewPopularity(halfLife)
+	for id, count := range file.Counts {
+		if !validCount(count) {
+			return nil, fmt.Errorf("invalid popularity file: bad count for %q", id)
+		}
+		popularity.counts[id] = popularityCount{count: count, at: file.At}
+	}
+	for prefix, counts := range file.Prefixes {
+		popularity.prefixes[prefix] = make(map[string]popularityCount, len(counts))
+		for id, count := range counts {
+			if !validCount(count) {
+				return nil, fmt.Errorf("invalid popularity file: bad count for %q after %q", id, prefix)
+			}
+			popularity.prefixes[prefix][id] = popularityCount{count: count, at: file.At}
+		}
+	}
+	return popularity, nil
+}
+
+func validCount(count float64) bool {
+	return count >= 0 && !math.IsInf(count, 0)
+}
+
+// A PopularityScorer scores results by how often they've been selected
+// recently, so the places users actually pick rank first. Selections after
+// the same query count twice, since they say the most about what the user
+// means by it.
+type PopularityScorer struct {
+	popularity *Popularity
+	query      string
+	at         time.Time
+}
+
+// A location selected this many times recently gets half the full popularity
+// score, and it approaches 1 with more.
+const halfPopularityCount = 10
+
+func NewPopularityScorer(popularity *Popularity, query string, at time.Time) *PopularityScorer {
+	return &PopularityScorer{popularity: popularity, query: query, at: at}
+}
+
+func (scorer *PopularityScorer) Score(location Location) float64 {
+	count, prefixCount := scorer.counts(location)
+	return (count + prefixCount) / (count + prefixCount + halfPopularityCount)
+}
+
+// counts returns the location's recent selections, and those after the query.
+func (scorer *PopularityScorer) counts(location Location) (float64, float64) {
+	return scorer.popularity.Count(location.ID, scorer.at), scorer.popularity.PrefixCount(scorer.query, location.ID, scorer.at)
+}
+
+func (scorer *PopularityScorer) Explain(location Location) Explanation {
+	count, prefixCount := scorer.counts(location)
+	weighted := count + prefixCount
+	return Explanation{
+		Value: scorer.Score(location),
+		Description: fmt.Sprintf("%.3g / (%.3g + %d), for %.3g recent selections and %.3g more after the same query",
+			weighted, weighted, halfPopularityCount, count, prefixCount),
+	}
+}
+
+// MaxScore is 1, which a location selected infinitely often approaches. Names
+// don't bound popularity.
+func (scorer *PopularityScorer) MaxScore(minLength int) float64 {
+	return 1.0
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPopularity_Count(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	popularity := NewPopularity(24 * time.Hour)
+	popularity.Select("spr", "1", start)
+	popularity.Select("spr", "1", start)
+	popularity.Select("spr", "2", start.Add(24*time.Hour))
+
+	tests := map[string]struct {
+		id       string
+		at       time.Time
+		expected float64
+	}{
+		"when selected":             {"1", start, 2},
+		"after a half-life":         {"1", start.Add(24 * time.Hour), 1},
+		"after two half-lives":      {"1", start.Add(48 * time.Hour), 0.5},
+		"selected later":            {"2", start.Add(48 * time.Hour), 0.5},
+		"before it was selected":    {"2", start, 1},
+		"never selected":            {"3", start, 0},
+		"half a half-life later":    {"1", start.Add(12 * time.Hour), 2 * math.Sqrt2 / 2},
+		"long after it was popular": {"1", start.Add(240 * time.Hour), 2.0 / 1024},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := popularity.Count(tt.id, tt.at); math.Abs(actual-tt.expected) > 1e-9 {
+				t.Errorf("%#v != %#v", actual, tt.expected)
+			}
+		})
+	}
+
+	// selecting again adds to what's left of the old selections
+	popularity.Select("Springf ", "1", start.Add(24*time.Hour))
+	if actual := popularity.Count("1", start.Add(24*time.Hour)); math.Abs(actual-2) > 1e-9 {
+		t.Errorf("%#v != %#v", actual, 2.0)
+	}
+
+	// and it's counted separately for each query
+	for query, expected := range map[string]float64{"spr": 1, "springf": 1, "SPRINGF": 1, "sp": 0} {
+		if actual := popularity.PrefixCount(query, "1", start.Add(24*time.Hour)); math.Abs(actual-expected) > 1e-9 {
+			t.Errorf("%s: %#v != %#v", query, actual, expected)
+		}
+	}
+	if actual := popularity.Selections(); actual != 4 {
+		t.Errorf("%#v != %#v", actual, 4)
+	}
+}
+
+func TestPopularity_SelectEvicts(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	popularity := NewPopularity(24 * time.Hour)
+	for i := 0; i < maxPopularityPrefixes; i++ {
+		popularity.Select(fmt.Sprintf("q%d", i), "1", start.Add(time.Duration(i)*time.Second))
+	}
+	popularity.Select("q0", "1", start.Add(time.Hour))
+
+	// the least recently selected after is forgotten for a new query
+	popularity.Select("new", "1", start.Add(time.Hour))
+	if actual := len(popularity.prefixes); actual != maxPopularityPrefixes {
+		t.Errorf("%#v != %#v", actual, maxPopularityPrefixes)
+	}
+	for query, expected := range map[string]bool{"q0": true, "q1": false, "q2": true, "new": true} {
+		if actual := popularity.PrefixCount(query, "1", start.Add(time.Hour)) > 0; actual != expected {
+			t.Errorf("%s: %#v != %#v", query, actual, expected)
+		}
+	}
+}
+
+func TestPopularity_WriteRead(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	popularity := NewPopularity(24 * time.Hour)
+	for i := 0; i < 4; i++ {
+		popularity.Select("spr", "1", start)
+	}
+	popularity.Select("spr", "2", start.Add(-240*time.Hour))
+
+	buffer := &bytes.Buffer{}
+	if err := popularity.Write(buffer, start.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if popularity.Len() != 1 {
+		t.Errorf("counts too small to matter should be forgotten, have %d", popularity.Len())
+	}
+
+	read, err := ReadPopularity(buffer, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := read.Count("1", start.Add(48*time.Hour)); math.Abs(actual-1) > 1e-9 {
+		t.Errorf("%#v != %#v", actual, 1.0)
+	}
+	if actual := read.Count("2", start.Add(24*time.Hour)); actual != 0 {
+		t.Errorf("%#v != %#v", actual, 0.0)
+	}
+	if actual := read.PrefixCount("spr", "1", start.Add(48*time.Hour)); math.Abs(actual-1) > 1e-9 {
+		t.Errorf("%#v != %#v", actual, 1.0)
+	}
+	if actual := read.PrefixCount("spr", "2", start.Add(24*time.Hour)); actual != 0 {
+		t.Errorf("%#v != %#v", actual, 0.0)
+	}
+	if read.Selections() != 0 {
+		t.Errorf("nothing has been selected since reading")
+	}
+
+	for name, file := range map[string]string{
+		"not JSON":              "counts",
+		"unknown field":         `{"at": "2026-01-01T00:00:00Z", "total": 3}`,
+		"negative count":        `{"at": "2026-01-01T00:00:00Z", "counts": {"1": -1}}`,
+		"negative prefix count": `{"at": "2026-01-01T00:00:00Z", "counts": {}, "prefixes": {"spr": {"1": -1}}}`,
+	} {
+		if _, err := ReadPopularity(bytes.NewBufferString(file), time.Hour); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPopularityScorer(t *testing.T) {
+	now := time.Now()
+	popularity := NewPopularity(time.Hour)
+	for i := 0; i < 10; i++ {
+		popularity.Select("spr", "1", now)
+	}
+	popularity.Select("spr", "2", now)
+
+	tests := map[string]struct {
+		query    string
+		id       string
+		expected float64
+	}{
+		"popular":                  {"spr", "1", 20.0 / 30},
+		"selected":                 {"spr", "2", 2.0 / 12},
+		"popular after others":     {"springf", "1", 0.5},
+		"selected after others":    {"springf", "2", 1.0 / 11},
+		"no one's":                 {"spr", "3", 0},
+		"popular, with qualifiers": {"Spr, MA", "1", 20.0 / 30},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			scorer := NewPopularityScorer(popularity, tt.query, now)
+			location := Location{ID: tt.id}
+			if actual := scorer.Score(location); math.Abs(actual-tt.expected) > 1e-9 {
+				t.Errorf("%#v != %#v", actual, tt.expected)
+			}
+			if explanation := scorer.Explain(location); explanation.Value != scorer.Score(location) {
+				t.Errorf("%#v != %#v", explanation.Value, scorer.Score(location))
+			}
+		})
+	}
+
+	profile := &Profile{Functions: []*ScoreFunction{{Type: FunctionText}, {Type: FunctionPopularity}}}
+	if err := profile.check(); err != nil {
+		t.Fatal(err)
+	}
+	name := "Springfield"
+	// the profile scores as of now, a moment after the scorer above
+	actual := profile.Scorer(name, ScoringContext{Popularity: popularity}).Score(Location{ID: "1", Name: name})
+	if expected := (1 + NewPopularityScorer(popularity, name, now).Score(Location{ID: "1"})) / 2; math.Abs(actual-expected) > 1e-3 {
+		t.Errorf("%#v != %#v", actual, expected)
+	}
+	if profile.Scorer(name, ScoringContext{}).Score(Location{ID: "1", Name: name}) != 1 {
+		t.Errorf("popularity shouldn't apply when selections aren't counted")
+	}
+}
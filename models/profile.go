@@ -0,0 +1,403 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Profiles are named ways of scoring results, so that different clients can
+// rank the same matches differently: a booking form might want prominent
+// places first, and a delivery app the closest. They're read from a JSON config
+// like:
+//
+//	{"profiles": {
+//	    "prominence": {
+//	        "functions": [{"type": "text"}, {"type": "population", "weight": 2}],
+//	        "boosts": [{"feature_codes": ["PPLC", "PPLA"], "factor": 1.5}]
+//	    },
+//	    "local": {
+//	        "mode": "multiply",
+//	        "functions": [{"type": "distance", "weight": 4}, {"type": "text"}]
+//	    }
+//	}}
+//
+// A "default" profile is used when none is asked for. If the config doesn't
+// have one, the built-in default scores by distance when there are coordinates
+// and by length otherwise.
+type Profiles struct {
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+// A Profile combines the scores of several functions, and multiplies the
+// result by the factor of every boost that a location matches.
+type Profile struct {
+	// How the functions are combined:
+	//   - "avg" (the default) is their average, weighted
+	//   - "multiply" is their product, each to the power of its weight
+	//   - "first" is the first that applies, times its weight
+	Mode      string           `json:"mode"`
+	Functions []*ScoreFunction `json:"functions"`
+	Boosts    []*Boost         `json:"boosts"`
+
+	// Locations outside the countries a request prefers have their scores
+	// multiplied by this (default 0.5, at most 1, so scores stay between 0
+	// and 1). Requests say which with prefer_country, or, if InferCountry is
+	// set, with the region of their Accept-Language.
+	OtherCountryFactor float64 `json:"other_country_factor"`
+	InferCountry       bool    `json:"infer_country"`
+}
+
+// A ScoreFunction is one part of a profile's score. Functions that don't apply
+// to a request (distance without coordinates or an origin, viewport without a
+// viewport) are left out, and if none apply, every location scores the same.
+type ScoreFunction struct {
+	// "text" scores by length relative to the query (or, for names that only
+	// sound like it, by how close they sound, and for what an alias stands
+	// for, as an exact match), "distance" by distance from the request's
+	// coordinates, "population" by population, "viewport" by closeness to the
+	// request's viewport and prominence, "popularity" by how often users have
+	// selected the location recently, and "learned" by weights trained on
+	// what users selected (if the server has them).
+	Type   string  `json:"type"`
+	Weight float64 `json:"weight"` // defaults to 1
+
+	// How a distance function's score falls off with distance. Without one,
+	// it's linear out to the far side of the Earth.
+	Decay *Decay `json:"decay"`
+}
+
+// A Boost multiplies the scores of the locations that match its conditions
+// (the same as a filter rule's) by its factor. A factor under 1 demotes them.
+type Boost struct {
+	FilterRule
+	Factor float64 `json:"factor"`
+
+	others bool // whether it's the locations that don't match that it applies to
+}
+
+// applies reports whether the boost applies to a location.
+func (boost *Boost) applies(location Location) bool {
+	return boost.Match(location) != boost.others
+}
+
+// Profile combination modes
+const (
+	ModeAverage  = "avg"
+	ModeMultiply = "multiply"
+	ModeFirst    = "first"
+)
+
+// Score function types
+const (
+	FunctionText       = "text"
+	FunctionDistance   = "distance"
+	FunctionPopulation = "population"
+	FunctionViewport   = "viewport"
+	FunctionPopularity = "popularity"
+	FunctionLearned    = "learned"
+)
+
+// DefaultProfileName is the profile used when a request doesn't ask for one.
+const DefaultProfileName = "default"
+
+// DefaultOtherCountryFactor is how much locations outside the preferred
+// countries are demoted by, unless a profile says otherwise.
+const DefaultOtherCountryFactor = 0.5
+
+// DefaultProfile scores by viewport when there is one, by distance when there
+// are coordinates, and by length relative to the query otherwise.
+func DefaultProfile() *Profile {
+	return &Profile{
+		Mode: ModeFirst,
+		Functions: []*ScoreFunction{
+			{Type: FunctionViewport, Weight: 1},
+			{Type: FunctionDistance, Weight: 1},
+			{Type: FunctionText, Weight: 1},
+		},
+		OtherCountryFactor: DefaultOtherCountryFactor,
+	}
+}
+
+// DefaultProfiles only has the default profile.
+func DefaultProfiles() *Profiles {
+	return &Profiles{Profiles: map[string]*Profile{DefaultProfileName: DefaultProfile()}}
+}
+
+// ReadProfiles parses and checks a JSON profiles config.
+func ReadProfiles(file io.Reader) (*Profiles, error) {
+	profiles := &Profiles{}
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(profiles); err != nil {
+		return nil, fmt.Errorf("invalid profiles config: %s", err)
+	}
+
+	if profiles.Profiles == nil {
+		profiles.Profiles = make(map[string]*Profile)
+	}
+	if _, found := profiles.Profiles[DefaultProfileName]; !found {
+		profiles.Profiles[DefaultProfileName] = DefaultProfile()
+	}
+	for name, profile := range profiles.Profiles {
+		if err := profile.check(); err != nil {
+			return nil, fmt.Errorf("invalid profile %q: %s", name, err)
+		}
+	}
+
+	return profiles, nil
+}
+
+// check validates a profile and fills in its defaults.
+func (profile *Profile) check() error {
+	switch profile.Mode {
+	case "":
+		profile.Mode = ModeAverage
+	case ModeAverage, ModeMultiply, ModeFirst:
+	default:
+		return fmt.Errorf("unknown mode %q", profile.Mode)
+	}
+
+	if len(profile.Functions) == 0 {
+		return fmt.Errorf("no functions")
+	}
+	for _, function := range profile.Functions {
+		switch function.Type {
+		case FunctionText, FunctionDistance, FunctionPopulation, FunctionViewport, FunctionPopularity, FunctionLearned:
+		default:
+			return fmt.Errorf("unknown function type %q", function.Type)
+		}
+		if function.Weight < 0 {
+			return fmt.Errorf("negative weight for %s", function.Type)
+		}
+		if function.Weight == 0 {
+			function.Weight = 1
+		}
+		if function.Decay != nil {
+			if function.Type != FunctionDistance {
+				return fmt.Errorf("only distance functions decay, not %s", function.Type)
+			}
+			if err := function.Decay.check(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if profile.OtherCountryFactor < 0 || profile.OtherCountryFactor > 1 {
+		return fmt.Errorf("other_country_factor has to be between 0 and 1")
+	}
+	if profile.OtherCountryFactor == 0 {
+		profile.OtherCountryFactor = DefaultOtherCountryFactor
+	}
+
+	for i, boost := range profile.Boosts {
+		if boost.Factor <= 0 {
+			return fmt.Errorf("boost %d needs a positive factor", i+1)
+		}
+		if boost.Name == "" {
+			boost.Name = fmt.Sprintf("boost %d", i+1)
+		}
+	}
+
+	return nil
+}
+
+// Get returns a profile by name, or the default profile for "".
+func (profiles *Profiles) Get(name string) (*Profile, bool) {
+	if name == "" {
+		name = DefaultProfileName
+	}
+	profile, found := profiles.Profiles[name]
+	return profile, found
+}
+
+// A ScoringContext is what a request says about where the user is, and what
+// the server has learned from other users.
+type ScoringContext struct {
+	Lat, Long       *float64    // the user's coordinates, if known
+	Viewport        *Viewport   // the map they're looking at, if any
+	PreferCountries []string    // ISO-3166 codes of the countries they'd rather see
+	Popularity      *Popularity // what users have selected, if it's being counted
+	Weights         *Weights    // learned from what users selected, if any
+	Phonetic        bool        // whether the matches only sound like the query
+	Alias           bool        // whether the matches are what an alias like "NYC" stands for
+}
+
+// Scorer returns the scorer for a query with this profile.
+func (profile *Profile) Scorer(text string, request ScoringContext) Scorer {
+	lat, long := request.Lat, request.Long
+
+	scorer := &ProfileScorer{mode: profile.Mode, boosts: profile.Boosts}
+	if len(request.PreferCountries) > 0 {
+		others := &Boost{
+			FilterRule: FilterRule{Name: "not a preferred country", Countries: request.PreferCountries},
+			Factor:     profile.OtherCountryFactor,
+			others:     true,
+		}
+		scorer.boosts = append(append([]*Boost{}, profile.Boosts...), others)
+	}
+
+	for _, function := range profile.Functions {
+		var part Scorer
+		switch function.Type {
+		case FunctionText:
+			switch {
+			case request.Alias:
+				part = &AliasScorer{}
+			case request.Phonetic:
+				part = NewPhoneticScorer(text)
+			default:
+				part = NewRelativeLengthScorer(text)
+			}
+		case FunctionDistance:
+			decay := function.Decay
+			switch {
+			case decay != nil && decay.Origin != nil:
+				part = NewDecayScorer(decay.Origin.Lat, decay.Origin.Long, decay)
+			case lat == nil || long == nil:
+				continue
+			case decay != nil:
+				part = NewDecayScorer(*lat, *long, decay)
+			default:
+				part = NewGeoDistanceScorer(*lat, *long)
+			}
+		case FunctionPopulation:
+			part = &PopulationScorer{}
+		case FunctionViewport:
+			if request.Viewport == nil {
+				continue
+			}
+			part = NewViewportScorer(request.Viewport)
+		case FunctionPopularity:
+			if request.Popularity == nil {
+				continue
+			}
+			part = NewPopularityScorer(request.Popularity, text, time.Now())
+		case FunctionLearned:
+			if request.Weights == nil {
+				continue
+			}
+			part = NewLearnedScorer(request.Weights, text, lat, long)
+		}
+		scorer.parts = append(scorer.parts, weightedScorer{part.(BoundedScorer), function.Weight})
+
+		if profile.Mode == ModeFirst {
+			break
+		}
+	}
+
+	// a single function with nothing to combine it with is just that function
+	if len(scorer.parts) == 1 && scorer.parts[0].weight == 1 && len(scorer.boosts) == 0 {
+		return scorer.parts[0].scorer
+	}
+	return scorer
+}
+
+// A ProfileScorer combines the scores of other scorers as a profile says.
+type ProfileScorer struct {
+	mode   string
+	parts  []weightedScorer
+	boosts []*Boost
+}
+
+type weightedScorer struct {
+	scorer BoundedScorer
+	weight float64
+}
+
+func (scorer *ProfileScorer) Score(location Location) float64 {
+	return scorer.combine(func(part Scorer) float64 {
+		return part.Score(location)
+	}) * scorer.boost(location)
+}
+
+// MaxScore combines the highest score each part can give a name of at least
+// minLength, with every boost that raises scores.
+func (scorer *ProfileScorer) MaxScore(minLength int) float64 {
+	score := scorer.combine(func(part Scorer) float64 {
+		return part.(BoundedScorer).MaxScore(minLength)
+	})
+	for _, boost := range scorer.boosts {
+		score *= math.Max(boost.Factor, 1)
+	}
+	return score
+}
+
+// combine combines the scores of the parts by the profile's mode.
+func (scorer *ProfileScorer) combine(score func(Scorer) float64) float64 {
+	if len(scorer.parts) == 0 {
+		return 1
+	}
+
+	switch scorer.mode {
+	case ModeFirst:
+		return scorer.parts[0].weight * score(scorer.parts[0].scorer)
+	case ModeMultiply:
+		product := 1.0
+		for _, part := range scorer.parts {
+			product *= math.Pow(score(part.scorer), part.weight)
+		}
+		return product
+	}
+
+	sum, weights := 0.0, 0.0
+	for _, part := range scorer.parts {
+		sum += part.weight * score(part.scorer)
+		weights += part.weight
+	}
+	return sum / weights
+}
+
+// boost returns the product of the factors of the boosts that match.
+func (scorer *ProfileScorer) boost(location Location) float64 {
+	factor := 1.0
+	for _, boost := range scorer.boosts {
+		if boost.applies(location) {
+			factor *= boost.Factor
+		}
+	}
+	return factor
+}
+
+func (scorer *ProfileScorer) Explain(location Location) Explanation {
+	details := []Explanation{}
+	for _, part := range scorer.parts {
+		explanation := Explain(part.scorer, location)
+		if part.weight != 1 {
+			explanation.Description += fmt.Sprintf(" (weight %g)", part.weight)
+		}
+		details = append(details, explanation)
+	}
+
+	descriptions := map[string]string{
+		ModeAverage:  "weighted average of:",
+		ModeMultiply: "product of, each to the power of its weight:",
+		ModeFirst:    "weight times:",
+	}
+	combined := Explanation{
+		Value:       scorer.combine(func(part Scorer) float64 { return part.Score(location) }),
+		Description: descriptions[scorer.mode],
+		Details:     details,
+	}
+	if len(scorer.parts) == 0 {
+		combined.Description = "no score functions apply"
+	}
+	if len(scorer.boosts) == 0 {
+		return combined
+	}
+
+	boosted := []Explanation{combined}
+	for _, boost := range scorer.boosts {
+		if boost.applies(location) {
+			boosted = append(boosted, Explanation{Value: boost.Factor, Description: boost.Name})
+		}
+	}
+	return Explanation{
+		Value:       scorer.Score(location),
+		Description: "product of:",
+		Details:     boosted,
+	}
+}
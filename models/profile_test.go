@@ -0,0 +1,222 @@
+package models
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadProfiles(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		valid  bool
+		names  []string
+	}{
+		"adds the default": {
+			`{"profiles": {"prominence": {"functions": [{"type": "population"}]}}}`,
+			true,
+			[]string{"default", "prominence"},
+		},
+		"replaces the default": {
+			`{"profiles": {"default": {"functions": [{"type": "text"}]}}}`,
+			true,
+			[]string{"default"},
+		},
+		"empty config": {
+			`{}`,
+			true,
+			[]string{"default"},
+		},
+		"unknown mode": {
+			`{"profiles": {"p": {"mode": "max", "functions": [{"type": "text"}]}}}`,
+			false,
+			nil,
+		},
+		"unknown function": {
+			`{"profiles": {"p": {"functions": [{"type": "elevation"}]}}}`,
+			false,
+			nil,
+		},
+		"no functions": {
+			`{"profiles": {"p": {}}}`,
+			false,
+			nil,
+		},
+		"negative weight": {
+			`{"profiles": {"p": {"functions": [{"type": "text", "weight": -1}]}}}`,
+			false,
+			nil,
+		},
+		"boost without a factor": {
+			`{"profiles": {"p": {"functions": [{"type": "text"}], "boosts": [{"countries": ["CA"]}]}}}`,
+			false,
+			nil,
+		},
+		"unknown field": {
+			`{"profiles": {"p": {"functions": [{"type": "text", "scale": 2}]}}}`,
+			false,
+			nil,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			profiles, err := ReadProfiles(strings.NewReader(tt.config))
+			if (err == nil) != tt.valid {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			for _, name := range tt.names {
+				if _, found := profiles.Get(name); !found {
+					t.Errorf("%q not found", name)
+				}
+			}
+			if len(profiles.Profiles) != len(tt.names) {
+				t.Errorf("%d profiles != %d", len(profiles.Profiles), len(tt.names))
+			}
+		})
+	}
+}
+
+func TestProfile_Scorer(t *testing.T) {
+	lat, long := 43.70011, -79.4163
+	toronto := Location{Name: "Toronto", Lat: 43.70011, Long: -79.4163, Country: "CA", Population: 2600000}
+	tampa := Location{Name: "Tampa", Lat: 27.94752, Long: -82.45843, Country: "US", FeatureCode: "PPLA2", Population: 335709}
+
+	text, distance := NewRelativeLengthScorer("t"), NewGeoDistanceScorer(lat, long)
+	population := &PopulationScorer{}
+
+	tests := map[string]struct {
+		profile   *Profile
+		lat, long *float64
+		expected  func(Location) float64
+	}{
+		"default without coordinates": {
+			DefaultProfile(), nil, nil,
+			text.Score,
+		},
+		"default with coordinates": {
+			DefaultProfile(), &lat, &long,
+			distance.Score,
+		},
+		"weighted average": {
+			&Profile{Mode: ModeAverage, Functions: []*ScoreFunction{{Type: FunctionText, Weight: 1}, {Type: FunctionPopulation, Weight: 3}}}, nil, nil,
+			func(location Location) float64 { return (text.Score(location) + 3*population.Score(location)) / 4 },
+		},
+		"product": {
+			&Profile{Mode: ModeMultiply, Functions: []*ScoreFunction{{Type: FunctionDistance, Weight: 2}, {Type: FunctionPopulation, Weight: 1}}}, &lat, &long,
+			func(location Location) float64 {
+				return math.Pow(distance.Score(location), 2) * population.Score(location)
+			},
+		},
+		"distance left out without coordinates": {
+			&Profile{Mode: ModeAverage, Functions: []*ScoreFunction{{Type: FunctionDistance, Weight: 5}, {Type: FunctionPopulation, Weight: 1}}}, nil, nil,
+			population.Score,
+		},
+		"nothing applies": {
+			&Profile{Mode: ModeFirst, Functions: []*ScoreFunction{{Type: FunctionDistance, Weight: 1}}}, nil, nil,
+			func(Location) float64 { return 1 },
+		},
+		"boosted": {
+			&Profile{
+				Mode:      ModeFirst,
+				Functions: []*ScoreFunction{{Type: FunctionText, Weight: 1}},
+				Boosts: []*Boost{
+					{FilterRule: FilterRule{Countries: []string{"CA"}}, Factor: 2},
+					{FilterRule: FilterRule{MinPopulation: 1000000}, Factor: 1.5},
+				},
+			},
+			nil, nil,
+			func(location Location) float64 {
+				if location.Country == "CA" {
+					return text.Score(location) * 3
+				}
+				return text.Score(location)
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			scorer := tt.profile.Scorer("t", ScoringContext{Lat: tt.lat, Long: tt.long})
+			bounded, ok := scorer.(BoundedScorer)
+			if !ok {
+				t.Fatalf("%T isn't a BoundedScorer", scorer)
+			}
+
+			for _, location := range []Location{toronto, tampa} {
+				if actual, expected := scorer.Score(location), tt.expected(location); math.Abs(actual-expected) > 1e-12 {
+					t.Errorf("%s: %v != %v", location.Name, actual, expected)
+				}
+				if explained := Explain(scorer, location); explained.Value != scorer.Score(location) {
+					t.Errorf("%s: explained %v != %v", location.Name, explained.Value, scorer.Score(location))
+				}
+				if max := bounded.MaxScore(len(location.Name)); scorer.Score(location) > max {
+					t.Errorf("%s: %v > max %v", location.Name, scorer.Score(location), max)
+				}
+			}
+		})
+	}
+}
+
+// The default profile has to score exactly as the scorers did before profiles,
+// down to the type, so that early stopping and explanations are unchanged.
+func TestDefaultProfile(t *testing.T) {
+	lat, long := 43.70011, -79.4163
+	profile := DefaultProfile()
+
+	if actual, expected := profile.Scorer("tor", ScoringContext{}), NewRelativeLengthScorer("tor"); !reflect.DeepEqual(actual, Scorer(expected)) {
+		t.Errorf("%#v != %#v", actual, expected)
+	}
+	if actual, expected := profile.Scorer("tor", ScoringContext{Lat: &lat, Long: &long}), NewGeoDistanceScorer(lat, long); !reflect.DeepEqual(actual, Scorer(expected)) {
+		t.Errorf("%#v != %#v", actual, expected)
+	}
+}
+
+func TestProfile_ScorerPreferCountries(t *testing.T) {
+	toronto := Location{Name: "Toronto", Country: "CA"}
+	tampa := Location{Name: "Tampa", Country: "US"}
+	text := NewRelativeLengthScorer("t")
+
+	profiles, err := ReadProfiles(strings.NewReader(`{"profiles": {"strong": {"functions": [{"type": "text"}], "other_country_factor": 0.125}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, _ := profiles.Get("default")
+	strong, _ := profiles.Get("strong")
+
+	tests := map[string]struct {
+		profile   *Profile
+		preferred []string
+		factor    float64
+	}{
+		"no preference":  {def, nil, 1},
+		"default factor": {def, []string{"CA"}, DefaultOtherCountryFactor},
+		"profile factor": {strong, []string{"CA"}, 0.125},
+		"several":        {def, []string{"MX", "CA"}, DefaultOtherCountryFactor},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			scorer := tt.profile.Scorer("t", ScoringContext{PreferCountries: tt.preferred})
+
+			if actual, expected := scorer.Score(toronto), text.Score(toronto); actual != expected {
+				t.Errorf("preferred: %v != %v", actual, expected)
+			}
+			if actual, expected := scorer.Score(tampa), text.Score(tampa)*tt.factor; actual != expected {
+				t.Errorf("not preferred: %v != %v", actual, expected)
+			}
+			if max := scorer.(BoundedScorer).MaxScore(len(toronto.Name)); scorer.Score(toronto) > max || max > 1 {
+				t.Errorf("%v > max %v", scorer.Score(toronto), max)
+			}
+		})
+	}
+
+	if len(def.Boosts) != 0 {
+		t.Errorf("the preference was added to the profile's own boosts")
+	}
+
+	if _, err := ReadProfiles(strings.NewReader(`{"profiles": {"boost": {"functions": [{"type": "text"}], "other_country_factor": 2}}}`)); err == nil {
+		t.Errorf("expected an error for a factor over 1")
+	}
+}
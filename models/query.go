@@ -0,0 +1,219 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A Query is the search typed by a user, split into the name to search for and
+// any qualifiers that follow it, like "Springfield, IL" or "London ON".
+type Query struct {
+	Text       string
+	Qualifiers []Qualifier
+	Area       Area // where the location has to be, if anywhere
+
+	// Partial is set when the qualifier is the last word, with no comma
+	// before it or space after it, so it may be the start of a word of the
+	// name that's still being typed ("New Or" for New Orleans).
+	Partial bool
+}
+
+// A Qualifier restricts matches to a set of regions or countries. A location
+// passes if it's in any of them.
+type Qualifier struct {
+	Text      string
+	Regions   map[string]bool // country code + admin1 code, e.g. "CA08"
+	Countries map[string]bool
+}
+
+// Qualifiers without a comma have to be whole region or country names, which
+// are at most this many words ("United States of America").
+const maxQualifierWords = 4
+
+// ParseQuery recognizes region and country names, codes and abbreviations at
+// the end of a query. After a comma, each part is a qualifier, and the last
+// one can be partial ("London, On" is restricted to Ontario, Oregon, etc.).
+// Without a comma, the trailing words have to be a complete name or code, and
+// unless a space follows them the query is Partial. Queries that can't be
+// parsed are returned as the text to search for.
+func ParseQuery(raw string) Query {
+	if parts := strings.Split(raw, ","); len(parts) > 1 {
+		query := Query{Text: strings.TrimSpace(parts[0])}
+
+		for i, part := range parts[1:] {
+			text := normalizeQualifier(part)
+			if text == "" {
+				continue
+			}
+
+			qualifier, found := resolveQualifier(text, i == len(parts)-2)
+			if !found {
+				return Query{Text: raw}
+			}
+			query.Qualifiers = append(query.Qualifiers, qualifier)
+		}
+
+		if query.Text == "" {
+			return Query{Text: raw}
+		}
+		return query
+	}
+
+	// try the longest trailing qualifier first, so "Springfield West Virginia"
+	// isn't a search for "Springfield West" in Virginia
+	words := strings.Fields(raw)
+	for n := maxQualifierWords; n > 0; n-- {
+		if len(words) <= n {
+			continue
+		}
+
+		text := normalizeQualifier(strings.Join(words[len(words)-n:], " "))
+		if qualifier, found := resolveQualifier(text, false); found {
+			return Query{
+				Text:       strings.Join(words[:len(words)-n], " "),
+				Qualifiers: []Qualifier{qualifier},
+				Partial:    strings.TrimRightFunc(raw, unicode.IsSpace) == raw,
+			}
+		}
+	}
+
+	return Query{Text: raw}
+}
+
+// Allow reports whether a location passes every qualifier and is in the area.
+func (query Query) Allow(location Location) bool {
+	for _, qualifier := range query.Qualifiers {
+		if !qualifier.Regions[location.Country+location.Region] && !qualifier.Countries[location.Country] {
+			return false
+		}
+	}
+	return query.Area == nil || query.Area.Contains(location.Lat, location.Long)
+}
+
+// resolveQualifier finds the regions and countries that text refers to. When
+// partial is set, text only has to be a prefix of their names.
+func resolveQualifier(text string, partial bool) (Qualifier, bool) {
+	qualifier := Qualifier{
+		Text:      text,
+		Regions:   make(map[string]bool),
+		Countries: make(map[string]bool),
+	}
+
+	matches := func(candidate string) bool {
+		candidate = strings.ToLower(candidate)
+		if partial {
+			return strings.HasPrefix(candidate, text)
+		}
+		return candidate == text
+	}
+
+	for _, region := range REGIONS {
+		if matches(region.Abbrev) || matches(region.Name) {
+			qualifier.Regions[region.Country+region.Code] = true
+		}
+	}
+
+	for country, names := range COUNTRY_NAMES {
+		if matches(country) {
+			qualifier.Countries[country] = true
+		}
+		for _, name := range names {
+			if matches(name) {
+				qualifier.Countries[country] = true
+			}
+		}
+	}
+
+	return qualifier, len(qualifier.Regions) > 0 || len(qualifier.Countries) > 0
+}
+
+// CountryQualifier restricts matches to any of the countries, given as
+// ISO-3166 codes or the names that queries can be qualified with.
+func CountryQualifier(countries []string) (Qualifier, error) {
+	qualifier := Qualifier{Text: strings.Join(countries, ","), Countries: make(map[string]bool)}
+	for _, country := range countries {
+		if IsCountryCode(country) {
+			qualifier.Countries[strings.ToUpper(country)] = true
+			continue
+		}
+
+		resolved, found := resolveQualifier(normalizeQualifier(country), false)
+		if !found || len(resolved.Countries) == 0 {
+			return Qualifier{}, fmt.Errorf("unknown country %q", country)
+		}
+		for code := range resolved.Countries {
+			qualifier.Countries[code] = true
+		}
+	}
+	return qualifier, nil
+}
+
+// RegionQualifier restricts matches to any of the regions, given as names,
+// postal abbreviations ("ON") or ISO 3166-2 style codes ("CA-ON"). After the
+// country, the admin1 code from the source data works too ("CA-08"), which is
+// the only way to name regions of countries that aren't built in.
+func RegionQualifier(regions []string) (Qualifier, error) {
+	qualifier := Qualifier{Text: strings.Join(regions, ","), Regions: make(map[string]bool)}
+	for _, region := range regions {
+		keys := resolveRegion(region)
+		if len(keys) == 0 {
+			return Qualifier{}, fmt.Errorf("unknown region %q", region)
+		}
+		for _, key := range keys {
+			qualifier.Regions[key] = true
+		}
+	}
+	return qualifier, nil
+}
+
+// resolveRegion returns the country and admin1 codes of the regions that text
+// names, e.g. "CA08" for "CA-ON".
+func resolveRegion(text string) []string {
+	keys := []string{}
+
+	country, code, found := strings.Cut(strings.ToUpper(strings.TrimSpace(text)), "-")
+	if found && IsCountryCode(country) && code != "" {
+		known := false
+		for _, region := range REGIONS {
+			if region.Country != country {
+				continue
+			}
+			known = true
+			if region.Abbrev == code || region.Code == code {
+				keys = append(keys, region.Country+region.Code)
+			}
+		}
+		if !known {
+			keys = append(keys, country+code)
+		}
+		return keys
+	}
+
+	text = normalizeQualifier(text)
+	for _, region := range REGIONS {
+		if strings.ToLower(region.Abbrev) == text || strings.ToLower(region.Name) == text {
+			keys = append(keys, region.Country+region.Code)
+		}
+	}
+	return keys
+}
+
+// IsCountryCode reports whether text looks like an ISO-3166 country code.
+func IsCountryCode(text string) bool {
+	if len(text) != 2 {
+		return false
+	}
+	for _, char := range text {
+		if (char < 'a' || char > 'z') && (char < 'A' || char > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeQualifier lower-cases a qualifier and drops periods, so "N.Y." is
+// the same as "NY".
+func normalizeQualifier(text string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Replace(text, ".", "", -1)))
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := map[string]struct {
+		raw        string
+		text       string
+		qualifiers []string // regions and countries allowed by each qualifier
+	}{
+		"no qualifier": {
+			"Springfield",
+			"Springfield",
+			nil,
+		},
+		"region code after comma": {
+			"Springfield, IL",
+			"Springfield",
+			[]string{"USIL"},
+		},
+		"region code without comma": {
+			"London ON",
+			"London",
+			[]string{"CA08"},
+		},
+		"region name": {
+			"London, Ontario",
+			"London",
+			[]string{"CA08"},
+		},
+		"multi-word region name without comma": {
+			"Charleston West Virginia",
+			"Charleston",
+			[]string{"USWV"},
+		},
+		"partial region after comma": {
+			"London, Ont",
+			"London",
+			[]string{"CA08"},
+		},
+		"partial region matching several": {
+			"London, O",
+			"London",
+			[]string{"CA08", "USOH", "USOK", "USOR"},
+		},
+		"abbreviation with periods": {
+			"Albany, N.Y.",
+			"Albany",
+			[]string{"USNY"},
+		},
+		"code that is a region and a country": {
+			"Springfield, CA",
+			"Springfield",
+			[]string{"CA", "USCA"},
+		},
+		"country name": {
+			"London, Canada",
+			"London",
+			[]string{"CA"},
+		},
+		"region and country": {
+			"London, ON, Canada",
+			"London",
+			[]string{"CA08", "CA"},
+		},
+		"trailing comma": {
+			"London,",
+			"London",
+			nil,
+		},
+		"unknown qualifier": {
+			"London, Narnia",
+			"London, Narnia",
+			nil,
+		},
+		"partial qualifier without comma is part of the name": {
+			"London Ont",
+			"London Ont",
+			nil,
+		},
+		"qualifier only": {
+			"Nevada",
+			"Nevada",
+			nil,
+		},
+		"empty name": {
+			", ON",
+			", ON",
+			nil,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			query := ParseQuery(tt.raw)
+			if query.Text != tt.text {
+				t.Errorf("%q != %q", query.Text, tt.text)
+			}
+
+			var qualifiers []string
+			for _, qualifier := range query.Qualifiers {
+				for _, key := range sortedKeys(qualifier.Countries) {
+					qualifiers = append(qualifiers, key)
+				}
+				for _, key := range sortedKeys(qualifier.Regions) {
+					qualifiers = append(qualifiers, key)
+				}
+			}
+			if !reflect.DeepEqual(qualifiers, tt.qualifiers) {
+				t.Errorf("%#v != %#v", qualifiers, tt.qualifiers)
+			}
+		})
+	}
+}
+
+func TestParseQuery_Partial(t *testing.T) {
+	tests := map[string]bool{
+		"New Or":          true,
+		"Des Mo":          true,
+		"London ON":       true,
+		"London ON ":      false,
+		"London Ontario":  true,
+		"London, ON":      false,
+		"London, Ont":     false,
+		"London":          false,
+		"London Ontario ": false,
+	}
+	for raw, expected := range tests {
+		if actual := ParseQuery(raw).Partial; actual != expected {
+			t.Errorf("%q: %#v != %#v", raw, actual, expected)
+		}
+	}
+}
+
+func TestQuery_Allow(t *testing.T) {
+	query := ParseQuery("London, ON, Canada")
+
+	if !query.Allow(Location{Name: "London", Country: "CA", Region: "08"}) {
+		t.Errorf("London, Ontario should be allowed")
+	}
+	if query.Allow(Location{Name: "London", Country: "US", Region: "OH"}) {
+		t.Errorf("London, Ohio should not be allowed")
+	}
+	if !ParseQuery("London").Allow(Location{Name: "London", Country: "US", Region: "OH"}) {
+		t.Errorf("unqualified queries should allow everything")
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCountryQualifier(t *testing.T) {
+	tests := map[string]struct {
+		countries []string
+		expected  []string
+		valid     bool
+	}{
+		"codes":             {[]string{"CA", "us"}, []string{"CA", "US"}, true},
+		"names":             {[]string{"Canada", "United States"}, []string{"CA", "US"}, true},
+		"code not built in": {[]string{"FJ"}, []string{"FJ"}, true},
+		"unknown name":      {[]string{"Narnia"}, nil, false},
+		"region name":       {[]string{"Ontario"}, nil, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			qualifier, err := CountryQualifier(tt.countries)
+			if (err == nil) != tt.valid {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if actual := sortedKeys(qualifier.Countries); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("%#v != %#v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRegionQualifier(t *testing.T) {
+	tests := map[string]struct {
+		regions  []string
+		expected []string
+		valid    bool
+	}{
+		"abbreviations": {[]string{"ON", "ny"}, []string{"CA08", "USNY"}, true},
+		"name":          {[]string{"British Columbia"}, []string{"CA02"}, true},
+		"ISO 3166-2":    {[]string{"CA-ON", "US-CA"}, []string{"CA08", "USCA"}, true},
+		"admin1 code":   {[]string{"CA-08"}, []string{"CA08"}, true},
+		"abbreviation without a country is ambiguous": {[]string{"CA"}, []string{"USCA"}, true},
+		"country not built in":                        {[]string{"FJ-01"}, []string{"FJ01"}, true},
+		"unknown region of a country":                 {[]string{"CA-XX"}, nil, false},
+		"unknown name":                                {[]string{"Atlantis"}, nil, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			qualifier, err := RegionQualifier(tt.regions)
+			if (err == nil) != tt.valid {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if actual := sortedKeys(qualifier.Regions); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("%#v != %#v", actual, tt.expected)
+			}
+		})
+	}
+}
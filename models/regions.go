@@ -0,0 +1,86 @@
+package models
+
+// A Region is a first-level administrative division (a province or state).
+type Region struct {
+	Country string // ISO-3166 country code
+	Code    string // admin1 code, as used in the source data
+	Abbrev  string // postal abbreviation, which is what people type
+	Name    string
+}
+
+// Provinces and states, used to recognize qualified queries like
+// "Springfield, IL" or "London, Ontario". Canadian admin1 codes are FIPS
+// codes, so they need their postal abbreviations listed separately, and
+// their names are shown in place of them (see REGION_CODES).
+var REGIONS = []Region{
+	{"CA", "01", "AB", "Alberta"},
+	{"CA", "02", "BC", "British Columbia"},
+	{"CA", "03", "MB", "Manitoba"},
+	{"CA", "04", "NB", "New Brunswick"},
+	{"CA", "05", "NL", "Newfoundland and Labrador"},
+	{"CA", "07", "NS", "Nova Scotia"},
+	{"CA", "08", "ON", "Ontario"},
+	{"CA", "09", "PE", "Prince Edward Island"},
+	{"CA", "10", "QC", "Quebec"},
+	{"CA", "11", "SK", "Saskatchewan"},
+	{"CA", "12", "YT", "Yukon"},
+	{"CA", "13", "NT", "Northwest Territories"},
+	{"CA", "14", "NU", "Nunavut"},
+	{"US", "AK", "AK", "Alaska"},
+	{"US", "AL", "AL", "Alabama"},
+	{"US", "AR", "AR", "Arkansas"},
+	{"US", "AZ", "AZ", "Arizona"},
+	{"US", "CA", "CA", "California"},
+	{"US", "CO", "CO", "Colorado"},
+	{"US", "CT", "CT", "Connecticut"},
+	{"US", "DC", "DC", "District of Columbia"},
+	{"US", "DE", "DE", "Delaware"},
+	{"US", "FL", "FL", "Florida"},
+	{"US", "GA", "GA", "Georgia"},
+	{"US", "HI", "HI", "Hawaii"},
+	{"US", "IA", "IA", "Iowa"},
+	{"US", "ID", "ID", "Idaho"},
+	{"US", "IL", "IL", "Illinois"},
+	{"US", "IN", "IN", "Indiana"},
+	{"US", "KS", "KS", "Kansas"},
+	{"US", "KY", "KY", "Kentucky"},
+	{"US", "LA", "LA", "Louisiana"},
+	{"US", "MA", "MA", "Massachusetts"},
+	{"US", "MD", "MD", "Maryland"},
+	{"US", "ME", "ME", "Maine"},
+	{"US", "MI", "MI", "Michigan"},
+	{"US", "MN", "MN", "Minnesota"},
+	{"US", "MO", "MO", "Missouri"},
+	{"US", "MS", "MS", "Mississippi"},
+	{"US", "MT", "MT", "Montana"},
+	{"US", "NC", "NC", "North Carolina"},
+	{"US", "ND", "ND", "North Dakota"},
+	{"US", "NE", "NE", "Nebraska"},
+	{"US", "NH", "NH", "New Hampshire"},
+	{"US", "NJ", "NJ", "New Jersey"},
+	{"US", "NM", "NM", "New Mexico"},
+	{"US", "NV", "NV", "Nevada"},
+	{"US", "NY", "NY", "New York"},
+	{"US", "OH", "OH", "Ohio"},
+	{"US", "OK", "OK", "Oklahoma"},
+	{"US", "OR", "OR", "Oregon"},
+	{"US", "PA", "PA", "Pennsylvania"},
+	{"US", "RI", "RI", "Rhode Island"},
+	{"US", "SC", "SC", "South Carolina"},
+	{"US", "SD", "SD", "South Dakota"},
+	{"US", "TN", "TN", "Tennessee"},
+	{"US", "TX", "TX", "Texas"},
+	{"US", "UT", "UT", "Utah"},
+	{"US", "VA", "VA", "Virginia"},
+	{"US", "VT", "VT", "Vermont"},
+	{"US", "WA", "WA", "Washington"},
+	{"US", "WI", "WI", "Wisconsin"},
+	{"US", "WV", "WV", "West Virginia"},
+	{"US", "WY", "WY", "Wyoming"},
+}
+
+// Names people use for each country, besides its ISO-3166 code.
+var COUNTRY_NAMES = map[string][]string{
+	"CA": {"Canada"},
+	"US": {"United States", "United States of America", "USA", "America"},
+}
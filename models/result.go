@@ -1,10 +1,22 @@
 package models
 
+import (
+	"container/heap"
+	"sort"
+)
+
 type Result struct {
-	Name  string  `json:"name"`
-	Lat   float64 `json:"latitude"`
-	Long  float64 `json:"longitude"`
-	Score float64 `json:"score"`
+	Name        string       `json:"name"`
+	Lat         float64      `json:"latitude"`
+	Long        float64      `json:"longitude"`
+	Score       float64      `json:"score"`
+	Explanation *Explanation `json:"explanation,omitempty"` // only if asked for
+
+	// From the caller, when their coordinates are known
+	Distance  *float64 `json:"distance,omitempty"`
+	Units     string   `json:"units,omitempty"`     // of distance, "km" or "mi"
+	Bearing   *float64 `json:"bearing,omitempty"`   // degrees clockwise from north
+	Direction string   `json:"direction,omitempty"` // the nearest compass point to bearing, e.g. "NW"
 }
 
 type ResultsByScore []Result
@@ -13,6 +25,105 @@ func (a ResultsByScore) Len() int           { return len(a) }
 func (a ResultsByScore) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ResultsByScore) Less(i, j int) bool { return a[i].Score < a[j].Score }
 
+// A ScoredLocation is a location and its score, before it's turned into a
+// Result. Breaking ties needs more of the location than a Result keeps.
+type ScoredLocation struct {
+	Location
+	Score       float64
+	Explanation *Explanation // of the score, if it was asked for
+}
+
+// ByRank sorts scored locations best first. Ties are broken by population
+// (largest first), then name as displayed, then ID, so that every location has
+// its own place and the order is the same on every run, whatever order the
+// index found them in.
+type ByRank []ScoredLocation
+
+func (a ByRank) Len() int           { return len(a) }
+func (a ByRank) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByRank) Less(i, j int) bool { return ranksBefore(a[i], a[j]) }
+
+// ranksBefore reports whether a ranks better than b.
+func ranksBefore(a, b ScoredLocation) bool {
+	switch {
+	case a.Score != b.Score:
+		return a.Score > b.Score
+	case a.Population != b.Population:
+		return a.Population > b.Population
+	case a.DisplayName != b.DisplayName:
+		return a.DisplayName < b.DisplayName
+	}
+	return a.ID < b.ID
+}
+
+// A TopK keeps the k best scored locations pushed into it, in the same order
+// as ByRank, without keeping or sorting the rest. It's a heap with the worst of
+// the k at the top, so each location that doesn't make it costs one
+// comparison, and each one that does costs log k.
+type TopK struct {
+	k    int
+	heap worstFirst
+}
+
+// NewTopK keeps the best k locations, or all of them if k is 0.
+func NewTopK(k int) *TopK {
+	return &TopK{k: k}
+}
+
+// Push adds a location if it's one of the best k so far, dropping the worst
+// one if that makes more than k.
+func (top *TopK) Push(scored ScoredLocation) {
+	if top.k <= 0 || len(top.heap) < top.k {
+		// like heap.Push, without boxing the location in an interface
+		top.heap = append(top.heap, scored)
+		heap.Fix(&top.heap, len(top.heap)-1)
+	} else if ranksBefore(scored, top.heap[0]) {
+		top.heap[0] = scored
+		heap.Fix(&top.heap, 0)
+	}
+}
+
+// Len returns the number of locations kept.
+func (top *TopK) Len() int {
+	return len(top.heap)
+}
+
+// Full reports whether there are k locations, so a location has to rank
+// better than Min to be kept.
+func (top *TopK) Full() bool {
+	return top.k > 0 && len(top.heap) == top.k
+}
+
+// Min returns the worst location kept. There has to be at least one.
+func (top *TopK) Min() ScoredLocation {
+	return top.heap[0]
+}
+
+// Sorted returns the locations kept, best first.
+func (top *TopK) Sorted() []ScoredLocation {
+	sorted := append([]ScoredLocation{}, top.heap...)
+	sort.Sort(ByRank(sorted))
+	return sorted
+}
+
+// worstFirst is a heap.Interface that keeps the worst ranked location on top.
+type worstFirst []ScoredLocation
+
+func (h worstFirst) Len() int           { return len(h) }
+func (h worstFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h worstFirst) Less(i, j int) bool { return ranksBefore(h[j], h[i]) }
+
+func (h *worstFirst) Push(x interface{}) {
+	*h = append(*h, x.(ScoredLocation))
+}
+
+func (h *worstFirst) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
 func NewResult(location Location, score float64) Result {
 	return Result{
 		Name:  location.DisplayName,
@@ -21,3 +132,29 @@ func NewResult(location Location, score float64) Result {
 		Score: score,
 	}
 }
+
+// AddDistance adds the distance and direction from a caller at lat, long to
+// the result, in units. A caller right at the location has no direction.
+func (result *Result) AddDistance(lat, long float64, units string) {
+	distance := HaversineDistance(lat, long, result.Lat, result.Long)
+	if units == Miles {
+		distance /= kmPerMile
+	}
+	result.Distance, result.Units = &distance, units
+
+	if distance > 0 {
+		bearing := Bearing(lat, long, result.Lat, result.Long)
+		result.Bearing, result.Direction = &bearing, CompassDirection(bearing)
+	}
+}
+
+// NewResults constructs results from scored locations, in the same order.
+func NewResults(scored []ScoredLocation) []Result {
+	results := []Result{}
+	for _, location := range scored {
+		result := NewResult(location.Location, location.Score)
+		result.Explanation = location.Explanation
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestByRank(t *testing.T) {
+	scored := []ScoredLocation{
+		{Location: Location{ID: "5", DisplayName: "B", Population: 10}, Score: 0.5},
+		{Location: Location{ID: "4", DisplayName: "B", Population: 10}, Score: 0.5},
+		{Location: Location{ID: "3", DisplayName: "A", Population: 10}, Score: 0.5},
+		{Location: Location{ID: "2", DisplayName: "C", Population: 20}, Score: 0.5},
+		{Location: Location{ID: "1", DisplayName: "D", Population: 0}, Score: 1.0},
+	}
+
+	sort.Sort(ByRank(scored))
+
+	ids := []string{}
+	for _, location := range scored {
+		ids = append(ids, location.ID)
+	}
+	expected := []string{"1", "2", "3", "4", "5"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("%#v != %#v", ids, expected)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	scored := []ScoredLocation{}
+	for i := 0; i < 200; i++ {
+		// few distinct scores and populations, so there are plenty of ties
+		location := Location{ID: fmt.Sprint(i), DisplayName: fmt.Sprint(i % 7), Population: int64(random.Intn(3))}
+		scored = append(scored, ScoredLocation{Location: location, Score: float64(random.Intn(5))})
+	}
+
+	expected := append([]ScoredLocation{}, scored...)
+	sort.Sort(ByRank(expected))
+
+	for _, k := range []int{0, 1, 10, 199, 200, 500} {
+		top := NewTopK(k)
+		for _, location := range scored {
+			top.Push(location)
+		}
+
+		want := expected
+		if k > 0 && k < len(want) {
+			want = want[:k]
+		}
+		if actual := top.Sorted(); !reflect.DeepEqual(actual, want) {
+			t.Errorf("k = %d: %#v != %#v", k, actual, want)
+		}
+		if top.Len() != len(want) || top.Min() != want[len(want)-1] {
+			t.Errorf("k = %d: %#v != %#v", k, top.Min(), want[len(want)-1])
+		}
+		if full := k > 0 && k <= len(scored); top.Full() != full {
+			t.Errorf("k = %d: %#v != %#v", k, top.Full(), full)
+		}
+	}
+}
+
+// Compares keeping the top 10 of every match for a short prefix with sorting
+// them all, with scores by distance from a location.
+func BenchmarkRank(b *testing.B) {
+	f, err := os.Open(testDataPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	tree, err := LoadTrie(f, nil)
+	f.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	scorer := NewGeoDistanceScorer(43.70011, -79.4163)
+	scored := []ScoredLocation{}
+	for _, match := range tree.FindTokenMatches("s", 0) {
+		scored = append(scored, ScoredLocation{Location: match.Location, Score: scorer.Score(match.Location) * match.Weight()})
+	}
+	b.Logf("%d matches", len(scored))
+
+	b.Run("sort", func(b *testing.B) {
+		all := make([]ScoredLocation, len(scored))
+		for i := 0; i < b.N; i++ {
+			copy(all, scored)
+			sort.Sort(ByRank(all))
+			_ = all[:10]
+		}
+	})
+
+	b.Run("topk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			top := NewTopK(10)
+			for _, location := range scored {
+				top.Push(location)
+			}
+			_ = top.Sorted()
+		}
+	})
+}
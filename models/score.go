@@ -1,14 +1,28 @@
 package models
 
-import "math"
+import (
+	"fmt"
+	"math"
+	"strings"
+)
 
 // A Scorer is used to calculate a score for each result returned by the server.
 type Scorer interface {
 	Score(Location) float64
 }
 
+// A BoundedScorer is a Scorer that knows the highest score a location with a
+// name at least minLength bytes long can get. An index walked in order of name
+// length can stop once no later location could make the results.
+type BoundedScorer interface {
+	Scorer
+	MaxScore(minLength int) float64
+}
+
 // A RelativeLengthScorer scores results based on the length of their names
-// relative to the length of the query. Longer names are given lower scores.
+// relative to the length of the query. Longer names are given lower scores,
+// and names no longer than the query (which synonyms can make of a longer
+// query, like "St. Catharines" of "saint catharines") score 1.
 type RelativeLengthScorer struct {
 	queryLength int
 }
@@ -23,10 +37,117 @@ func (scorer *RelativeLengthScorer) Score(location Location) float64 {
 	return InverseLengthScore(len(location.Name) - scorer.queryLength)
 }
 
+func (scorer *RelativeLengthScorer) Explain(location Location) Explanation {
+	n := len(location.Name) - scorer.queryLength
+	if n <= 0 {
+		return Explanation{Value: 1, Description: "1, for a name no longer than the query"}
+	}
+	return Explanation{
+		Value:       scorer.Score(location),
+		Description: fmt.Sprintf("2^%d, for a name %d characters longer than the query", -n, n),
+	}
+}
+
+// MaxScore is the score of a name minLength bytes long, since longer names
+// score lower.
+func (scorer *RelativeLengthScorer) MaxScore(minLength int) float64 {
+	return InverseLengthScore(minLength - scorer.queryLength)
+}
+
+// InverseLengthScore is 2^-n for n extra characters, and 1 for none or fewer.
 func InverseLengthScore(n int) float64 {
+	if n < 0 {
+		n = 0
+	}
 	return math.Exp2(-float64(n))
 }
 
+// A PhoneticScorer scores names that sound like the query by how close their
+// phonetic keys are: a name whose key has more sounds than the query's scores
+// lower, like a longer name does with a RelativeLengthScorer. Different names
+// often have the same key ("Chino" and "Cheyenne" are both "xn"), so the score
+// is also halved for each edit between the query and the closest start of the
+// name.
+type PhoneticScorer struct {
+	query []rune
+	keys  []string
+}
+
+func NewPhoneticScorer(query string) *PhoneticScorer {
+	query = strings.ToLower(query)
+	return &PhoneticScorer{query: []rune(query), keys: PhoneticKeys(query)}
+}
+
+func (scorer *PhoneticScorer) Score(location Location) float64 {
+	sounds, edits := scorer.distances(location)
+	return InverseLengthScore(sounds) * math.Exp2(-edits)
+}
+
+// distances returns how many more sounds the name's closest key has than the
+// query's, and how many edits the query is from the start of the name.
+func (scorer *PhoneticScorer) distances(location Location) (int, float64) {
+	name := strings.ToLower(location.Name)
+
+	sounds := -1
+	for _, key := range PhoneticKeys(name) {
+		for _, queryKey := range scorer.keys {
+			if n := len(key) - len(queryKey); sounds < 0 || n < sounds {
+				sounds = n
+			}
+		}
+	}
+	if sounds < 0 {
+		sounds = 0
+	}
+	return sounds, prefixEditDistance(scorer.query, name, math.Inf(1))
+}
+
+func (scorer *PhoneticScorer) Explain(location Location) Explanation {
+	sounds, edits := scorer.distances(location)
+	return Explanation{
+		Value:       scorer.Score(location),
+		Description: fmt.Sprintf("2^-%d * 2^-%g, for a name with %d more sounds than the query, %g edits from it", sounds, edits, sounds, edits),
+	}
+}
+
+// MaxScore is 1, since how a name sounds isn't bounded by its length.
+func (scorer *PhoneticScorer) MaxScore(minLength int) float64 {
+	return 1.0
+}
+
+// An AliasScorer scores the locations an alias like "NYC" stands for as exact
+// matches, since they're what the user meant however long their names are.
+type AliasScorer struct{}
+
+func (scorer *AliasScorer) Score(location Location) float64 {
+	return 1.0
+}
+
+func (scorer *AliasScorer) Explain(location Location) Explanation {
+	return Explanation{Value: 1, Description: "1, for what an alias stands for"}
+}
+
+func (scorer *AliasScorer) MaxScore(minLength int) float64 {
+	return 1.0
+}
+
+// Matches on a later word of a name ("york" for "New York") are less likely to
+// be what the user meant than matches on the start of a name, so their scores
+// are scaled by this much.
+const LaterTokenWeight = 0.5
+
+// TokenWeight scales the score of a match by which word of the name matched.
+func TokenWeight(token int) float64 {
+	if token > 0 {
+		return LaterTokenWeight
+	}
+	return 1.0
+}
+
+// Matches that only sound like the query ("shyenne" for "Cheyenne") are a
+// fallback for when the spelling is unknown, so they're scaled down further.
+const PhoneticWeight = 0.25
+
 // A GeoDistanceScorer scores results based on their distance from the latitude
 // and longitude provided in the query.
 type GeoDistanceScorer struct {
@@ -44,6 +165,24 @@ func (scorer *GeoDistanceScorer) Score(location Location) float64 {
 	return DistanceScore(scorer.lat, scorer.long, location.Lat, location.Long)
 }
 
+func (scorer *GeoDistanceScorer) Explain(location Location) Explanation {
+	distance := CosineDistance(scorer.lat, scorer.long, location.Lat, location.Long)
+	return Explanation{
+		Value: scorer.Score(location),
+		Description: fmt.Sprintf("1 - %g, for %.0f km from (%g, %g) as a fraction of half the Earth's circumference",
+			distance, distance*math.Pi*earthRadiusKm, scorer.lat, scorer.long),
+	}
+}
+
+// MaxScore is 1, for a location right at the coordinates. Names don't bound
+// distance.
+func (scorer *GeoDistanceScorer) MaxScore(minLength int) float64 {
+	return 1.0
+}
+
+// Mean radius of the Earth
+const earthRadiusKm = 6371.0
+
 // Calculate the distance between two points as a fraction of half the Earth's
 // circumference (the maximum distance).
 func CosineDistance(lat1, long1, lat2, long2 float64) float64 {
@@ -66,3 +205,26 @@ func DistanceScore(lat1, long1, lat2, long2 float64) float64 {
 func radians(degrees float64) float64 {
 	return degrees * math.Pi / 180.0
 }
+
+// A PopulationScorer scores results by the order of magnitude of their
+// population, so prominent places rank first.
+type PopulationScorer struct{}
+
+// Places of at least this many people get the full population score
+const maxPopulationLog = 8
+
+func (scorer *PopulationScorer) Score(location Location) float64 {
+	return math.Min(math.Log10(float64(location.Population)+1)/maxPopulationLog, 1.0)
+}
+
+func (scorer *PopulationScorer) Explain(location Location) Explanation {
+	return Explanation{
+		Value:       scorer.Score(location),
+		Description: fmt.Sprintf("log10(%d + 1) / %d, at most 1, for the population", location.Population, maxPopulationLog),
+	}
+}
+
+// MaxScore is 1, for a place of at least 10^8 people.
+func (scorer *PopulationScorer) MaxScore(minLength int) float64 {
+	return 1.0
+}
@@ -23,6 +23,12 @@ func TestRelativeLengthScorer_Score(t *testing.T) {
 			Location{Name: "ABCD"},
 			InverseLengthScore(1),
 		},
+		"shorter result": {
+			"ABCD",
+			[]Location{{Name: "ABC"}},
+			Location{Name: "ABC"},
+			1.0,
+		},
 		"empty string": {
 			"",
 			[]Location{{Name: ""}},
@@ -47,3 +53,41 @@ func TestRelativeLengthScorer_Score(t *testing.T) {
 		})
 	}
 }
+
+func TestPhoneticScorer_Score(t *testing.T) {
+	tests := map[string]struct {
+		query    string
+		location Location
+		expected float64
+	}{
+		"same sounds and spelling": {
+			"cheyenne",
+			Location{Name: "Cheyenne"},
+			1.0,
+		},
+		"same sounds, spelled differently": {
+			"shyenne",
+			Location{Name: "Cheyenne"},
+			InverseLengthScore(2),
+		},
+		"same sounds, spelled more differently": {
+			"shyenne",
+			Location{Name: "Chino"},
+			InverseLengthScore(5),
+		},
+		"more sounds": {
+			"she",
+			Location{Name: "Cheyenne"},
+			InverseLengthScore(1) * InverseLengthScore(1),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			scorer := NewPhoneticScorer(tt.query)
+
+			if actual := scorer.Score(tt.location); actual != tt.expected {
+				t.Errorf("%v != %v", actual, tt.expected)
+			}
+		})
+	}
+}
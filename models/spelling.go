@@ -0,0 +1,234 @@
+package models
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Corrections are found by walking the tree with a row of the edit distance
+// table for each node, so only the parts of the name vocabulary that are
+// within reach of the query are ever visited.
+
+// Edit costs. A substitution of a neighbouring key on the keyboard or of an
+// accented letter is a likely typo, so it's cheaper than other substitutions.
+const (
+	editCost      = 1.0
+	adjacentCost  = 0.5
+	accentCost    = 0.1
+	transposeCost = 0.5
+)
+
+// Each edit costs as much as this many orders of magnitude of population when
+// ranking corrections, so "Toronto" beats a tiny town one typo closer.
+const correctionEditWeight = 2.0
+
+// At most this many names below each node that matches the query are
+// considered, shortest first.
+const maxCorrectionCandidates = 100
+
+// QWERTY neighbours of each key.
+var keyboardAdjacent = map[rune]string{
+	'q': "wa", 'w': "qeas", 'e': "wrsd", 'r': "etdf", 't': "ryfg", 'y': "tugh", 'u': "yihj", 'i': "uojk", 'o': "ipkl", 'p': "ol",
+	'a': "qwsz", 's': "weadzx", 'd': "ersfxc", 'f': "rtdgcv", 'g': "tyfhvb", 'h': "yugjbn", 'j': "uihknm", 'k': "iojlm", 'l': "opk",
+	'z': "asx", 'x': "zsdc", 'c': "xdfv", 'v': "cfgb", 'b': "vghn", 'n': "bhjm", 'm': "njk",
+}
+
+// Corrections returns up to limit names that the query text was probably a
+// misspelling of, most likely first. Candidates are names in the tree that
+// start with something within a small edit distance of the text, ranked by
+// that distance and their population. Only locations that allow accepts (which
+// may be nil) are considered.
+func (tree *Trie) Corrections(text string, limit int, allow func(Location) bool) []string {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	query := []rune(tree.synonyms.Normalize(strings.ToLower(strings.TrimSpace(text))))
+	maxDistance := maxEditDistance(len(query))
+	if maxDistance == 0 {
+		return []string{}
+	}
+
+	walk := &correctionWalk{
+		query:       query,
+		maxDistance: maxDistance,
+		children: func(node keyNode, visit func(char rune, child keyNode)) {
+			for child := tree.nodes[node.node].child; child != 0; child = tree.nodes[child].sibling {
+				visit(tree.nodes[child].char, keyNode{node: child})
+			}
+		},
+		below: func(node keyNode, visit func(result posting, depth int) bool) {
+			tree.traverse(context.Background(), []walkRoot{{node: node.node}}, visit)
+		},
+	}
+	distances := walk.distances(keyNode{node: rootNode})
+
+	scores := make(map[string]float64)
+	for ref, distance := range distances {
+		if location := tree.locations.Location(ref); allow == nil || allow(location) {
+			addCorrection(scores, location, distance)
+		}
+	}
+	return rankCorrections(scores, limit)
+}
+
+// addCorrection records the score of a candidate name, keeping the best score
+// of each name. Candidates are scored by distance and prominence.
+func addCorrection(scores map[string]float64, location Location, distance float64) {
+	score := math.Log10(float64(location.Population)+10) - distance*correctionEditWeight
+	if best, found := scores[location.Name]; !found || score > best {
+		scores[location.Name] = score
+	}
+}
+
+// rankCorrections returns the limit best scoring names.
+func rankCorrections(scores map[string]float64, limit int) []string {
+	names := make([]string, 0, len(scores))
+	for name := range scores {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if scores[names[i]] != scores[names[j]] {
+			return scores[names[i]] > scores[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+	return names
+}
+
+// A keyNode is a node of an index's keys: a node of a Trie, or a node of a
+// transducer and the ordinal of the path to it.
+type keyNode struct {
+	node, ordinal uint32
+}
+
+// A correctionWalk walks the keys of an index with a row of the edit distance
+// table for each node (a restricted Damerau-Levenshtein distance, so a swap of
+// two letters is one edit). Wherever the whole query is within maxDistance of
+// the path to a node, the names below it are candidates.
+type correctionWalk struct {
+	query       []rune
+	maxDistance float64
+
+	// children calls visit with each child of a node and the character that
+	// leads to it, and below visits the postings below a node
+	children func(node keyNode, visit func(char rune, child keyNode))
+	below    func(node keyNode, visit func(result posting, depth int) bool)
+
+	// the best edit distance found for each location
+	found map[LocationRef]float64
+}
+
+// distances walks the keys below root, and returns the best edit distance
+// found for each location.
+func (walk *correctionWalk) distances(root keyNode) map[LocationRef]float64 {
+	walk.found = make(map[LocationRef]float64)
+	walk.correct(root, nil, firstEditRow(walk.query), 0, math.Inf(1))
+	return walk.found
+}
+
+// correct visits the children of node, computing the next row of the edit
+// distance table for each of them. matched is the distance at the nearest
+// ancestor that was a match, so its names are only collected again when
+// they're closer.
+func (walk *correctionWalk) correct(node keyNode, prevRow, row []float64, char rune, matched float64) {
+	walk.children(node, func(next rune, child keyNode) {
+		nextRow, best := nextEditRow(walk.query, prevRow, row, char, next)
+
+		childMatched := matched
+		if distance := nextRow[len(walk.query)]; distance <= walk.maxDistance && distance < matched {
+			walk.collect(child, distance)
+			childMatched = distance
+		}
+
+		// no path below can get closer than the best cell in the row
+		if best <= walk.maxDistance {
+			walk.correct(child, row, nextRow, next, childMatched)
+		}
+	})
+}
+
+// collect records the distance of the names below node.
+func (walk *correctionWalk) collect(node keyNode, distance float64) {
+	seen := make(map[LocationRef]bool)
+	walk.below(node, func(result posting, depth int) bool {
+		if result.token > 0 || seen[result.ref] {
+			return true
+		}
+		seen[result.ref] = true
+
+		if current, found := walk.found[result.ref]; !found || distance < current {
+			walk.found[result.ref] = distance
+		}
+		return len(seen) < maxCorrectionCandidates
+	})
+}
+
+// firstEditRow is the row of the edit distance table for an empty name.
+func firstEditRow(query []rune) []float64 {
+	row := make([]float64, len(query)+1)
+	for i := range row {
+		row[i] = float64(i) * editCost
+	}
+	return row
+}
+
+// nextEditRow computes the row of the edit distance table for a name with next
+// added to it, given the rows for the name (row, ending with char) and the name
+// without char (prevRow, or nil if it was empty). It also returns the smallest
+// distance in the new row.
+func nextEditRow(query []rune, prevRow, row []float64, char, next rune) ([]float64, float64) {
+	nextRow := make([]float64, len(query)+1)
+	nextRow[0] = row[0] + editCost
+	best := nextRow[0]
+	for i := 1; i <= len(query); i++ {
+		nextRow[i] = math.Min(
+			math.Min(row[i]+editCost, nextRow[i-1]+editCost),
+			row[i-1]+substitutionCost(query[i-1], next),
+		)
+		if prevRow != nil && i > 1 && query[i-1] == char && query[i-2] == next {
+			nextRow[i] = math.Min(nextRow[i], prevRow[i-2]+transposeCost)
+		}
+		best = math.Min(best, nextRow[i])
+	}
+	return nextRow, best
+}
+
+// maxEditDistance is how far a query of n characters can be from a name.
+// Short queries are too ambiguous to correct at all.
+func maxEditDistance(n int) float64 {
+	switch {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	}
+	return 2
+}
+
+// substitutionCost is the cost of typing a instead of b.
+func substitutionCost(a, b rune) float64 {
+	switch {
+	case a == b:
+		return 0
+	case foldLetter(a) == foldLetter(b):
+		return accentCost
+	case strings.ContainsRune(keyboardAdjacent[a], b):
+		return adjacentCost
+	}
+	return editCost
+}
+
+// foldLetter strips the accent from a lower-case Latin letter.
+func foldLetter(r rune) rune {
+	if base, found := accents[unicode.ToUpper(r)]; found {
+		return unicode.ToLower(rune(base))
+	}
+	return r
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrie_Corrections(t *testing.T) {
+	toronto := Location{Name: "Toronto", ID: "1", Country: "CA", Population: 2600000}
+	torrington := Location{Name: "Torrington", ID: "2", Country: "US", Population: 36000}
+	montreal := Location{Name: "Montréal", ID: "3", Country: "CA", Population: 1600000}
+	boston := Location{Name: "Boston", ID: "4", Country: "US", Population: 617000}
+	bolton := Location{Name: "Bolton", ID: "5", Country: "CA", Population: 26000}
+
+	tree := makeTrie(toronto, torrington, montreal, boston, bolton)
+
+	tests := map[string]struct {
+		text     string
+		allow    func(Location) bool
+		expected []string
+	}{
+		"transposed letters":   {"torotno", nil, []string{"Toronto"}},
+		"partial":              {"torint", nil, []string{"Toronto", "Torrington"}},
+		"missing accent":       {"montreall", nil, []string{"Montréal"}},
+		"adjacent key":         {"bodton", nil, []string{"Boston", "Bolton"}},
+		"ranked by prominence": {"boqton", nil, []string{"Boston", "Bolton"}},
+		"filtered":             {"bodton", func(location Location) bool { return location.Country == "CA" }, []string{"Bolton"}},
+		"too far":              {"xyzzy", nil, []string{}},
+		"too short":            {"tp", nil, []string{}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := tree.Corrections(tt.text, 3, tt.allow); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("%#v != %#v", actual, tt.expected)
+			}
+		})
+	}
+
+	tree.Remove(toronto.ID)
+	if actual := tree.Corrections("torotno", 3, nil); len(actual) != 0 {
+		t.Errorf("removed locations should not be suggested: %#v", actual)
+	}
+}
@@ -0,0 +1,43 @@
+package models
+
+// A StringPool interns repeated strings (country codes, region codes, display
+// name suffixes) so that each distinct value is only stored once, and callers
+// can keep a small integer symbol instead of a string header.
+type StringPool struct {
+	index  map[string]uint32
+	values []string
+}
+
+func NewStringPool() *StringPool {
+	return &StringPool{
+		index: make(map[string]uint32),
+	}
+}
+
+// Intern returns the symbol for s, adding it to the pool if necessary.
+func (pool *StringPool) Intern(s string) uint32 {
+	if symbol, found := pool.index[s]; found {
+		return symbol
+	}
+
+	symbol := uint32(len(pool.values))
+	pool.index[s] = symbol
+	pool.values = append(pool.values, s)
+	return symbol
+}
+
+// Lookup returns the symbol for s without adding it to the pool.
+func (pool *StringPool) Lookup(s string) (uint32, bool) {
+	symbol, found := pool.index[s]
+	return symbol, found
+}
+
+// String returns the value interned as symbol.
+func (pool *StringPool) String(symbol uint32) string {
+	return pool.values[symbol]
+}
+
+// Len returns the number of distinct strings in the pool.
+func (pool *StringPool) Len() int {
+	return len(pool.values)
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Synonyms makes different spellings of the same name find each other. There
+// are two kinds:
+//
+// Word groups like "saint, st" are interchangeable anywhere in a name. Every
+// word in a group is replaced by the first one when a key is indexed and when
+// a query is searched, so "St. Catharines", "Saint Catharines" and
+// "St Catharines" are all the same key.
+//
+// Aliases like "nyc = New York, NY" stand for a whole query. Their matches are
+// added to the matches for the query itself.
+type Synonyms struct {
+	words   map[string]string // word -> first word in its group
+	aliases map[string]string // query -> what it stands for
+}
+
+// The built-in dictionary, which a synonyms file adds to.
+const defaultSynonyms = `
+# Abbreviations, using the full word in the index
+saint, st
+sainte, ste
+fort, ft
+mount, mt
+mountain, mtn
+
+# Nicknames
+nyc = New York, NY
+t.o. = Toronto, ON
+philly = Philadelphia, PA
+vegas = Las Vegas, NV
+`
+
+func NewSynonyms() *Synonyms {
+	return &Synonyms{
+		words:   make(map[string]string),
+		aliases: make(map[string]string),
+	}
+}
+
+// DefaultSynonyms returns the built-in dictionary.
+func DefaultSynonyms() *Synonyms {
+	synonyms := NewSynonyms()
+	if err := synonyms.Read(strings.NewReader(defaultSynonyms)); err != nil {
+		panic(err)
+	}
+	return synonyms
+}
+
+// Read adds the entries in a synonyms file to the dictionary. Each line is
+// either a comma separated group of words, or an alias and the query it stands
+// for separated by "=". Blank lines and lines starting with "#" are ignored.
+func (synonyms *Synonyms) Read(file io.Reader) error {
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if parts := strings.SplitN(text, "=", 2); len(parts) == 2 {
+			alias := strings.ToLower(strings.TrimSpace(parts[0]))
+			query := strings.TrimSpace(parts[1])
+			if alias == "" || query == "" {
+				return fmt.Errorf("line %d: expected <alias> = <query>", line)
+			}
+			synonyms.aliases[alias] = query
+			continue
+		}
+
+		var group []string
+		for _, word := range strings.Split(text, ",") {
+			word = strings.ToLower(strings.TrimSpace(word))
+			if word == "" || strings.IndexFunc(word, isSeparator) >= 0 {
+				return fmt.Errorf("line %d: %q is not a single word", line, word)
+			}
+			group = append(group, word)
+		}
+		if len(group) < 2 {
+			return fmt.Errorf("line %d: expected at least two words", line)
+		}
+		for _, word := range group {
+			synonyms.words[word] = group[0]
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Write writes the dictionary in the format that Read reads, so that it can be
+// stored with an index.
+func (synonyms *Synonyms) Write(file io.Writer) error {
+	groups := make(map[string][]string)
+	for word, first := range synonyms.words {
+		if word != first {
+			groups[first] = append(groups[first], word)
+		}
+	}
+
+	var lines []string
+	for first, words := range groups {
+		sort.Strings(words)
+		lines = append(lines, strings.Join(append([]string{first}, words...), ", "))
+	}
+	for alias, query := range synonyms.aliases {
+		lines = append(lines, alias+" = "+query)
+	}
+	sort.Strings(lines)
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(file, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Normalize replaces every word in a lower-cased key with the first word in its
+// group. A period after an abbreviation is dropped along with it, so "st." and
+// "st" are the same.
+func (synonyms *Synonyms) Normalize(key string) string {
+	if synonyms == nil || len(synonyms.words) == 0 {
+		return key
+	}
+
+	var normalized strings.Builder
+	for len(key) > 0 {
+		end := strings.IndexFunc(key, isSeparator)
+		if end < 0 {
+			end = len(key)
+		}
+
+		if word, found := synonyms.words[key[:end]]; found && end > 0 {
+			normalized.WriteString(word)
+			if strings.HasPrefix(key[end:], ".") {
+				end++
+			}
+		} else {
+			normalized.WriteString(key[:end])
+		}
+
+		// copy separators up to the next word
+		next := strings.IndexFunc(key[end:], func(r rune) bool { return !isSeparator(r) })
+		if next < 0 {
+			next = len(key) - end
+		}
+		normalized.WriteString(key[end : end+next])
+		key = key[end+next:]
+	}
+
+	return normalized.String()
+}
+
+// Variants returns the keys to search for a lower-cased query. The last word
+// may still be being typed, so it's searched for as typed as well as
+// normalized: "st" is the start of "Stamford" as well as an abbreviation.
+func (synonyms *Synonyms) Variants(query string) []string {
+	normalized := synonyms.Normalize(query)
+
+	last := strings.LastIndexFunc(query, isSeparator) + 1
+	typed := synonyms.Normalize(query[:last]) + query[last:]
+
+	if typed == normalized {
+		return []string{normalized}
+	}
+	return []string{normalized, typed}
+}
+
+// Alias returns the query that a whole query stands for, if it's an alias.
+func (synonyms *Synonyms) Alias(query string) (string, bool) {
+	if synonyms == nil {
+		return "", false
+	}
+	alias, found := synonyms.aliases[strings.ToLower(strings.TrimSpace(query))]
+	return alias, found
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
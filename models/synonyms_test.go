@@ -0,0 +1,131 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSynonyms_Normalize(t *testing.T) {
+	synonyms := DefaultSynonyms()
+
+	tests := map[string]string{
+		"":                "",
+		"saint john":      "saint john",
+		"st john":         "saint john",
+		"st. catharines":  "saint catharines",
+		"fort st. john":   "fort saint john",
+		"ste-foy":         "sainte-foy",
+		"ft. mcmurray":    "fort mcmurray",
+		"mt pearl":        "mount pearl",
+		"stamford":        "stamford",
+		"west st":         "west saint",
+		" st. ":           " saint ",
+		"côte-st-luc":     "côte-saint-luc",
+		"st.-jean":        "saint-jean",
+		"mountain view":   "mountain view",
+		"mtn view":        "mountain view",
+		"(st) catharines": "(saint) catharines",
+	}
+	for key, expected := range tests {
+		t.Run(key, func(t *testing.T) {
+			if actual := synonyms.Normalize(key); actual != expected {
+				t.Errorf("%q != %q", actual, expected)
+			}
+		})
+	}
+
+	var none *Synonyms
+	if none.Normalize("st john") != "st john" {
+		t.Errorf("nil synonyms should not change keys")
+	}
+}
+
+func TestSynonyms_Variants(t *testing.T) {
+	synonyms := DefaultSynonyms()
+
+	tests := map[string][]string{
+		"st":      {"saint", "st"},
+		"st.":     {"saint"},
+		"st ":     {"saint "},
+		"st cath": {"saint cath"},
+		"ft st":   {"fort saint", "fort st"},
+		"stam":    {"stam"},
+	}
+	for query, expected := range tests {
+		t.Run(query, func(t *testing.T) {
+			if actual := synonyms.Variants(query); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%#v != %#v", actual, expected)
+			}
+		})
+	}
+}
+
+func TestSynonyms_Read(t *testing.T) {
+	tests := map[string]struct {
+		file  string
+		valid bool
+	}{
+		"groups, aliases and comments": {"# comment\n\npt, port\nbig apple = New York, NY\n", true},
+		"single word group":            {"saint\n", false},
+		"multiple words in a group":    {"saint john, sj\n", false},
+		"empty alias":                  {" = New York\n", false},
+		"empty alias query":            {"nyc =\n", false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := NewSynonyms().Read(strings.NewReader(tt.file))
+			if (err == nil) != tt.valid {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	synonyms := DefaultSynonyms()
+	if err := synonyms.Read(strings.NewReader("pt, port\nbig apple = New York, NY\n")); err != nil {
+		t.Fatal(err)
+	}
+	if actual := synonyms.Normalize("port st lucie"); actual != "pt saint lucie" {
+		t.Errorf("%q", actual)
+	}
+	if alias, found := synonyms.Alias(" Big Apple "); !found || alias != "New York, NY" {
+		t.Errorf("%q, %v", alias, found)
+	}
+	if _, found := synonyms.Alias("NYC"); !found {
+		t.Errorf("built-in aliases should be kept")
+	}
+}
+
+func TestTrie_Synonyms(t *testing.T) {
+	stCatharines := Location{Name: "St. Catharines", ID: "1"}
+	saintJohn := Location{Name: "Saint John", ID: "2"}
+	stamford := Location{Name: "Stamford", ID: "3"}
+	fortStJohn := Location{Name: "Fort St. John", ID: "4"}
+
+	tree := makeTrie(stCatharines, saintJohn, stamford, fortStJohn)
+
+	tests := map[string][]string{
+		"saint catharines": {"St. Catharines"},
+		"st catharines":    {"St. Catharines"},
+		"st. john":         {"Saint John"},
+		"st":               {"Stamford", "Saint John", "St. Catharines"},
+		"ft saint":         {"Fort St. John"},
+		"john":             {},
+	}
+	for query, expected := range tests {
+		t.Run(query, func(t *testing.T) {
+			if actual := names(tree.FindMatches(query, 0)); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%#v != %#v", actual, expected)
+			}
+		})
+	}
+
+	if !tree.Find("Saint Catharines") {
+		t.Errorf("synonyms should apply to exact keys")
+	}
+
+	expected := []Match{{saintJohn, 0, false}, {fortStJohn, 1, false}, {stCatharines, 0, false}}
+	if actual := tree.FindTokenMatches("saint", 0); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%#v != %#v", actual, expected)
+	}
+}
@@ -0,0 +1,266 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A LocationRef is the index of a location in a LocationTable. Indexes store
+// refs instead of Location values so that each location is only held once.
+type LocationRef uint32
+
+// A LocationTable stores locations as a struct of arrays. Every column is a
+// flat slice indexed by LocationRef, names are packed into a single byte arena,
+// and low-cardinality strings (countries, regions, display name suffixes) are
+// interned. This keeps the per-location overhead to a few dozen bytes, which is
+// what makes loading the full GeoNames dump feasible (see NOTES.md).
+type LocationTable struct {
+	ids     []uint32
+	nameOff []uint32
+	nameLen []uint16
+	lat     []float32
+	long    []float32
+	country []uint32
+	region  []uint32
+	suffix  []uint32
+	feature []uint32
+	pop     []uint32
+	days    []uint16 // modification date, as days since the Unix epoch
+
+	names   []byte
+	strings *StringPool
+
+	// Locations that have been replaced or removed stay in the table so refs
+	// remain stable, but are flagged here and skipped by indexes.
+	deleted []uint64
+
+	// byID holds refs sorted by ID, and the locations appended since it was
+	// last sorted are tracked in the (much smaller) recent map. Both are kept
+	// up to date by Append, so that lookups only ever read them.
+	byID   []LocationRef
+	recent map[uint32]LocationRef
+
+	// grid indexes refs by where they are, built on the first area lookup
+	grid *GeoGrid
+}
+
+const dateFormat = "2006-01-02"
+
+func NewLocationTable() *LocationTable {
+	return &LocationTable{
+		strings: NewStringPool(),
+		recent:  make(map[uint32]LocationRef),
+	}
+}
+
+// Append adds a location to the table and returns its ref. IDs have to be
+// numeric GeoNames ids that fit in 32 bits, which the loaders check, or empty;
+// anything else is stored as an empty ID.
+func (table *LocationTable) Append(location Location) LocationRef {
+	ref := LocationRef(len(table.ids))
+
+	id, _ := strconv.ParseUint(location.ID, 10, 32)
+	table.ids = append(table.ids, uint32(id))
+	table.nameOff = append(table.nameOff, uint32(len(table.names)))
+	table.nameLen = append(table.nameLen, uint16(len(location.Name)))
+	table.names = append(table.names, location.Name...)
+	table.lat = append(table.lat, float32(location.Lat))
+	table.long = append(table.long, float32(location.Long))
+	table.country = append(table.country, table.strings.Intern(location.Country))
+	table.region = append(table.region, table.strings.Intern(location.Region))
+
+	// Display names are almost always the name followed by a shared suffix
+	// like ", Ontario, CA", so only the suffix needs to be stored
+	suffix := location.DisplayName
+	if strings.HasPrefix(suffix, location.Name) {
+		suffix = suffix[len(location.Name):]
+	} else {
+		// mark suffixes that are actually full display names with a NUL
+		suffix = "\x00" + suffix
+	}
+	table.suffix = append(table.suffix, table.strings.Intern(suffix))
+	table.feature = append(table.feature, table.strings.Intern(location.FeatureCode))
+	table.pop = append(table.pop, clampPopulation(location.Population))
+	table.days = append(table.days, parseDays(location.ModifiedAt))
+
+	// re-sort once the unsorted locations are a significant fraction, which
+	// keeps the map small however many locations are appended
+	if id != 0 {
+		table.recent[uint32(id)] = ref
+		if len(table.recent) > len(table.byID)/4+1024 {
+			table.indexIDs()
+		}
+	}
+	if table.grid != nil {
+		table.grid.Insert(ref, float64(table.lat[ref]), float64(table.long[ref]))
+	}
+
+	return ref
+}
+
+// Len returns the number of locations in the table.
+func (table *LocationTable) Len() int {
+	return len(table.ids)
+}
+
+// Name returns the name of a location without materializing the rest of it.
+func (table *LocationTable) Name(ref LocationRef) string {
+	off := table.nameOff[ref]
+	return string(table.names[off : off+uint32(table.nameLen[ref])])
+}
+
+// Location materializes the location stored at ref.
+func (table *LocationTable) Location(ref LocationRef) Location {
+	location := Location{
+		Name:        table.Name(ref),
+		Lat:         float64(table.lat[ref]),
+		Long:        float64(table.long[ref]),
+		Country:     table.strings.String(table.country[ref]),
+		Region:      table.strings.String(table.region[ref]),
+		FeatureCode: table.strings.String(table.feature[ref]),
+		Population:  int64(table.pop[ref]),
+	}
+
+	if id := table.ids[ref]; id != 0 {
+		location.ID = strconv.FormatUint(uint64(id), 10)
+	}
+
+	location.ModifiedAt = table.ModifiedAt(ref)
+
+	suffix := table.strings.String(table.suffix[ref])
+	if strings.HasPrefix(suffix, "\x00") {
+		location.DisplayName = suffix[1:]
+	} else {
+		location.DisplayName = location.Name + suffix
+	}
+
+	return location
+}
+
+// Locations materializes a list of refs.
+func (table *LocationTable) Locations(refs []LocationRef) []Location {
+	locations := make([]Location, 0, len(refs))
+	for _, ref := range refs {
+		locations = append(locations, table.Location(ref))
+	}
+	return locations
+}
+
+// Delete flags a location as removed. It stays in the table, but indexes will
+// no longer return it.
+func (table *LocationTable) Delete(ref LocationRef) {
+	word := int(ref / 64)
+	for len(table.deleted) <= word {
+		table.deleted = append(table.deleted, 0)
+	}
+	table.deleted[word] |= 1 << (ref % 64)
+}
+
+// Deleted reports whether a location has been removed.
+func (table *LocationTable) Deleted(ref LocationRef) bool {
+	word := int(ref / 64)
+	return word < len(table.deleted) && table.deleted[word]&(1<<(ref%64)) != 0
+}
+
+// Lookup finds the current (not deleted) location with a GeoNames id. It only
+// reads the table, so lookups can run concurrently with each other (but not
+// with Append).
+func (table *LocationTable) Lookup(id string) (LocationRef, bool) {
+	key, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || key == 0 {
+		return 0, false
+	}
+
+	if ref, found := table.recent[uint32(key)]; found && !table.Deleted(ref) {
+		return ref, true
+	}
+
+	i := sort.Search(len(table.byID), func(i int) bool {
+		return table.ids[table.byID[i]] >= uint32(key)
+	})
+	for ; i < len(table.byID) && table.ids[table.byID[i]] == uint32(key); i++ {
+		if ref := table.byID[i]; !table.Deleted(ref) {
+			return ref, true
+		}
+	}
+
+	return 0, false
+}
+
+// InArea finds the current locations in an area, if there are at most limit
+// locations in the cells of the grid it overlaps. The grid has to have been
+// built with indexGrid.
+func (table *LocationTable) InArea(area Area, limit int) ([]LocationRef, bool) {
+	candidates, found := table.grid.Find(area, limit)
+	if !found {
+		return nil, false
+	}
+
+	refs := []LocationRef{}
+	for _, ref := range candidates {
+		if !table.Deleted(ref) && area.Contains(float64(table.lat[ref]), float64(table.long[ref])) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs, true
+}
+
+func (table *LocationTable) indexGrid() {
+	table.grid = NewGeoGrid()
+	for ref := range table.ids {
+		table.grid.Insert(LocationRef(ref), float64(table.lat[ref]), float64(table.long[ref]))
+	}
+}
+
+// ModifiedAt returns the modification date of a location.
+func (table *LocationTable) ModifiedAt(ref LocationRef) string {
+	return formatDays(table.days[ref])
+}
+
+func (table *LocationTable) indexIDs() {
+	table.byID = make([]LocationRef, len(table.ids))
+	for i := range table.byID {
+		table.byID[i] = LocationRef(i)
+	}
+	sort.SliceStable(table.byID, func(i, j int) bool {
+		return table.ids[table.byID[i]] < table.ids[table.byID[j]]
+	})
+	table.recent = make(map[uint32]LocationRef)
+}
+
+// Population returns the population of a location without materializing it.
+func (table *LocationTable) Population(ref LocationRef) int64 {
+	return int64(table.pop[ref])
+}
+
+// Populations are stored as uint32, which covers every city on Earth; larger
+// values (only ever continents or countries) are clamped.
+func clampPopulation(population int64) uint32 {
+	if population < 0 {
+		return 0
+	} else if population > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(population)
+}
+
+// formatDays converts days since the Unix epoch back to a yyyy-MM-dd date.
+func formatDays(days uint16) string {
+	if days == 0 {
+		return ""
+	}
+	return time.Unix(int64(days)*86400, 0).UTC().Format(dateFormat)
+}
+
+// parseDays converts a yyyy-MM-dd date to days since the Unix epoch, or 0 if
+// the date is missing or invalid.
+func parseDays(date string) uint16 {
+	t, err := time.Parse(dateFormat, date)
+	if err != nil || t.Unix() <= 0 {
+		return 0
+	}
+	return uint16(t.Unix() / 86400)
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const testDataPath = "../data/cities_canada-usa.tsv"
+
+func TestLocationTable_Location(t *testing.T) {
+	tests := map[string]Location{
+		"full location": {
+			ID:          "6174041",
+			Name:        "Victoria",
+			DisplayName: "Victoria, British Columbia, CA",
+			Lat:         48.43294143676758,
+			Long:        -123.36930084228516,
+			Country:     "CA",
+			Region:      "02",
+			FeatureCode: "PPLA",
+			Population:  289625,
+			ModifiedAt:  "2013-04-22",
+		},
+		"empty location": {},
+		"display name without name prefix": {
+			Name:        "Montréal",
+			DisplayName: "Montreal, Quebec, CA",
+		},
+	}
+	for name, location := range tests {
+		t.Run(name, func(t *testing.T) {
+			table := NewLocationTable()
+			table.Append(Location{Name: "padding", DisplayName: "padding, XX"})
+			ref := table.Append(location)
+
+			if actual := table.Location(ref); !reflect.DeepEqual(actual, location) {
+				t.Errorf("%#v != %#v", actual, location)
+			}
+		})
+	}
+}
+
+func TestLocationTable_InternsSuffixes(t *testing.T) {
+	table := NewLocationTable()
+	table.Append(Location{Name: "London", DisplayName: "London, Ontario, CA", Country: "CA", Region: "08"})
+	table.Append(Location{Name: "Ottawa", DisplayName: "Ottawa, Ontario, CA", Country: "CA", Region: "08"})
+
+	// "CA", "08", ", Ontario, CA" and ""
+	if table.strings.Len() != 4 {
+		t.Errorf("%d interned strings != 4", table.strings.Len())
+	}
+}
+
+// Reports the heap used by a loaded tree (table and index) per location. This
+// is the number to track when estimating the memory needed for larger dumps.
+func BenchmarkLoadTrie(b *testing.B) {
+	var stats runtime.MemStats
+	var tree *Trie
+
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(testDataPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		before := stats.HeapAlloc
+
+		if tree, err = LoadTrie(f, nil); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		b.ReportMetric(float64(stats.HeapAlloc-before)/float64(tree.Locations().Len()), "bytes/location")
+	}
+
+	runtime.KeepAlive(tree)
+}
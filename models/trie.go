@@ -1,110 +1,569 @@
 package models
 
-import "strings"
+import (
+	"context"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
 
 // This is a tree that:
-// - stores its children in a map{string -> node}
-// - uses single char keys in every node
-// - is case-insensitive
+//   - stores all of its nodes in a single flat slice, referenced by index
+//   - links each node to its first child and next sibling, in character order,
+//     so that nodes don't need their own edge maps or slices
+//   - uses single char keys in every node
+//   - is case-insensitive
+//   - stores refs into a LocationTable at its leaves, rather than locations
+//
+// Each node is a fixed 20 bytes with no separate allocations, which keeps the
+// index small enough for the full GeoNames dump.
+//
+// A Trie is safe for concurrent use, so it can be updated while serving.
 type Trie struct {
-	edges Edges
-	leaf  bool
-	value []Location
+	mu        sync.RWMutex
+	nodes     []trieNode
+	postings  []posting
+	locations *LocationTable
+	synonyms  *Synonyms
+
+	phonetic *Trie // index of phonetic keys, if enabled
+	encoded  bool  // whether this is a phonetic index
+
+	// how many more characters a key can have than its name has bytes, since
+	// normalizing can lengthen words ("st" is indexed as "saint")
+	slack int
+}
+
+type trieNode struct {
+	char    rune   // the character on the edge leading to this node
+	child   uint32 // first child, or 0 if there are none
+	sibling uint32 // next sibling, or 0 if this is the last one
+	first   uint32 // first posting stored at this node, or 0 if not a leaf
+	last    uint32 // last posting stored at this node
+}
+
+// A posting is an entry in the linked list of refs stored at a node.
+type posting struct {
+	ref   LocationRef
+	next  uint32
+	token uint8 // which word of the key starts here, 0 for the whole key
 }
 
-type Edges map[rune]*Trie
+// Words past this many into a key aren't indexed on their own.
+const maxTokens = 255
+
+// the root node is always the first node in the slice
+const rootNode uint32 = 0
 
 func NewTrie() *Trie {
+	return NewTrieWithTable(NewLocationTable())
+}
+
+// NewTrieWithTable creates an empty tree that indexes locations stored in an
+// existing table.
+func NewTrieWithTable(locations *LocationTable) *Trie {
 	return &Trie{
-		edges: make(Edges),
-		leaf:  false,
+		nodes:     []trieNode{{}},
+		postings:  []posting{{}}, // index 0 is reserved to mean "none"
+		locations: locations,
+		synonyms:  DefaultSynonyms(),
 	}
 }
 
-// Insert a key into the tree.
-func (tree *Trie) Insert(key string, value Location) {
-	var node *Trie = tree
+// Locations returns the table that the tree's refs point into.
+func (tree *Trie) Locations() *LocationTable {
+	return tree.locations
+}
 
-	// iterate through each character in the key, lower-cased
-	for _, char := range strings.ToLower(key) {
-		// check to see if it exists as a child of the current tree
-		leaf, found := node.edges[char]
-		if !found {
-			// if not found, we need to create the leaf node and attach it
-			leaf = NewTrie()
-			node.edges[char] = leaf
+// Synonyms returns the dictionary used to normalize keys and queries.
+func (tree *Trie) Synonyms() *Synonyms {
+	return tree.synonyms
+}
+
+// SetSynonyms replaces the built-in synonyms dictionary. Keys are normalized
+// as they're inserted, so this has to be called before inserting anything.
+func (tree *Trie) SetSynonyms(synonyms *Synonyms) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	tree.synonyms = synonyms
+	if tree.phonetic != nil {
+		tree.phonetic.synonyms = synonyms
+	}
+}
+
+// EnablePhonetic builds a second index next to the tree, keyed by the Double
+// Metaphone keys of each name, so that names can be found by how they sound.
+// Locations already in the table are indexed by name, and every key inserted
+// after this is indexed in both.
+func (tree *Trie) EnablePhonetic() {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if tree.phonetic != nil {
+		return
+	}
+
+	tree.phonetic = NewTrieWithTable(tree.locations)
+	tree.phonetic.synonyms = tree.synonyms
+	tree.phonetic.encoded = true
+	for ref := LocationRef(0); int(ref) < tree.locations.Len(); ref++ {
+		if !tree.locations.Deleted(ref) {
+			tree.phonetic.insertRef(tree.locations.Name(ref), ref)
 		}
+	}
+}
+
+// Insert a key into the tree, storing the value in the tree's location table.
+func (tree *Trie) Insert(key string, value Location) LocationRef {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	ref := tree.locations.Append(value)
+	tree.insertRef(key, ref)
+	return ref
+}
+
+// InsertRef inserts a key for a location that is already in the tree's table.
+func (tree *Trie) InsertRef(key string, ref LocationRef) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	tree.insertRef(key, ref)
+}
 
-		// look at the current leaf node in the next iteration
-		node = leaf
+// Get returns the current location with a GeoNames id.
+func (tree *Trie) Get(id string) (Location, bool) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	if ref, found := tree.locations.Lookup(id); found {
+		return tree.locations.Location(ref), true
 	}
+	return Location{}, false
+}
+
+// Lookup returns the ref of the current location with a GeoNames id.
+func (tree *Trie) Lookup(id string) (LocationRef, bool) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
 
-	// mark the current leaf node as a leaf and store the value
-	node.leaf = true
-	node.value = append(node.value, value)
+	return tree.locations.Lookup(id)
 }
 
-// Check if a key is present in the tree.
-func (tree *Trie) Find(key string) bool {
-	var node *Trie = tree
-	var found bool
+// Replace inserts a location under key, removing any existing location with
+// the same ID. It returns the new ref, and true if a location was replaced.
+func (tree *Trie) Replace(key string, value Location) (LocationRef, bool) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
 
-	for _, char := range strings.ToLower(key) {
-		node, found = node.edges[char]
+	old, found := tree.locations.Lookup(value.ID)
+	if found {
+		tree.locations.Delete(old)
+	}
+	ref := tree.locations.Append(value)
+	tree.insertRef(key, ref)
+	return ref, found
+}
+
+// Remove deletes the location with a GeoNames id, returning true if it was
+// present. The location's nodes and postings stay in the tree, but it will no
+// longer be returned by any query.
+func (tree *Trie) Remove(id string) bool {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	ref, found := tree.locations.Lookup(id)
+	if found {
+		tree.locations.Delete(ref)
+	}
+	return found
+}
+
+func (tree *Trie) insertRef(key string, ref LocationRef) {
+	keys := tree.keys(key)
+	for i, key := range keys {
+		tree.slack = max(tree.slack, utf8.RuneCountInString(key)-len(tree.locations.Name(ref)))
+
+		for token, start := range tokenStarts(key) {
+			// the alternate phonetic key often only differs in its first words,
+			// so the words it shares with the primary key are already indexed
+			if i > 0 && isWordSuffix(keys[0], key[start:]) {
+				continue
+			}
+			tree.insertPosting(key[start:], posting{ref: ref, token: uint8(token)})
+		}
+	}
+
+	if tree.phonetic != nil {
+		tree.phonetic.insertRef(key, ref)
+	}
+}
+
+// isWordSuffix checks whether key ends with suffix, starting at a word.
+func isWordSuffix(key, suffix string) bool {
+	n := len(key) - len(suffix)
+	return n >= 0 && key[n:] == suffix && (n == 0 || key[n-1] == ' ')
+}
+
+func (tree *Trie) keys(name string) []string {
+	return indexKeys(tree.synonyms, name, tree.encoded)
+}
+
+func (tree *Trie) variants(prefix string) []string {
+	return searchKeys(tree.synonyms, prefix, tree.encoded)
+}
+
+// indexKeys returns the keys to index a name under: the normalized name, or
+// its phonetic keys in a phonetic index.
+func indexKeys(synonyms *Synonyms, name string, phonetic bool) []string {
+	key := synonyms.Normalize(strings.ToLower(name))
+	if phonetic {
+		return PhoneticKeys(key)
+	}
+	return []string{key}
+}
+
+// searchKeys returns the keys to search for a prefix.
+func searchKeys(synonyms *Synonyms, prefix string, phonetic bool) []string {
+	variants := synonyms.Variants(strings.ToLower(prefix))
+	if !phonetic {
+		return variants
+	}
+
+	keys := []string{}
+	for _, variant := range variants {
+	next:
+		for _, key := range PhoneticKeys(variant) {
+			for _, existing := range keys {
+				if key == existing {
+					continue next
+				}
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (tree *Trie) insertPosting(key string, value posting) {
+	node := rootNode
+
+	// iterate through each character in the key
+	for _, char := range key {
+		// check to see if it exists as a child of the current node
+		child, found := tree.child(node, char)
 		if !found {
-			return false
+			// if not found, we need to create the node and attach it
+			child = tree.addChild(node, char)
 		}
+
+		// look at the child node in the next iteration
+		node = child
 	}
 
-	if node != nil && node.leaf {
-		return true
+	// store the posting in the final node, which marks it as a leaf
+	p := uint32(len(tree.postings))
+	tree.postings = append(tree.postings, value)
+	if tree.nodes[node].first == 0 {
+		tree.nodes[node].first = p
+	} else {
+		tree.postings[tree.nodes[node].last].next = p
 	}
+	tree.nodes[node].last = p
+}
 
+// Check if a key is present in the tree.
+func (tree *Trie) Find(key string) bool {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	node, found := tree.walk(tree.synonyms.Normalize(strings.ToLower(key)))
+	if !found {
+		return false
+	}
+	for p := tree.nodes[node].first; p != 0; p = tree.postings[p].next {
+		if tree.postings[p].token == 0 && !tree.locations.Deleted(tree.postings[p].ref) {
+			return true
+		}
+	}
 	return false
 }
 
 // Find <limit> matches with the given <prefix>.
 func (tree *Trie) FindMatches(prefix string, limit int) []Location {
-	root := tree
-	count := 0
-	results := []Location{}
-
-	// find the subset of the tree that matches the query,
-	// and set that as the current root
-	for _, char := range strings.ToLower(prefix) {
-		child, found := root.edges[char]
-		if !found {
-			return results
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	return tree.locations.Locations(tree.findRefs(prefix, limit))
+}
+
+// FindRefs is like FindMatches, but returns refs into the location table.
+func (tree *Trie) FindRefs(prefix string, limit int) []LocationRef {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	return tree.findRefs(prefix, limit)
+}
+
+// FindTokenMatches finds <limit> locations with any word that starts with
+// <prefix>, so "york" finds "New York" as well as "Yorktown". Each location is
+// returned once, with the first of its words that matched.
+func (tree *Trie) FindTokenMatches(prefix string, limit int) []Match {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	matches := []Match{}
+	for _, result := range tree.search(prefix, limit, true) {
+		matches = append(matches, Match{
+			Location: tree.locations.Location(result.ref),
+			Token:    int(result.token),
+		})
+	}
+	return matches
+}
+
+// FindPhoneticMatches is like FindTokenMatches, but finds locations with names
+// that sound like <prefix>, so "shyenne" finds "Cheyenne". It returns nothing
+// unless EnablePhonetic has been called.
+func (tree *Trie) FindPhoneticMatches(prefix string, limit int) []Match {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	matches := []Match{}
+	if tree.phonetic == nil {
+		return matches
+	}
+	for _, result := range tree.phonetic.search(prefix, limit, true) {
+		matches = append(matches, Match{
+			Location: tree.locations.Location(result.ref),
+			Token:    int(result.token),
+			Phonetic: true,
+		})
+	}
+	return matches
+}
+
+func (tree *Trie) findRefs(prefix string, limit int) []LocationRef {
+	results := []LocationRef{}
+	for _, result := range tree.search(prefix, limit, false) {
+		results = append(results, result.ref)
+	}
+	return results
+}
+
+// Walk calls visit with each location that has a word starting with prefix,
+// like FindTokenMatches, but as they're found rather than all at once. They're
+// visited in order of the length of the key they were found under (the name
+// from the word that matched), shortest first. The walk stops when visit
+// returns false or ctx is done, and returns ctx.Err() in the second case.
+//
+// The tree is locked for reading during the walk, so visit can't modify it.
+func (tree *Trie) Walk(ctx context.Context, prefix string, visit Visitor) error {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	return tree.find(ctx, prefix, true, func(result posting, depth int) bool {
+		match := Match{Location: tree.locations.Location(result.ref), Token: int(result.token)}
+		return visit(match, depth-tree.slack)
+	})
+}
+
+// FindInArea finds the locations in area with a word starting with prefix, by
+// checking each location in the cells of the table's grid that it overlaps.
+// The grid is built on the first call.
+func (tree *Trie) FindInArea(prefix string, area Area, limit int) ([]Match, bool) {
+	tree.mu.RLock()
+	if tree.locations.grid == nil {
+		tree.mu.RUnlock()
+		tree.mu.Lock()
+		if tree.locations.grid == nil {
+			tree.locations.indexGrid()
 		}
-		root = child
+		tree.mu.Unlock()
+		tree.mu.RLock()
 	}
+	defer tree.mu.RUnlock()
 
-	// breadth first search starting at current root node
-	queue := []*Trie{root}
-	var node *Trie
+	refs, found := tree.locations.InArea(area, limit)
+	if !found {
+		return nil, false
+	}
+	return areaMatches(tree.synonyms, tree.locations.Locations(refs), prefix), true
+}
 
-loop_nodes:
-	for len(queue) > 0 {
-		// dequeue safely (range doesn't allow modifying the original slice)
-		node, queue = queue[0], queue[1:]
+// search collects up to limit distinct locations with keys that start with
+// prefix. Postings for later words are skipped unless tokens is set.
+func (tree *Trie) search(prefix string, limit int, tokens bool) []posting {
+	results := []posting{}
+	tree.find(context.Background(), prefix, tokens, func(result posting, depth int) bool {
+		results = append(results, result)
+		return limit <= 0 || len(results) < limit
+	})
+	return results
+}
 
-		// only store leaf nodes as results
-		if node.leaf {
-			for _, result := range node.value {
-				results = append(results, result)
-				count += 1
+// find visits each location with a key that starts with any variant of
+// prefix once, in order of key length, along with the length of the key it
+// was found under. The token of each posting is the first word that matches
+// the first variant that matches.
+func (tree *Trie) find(ctx context.Context, prefix string, tokens bool, visit func(result posting, depth int) bool) error {
+	// find the subsets of the tree that match the query,
+	// and set those as the roots
+	variants := tree.variants(prefix)
+	roots := []walkRoot{}
+	for _, variant := range variants {
+		if node, found := tree.walk(variant); found {
+			roots = append(roots, walkRoot{node: node, depth: utf8.RuneCountInString(variant)})
+		}
+	}
+
+	seen := make(map[LocationRef]bool)
+	return tree.traverse(ctx, roots, func(result posting, depth int) bool {
+		if result.token > 0 && !tokens {
+			return true
+		}
 
-				if limit > 0 && count >= limit {
-					break loop_nodes
+		// several words of the same location can match, but a later word
+		// can be reached first (its key is shorter), so keep the first word
+		// that matches
+		if seen[result.ref] {
+			return true
+		}
+		seen[result.ref] = true
+		if tokens && (result.token > 0 || len(variants) > 1) {
+			result.token = firstToken(tree.keys(tree.locations.Name(result.ref)), variants, result.token)
+		}
+
+		return visit(result, depth)
+	})
+}
+
+// A walkRoot is a node to visit the postings below, and the length of the key
+// that leads to it.
+type walkRoot struct {
+	node  uint32
+	depth int
+}
+
+// traverse visits the postings below each root breadth first, a level at a
+// time. The next level is always the shallowest of any root's (the first
+// root's on a tie), so postings are visited in order of key length even when
+// the roots are at different depths. It stops when visit returns false or ctx
+// is done.
+func (tree *Trie) traverse(ctx context.Context, roots []walkRoot, visit func(result posting, depth int) bool) error {
+	levels := make([][]uint32, len(roots))
+	for i, root := range roots {
+		levels[i] = []uint32{root.node}
+	}
+
+	for {
+		next := -1
+		for i := range roots {
+			if len(levels[i]) > 0 && (next < 0 || roots[i].depth < roots[next].depth) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		children := []uint32{}
+		for _, node := range levels[next] {
+			// only leaf nodes have postings
+			for p := tree.nodes[node].first; p != 0; p = tree.postings[p].next {
+				result := tree.postings[p]
+				if tree.locations.Deleted(result.ref) {
+					continue
+				}
+				if !visit(result, roots[next].depth) {
+					return nil
 				}
 			}
+
+			for child := tree.nodes[node].child; child != 0; child = tree.nodes[child].sibling {
+				children = append(children, child)
+			}
 		}
+		levels[next] = children
+		roots[next].depth++
+	}
+}
 
-		// after processing each node, add its children to the end of the queue
-		for _, child := range node.edges {
-			queue = append(queue, child)
+// walk follows key from the root, returning the node it ends at.
+func (tree *Trie) walk(key string) (uint32, bool) {
+	node := rootNode
+
+	for _, char := range strings.ToLower(key) {
+		child, found := tree.child(node, char)
+		if !found {
+			return 0, false
 		}
+		node = child
 	}
 
-	return results
+	return node, true
+}
+
+func (tree *Trie) child(node uint32, char rune) (uint32, bool) {
+	// siblings are sorted, so stop as soon as we've gone past char
+	for child := tree.nodes[node].child; child != 0; child = tree.nodes[child].sibling {
+		if c := tree.nodes[child].char; c == char {
+			return child, true
+		} else if c > char {
+			break
+		}
+	}
+	return 0, false
+}
+
+func (tree *Trie) addChild(node uint32, char rune) uint32 {
+	child := uint32(len(tree.nodes))
+	tree.nodes = append(tree.nodes, trieNode{char: char})
+
+	// keep siblings sorted by character so traversal order is predictable
+	prev, next := uint32(0), tree.nodes[node].child
+	for next != 0 && tree.nodes[next].char < char {
+		prev, next = next, tree.nodes[next].sibling
+	}
+	tree.nodes[child].sibling = next
+	if prev == 0 {
+		tree.nodes[node].child = child
+	} else {
+		tree.nodes[prev].sibling = child
+	}
+
+	return child
+}
+
+// tokenStarts returns the byte offset of each word in key. The first word
+// always starts at 0, even if the key starts with punctuation.
+func tokenStarts(key string) []int {
+	starts := []int{0}
+	word := true
+
+	for i, char := range key {
+		isWord := !isSeparator(char)
+		if isWord && !word && len(starts) < maxTokens {
+			starts = append(starts, i)
+		}
+		word = isWord
+	}
+
+	return starts
+}
+
+// firstToken finds the first word of any of the keys of a location that
+// starts with the first of the variants that any word does. Keys don't have
+// to be names, so token is returned if none of them do.
+func firstToken(keys, variants []string, token uint8) uint8 {
+	for _, variant := range variants {
+		if first, found := firstTokenWithPrefix(keys, variant); found {
+			return uint8(first)
+		}
+	}
+	return token
 }
@@ -1,26 +1,38 @@
 package models
 
 import (
+	"context"
+	"fmt"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
 )
 
-func makeTree(args ...interface{}) *Trie {
+func makeTrie(values ...Location) *Trie {
 	tree := NewTrie()
-	for len(args) >= 2 {
-		char, child, rest := args[0], args[1], args[2:]
-		tree.edges[char.(rune)] = child.(*Trie)
-		args = rest
+	for _, value := range values {
+		tree.Insert(value.Name, value)
 	}
 	return tree
 }
 
-func makeLeaf(value ...Location) *Trie {
-	tree := NewTrie()
-	tree.leaf = true
-	tree.value = append(tree.value, value...)
-	return tree
+// contents lists every key in the tree along with the locations stored there.
+func contents(tree *Trie) map[string][]Location {
+	keys := make(map[string][]Location)
+
+	var visit func(node uint32, key string)
+	visit = func(node uint32, key string) {
+		for p := tree.nodes[node].first; p != 0; p = tree.postings[p].next {
+			keys[key] = append(keys[key], tree.locations.Location(tree.postings[p].ref))
+		}
+		for child := tree.nodes[node].child; child != 0; child = tree.nodes[child].sibling {
+			visit(child, key+string(tree.nodes[child].char))
+		}
+	}
+	visit(rootNode, "")
+
+	return keys
 }
 
 func TestTrie_Insert(t *testing.T) {
@@ -28,56 +40,72 @@ func TestTrie_Insert(t *testing.T) {
 		before *Trie
 		key    string
 		value  Location
-		after  *Trie
+		after  map[string][]Location
 	}{
 		"single character empty tree": {
 			NewTrie(),
 			"a",
 			Location{Name: "a"},
-			makeTree('a', makeLeaf(Location{Name: "a"})),
+			map[string][]Location{"a": {{Name: "a"}}},
 		},
 		"empty key empty tree": {
 			NewTrie(),
 			"",
 			Location{Name: ""},
-			makeLeaf(Location{Name: ""}),
+			map[string][]Location{"": {{Name: ""}}},
 		},
 		"multiple characters empty tree": {
 			NewTrie(),
 			"abc",
 			Location{Name: "abc"},
-			makeTree('a', makeTree('b', makeTree('c', makeLeaf(Location{Name: "abc"})))),
+			map[string][]Location{"abc": {{Name: "abc"}}},
 		},
 		"multiple characters case-insensitive": {
 			NewTrie(),
 			"ABC",
 			Location{Name: "ABC"},
-			makeTree('a', makeTree('b', makeTree('c', makeLeaf(Location{Name: "ABC"})))),
+			map[string][]Location{"abc": {{Name: "ABC"}}},
 		},
 		"multiple characters non-empty tree": {
-			makeTree('a', makeTree('b', makeTree('c', makeLeaf(Location{Name: "abc"})))),
+			makeTrie(Location{Name: "abc"}),
 			"abd",
 			Location{Name: "abd"},
-			makeTree(
-				'a', makeTree(
-					'b', makeTree(
-						'c', makeLeaf(Location{Name: "abc"}),
-						'd', makeLeaf(Location{Name: "abd"}),
-					),
-				),
-			),
+			map[string][]Location{
+				"abc": {{Name: "abc"}},
+				"abd": {{Name: "abd"}},
+			},
+		},
+		"duplicate key": {
+			makeTrie(Location{Name: "abc", ID: "1"}),
+			"abc",
+			Location{Name: "abc", ID: "2"},
+			map[string][]Location{
+				"abc": {{Name: "abc", ID: "1"}, {Name: "abc", ID: "2"}},
+			},
 		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tree := tt.before
-			if tree.Insert(tt.key, tt.value); !reflect.DeepEqual(tree, tt.after) {
-				t.Errorf("\n%#v\n!=\n%#v", tree, tt.after)
+			if tree.Insert(tt.key, tt.value); !reflect.DeepEqual(contents(tree), tt.after) {
+				t.Errorf("\n%#v\n!=\n%#v", contents(tree), tt.after)
 			}
 		})
 	}
 }
 
+func TestTrie_InsertSharesNodes(t *testing.T) {
+	tree := makeTrie(Location{Name: "abc"}, Location{Name: "abd"}, Location{Name: "ab"})
+
+	// root, a, b, c, d
+	if len(tree.nodes) != 5 {
+		t.Errorf("%d nodes != 5", len(tree.nodes))
+	}
+	if tree.Locations().Len() != 3 {
+		t.Errorf("%d locations != 3", tree.Locations().Len())
+	}
+}
+
 func TestTrie_Find(t *testing.T) {
 	tests := map[string]struct {
 		tree     *Trie
@@ -90,32 +118,32 @@ func TestTrie_Find(t *testing.T) {
 			false,
 		},
 		"missing key": {
-			makeTree('a', makeLeaf(Location{Name: "a"})),
+			makeTrie(Location{Name: "a"}),
 			"nope",
 			false,
 		},
 		"single character": {
-			makeTree('a', makeLeaf(Location{Name: "a"})),
+			makeTrie(Location{Name: "a"}),
 			"a",
 			true,
 		},
 		"multiple characters": {
-			makeTree('a', makeTree('b', makeTree('c', makeLeaf(Location{Name: "abc"})))),
+			makeTrie(Location{Name: "abc"}),
 			"abc",
 			true,
 		},
 		"multiple character subset": {
-			makeTree('a', makeTree('b', makeTree('c', makeLeaf(Location{Name: "abc"})))),
+			makeTrie(Location{Name: "abc"}),
 			"ab",
 			false,
 		},
 		"multiple character superset": {
-			makeTree('a', makeTree('b', makeTree('c', makeLeaf(Location{Name: "abc"})))),
+			makeTrie(Location{Name: "abc"}),
 			"abcd",
 			false,
 		},
 		"case-insensitive": {
-			makeTree('a', makeTree('b', makeTree('c', makeLeaf(Location{Name: "abc"})))),
+			makeTrie(Location{Name: "abc"}),
 			"ABC",
 			true,
 		},
@@ -143,72 +171,43 @@ func TestTrie_FindMatches(t *testing.T) {
 			[]Location{},
 		},
 		"missing key": {
-			makeTree('a', makeLeaf(Location{Name: "a"})),
+			makeTrie(Location{Name: "a"}),
 			"nope",
 			10,
 			[]Location{},
 		},
 		"exact match": {
-			makeTree('a', makeLeaf(Location{Name: "a"})),
+			makeTrie(Location{Name: "a"}),
 			"a",
 			10,
 			[]Location{{Name: "a"}},
 		},
 		"multiple matches": {
-			makeTree(
-				'a', makeTree(
-					'b', makeTree(
-						'c', makeLeaf(Location{Name: "abc"}),
-						'd', makeLeaf(Location{Name: "abd"}),
-					),
-				),
-			),
+			makeTrie(Location{Name: "abc"}, Location{Name: "abd"}),
 			"ab",
 			10,
 			[]Location{{Name: "abc"}, {Name: "abd"}},
 		},
 		"case-insensitive": {
-			makeTree(
-				'a', makeTree(
-					'b', makeTree(
-						'c', makeLeaf(Location{Name: "ABC"}),
-					),
-				),
-			),
+			makeTrie(Location{Name: "ABC"}),
 			"ABC",
 			10,
 			[]Location{{Name: "ABC"}},
 		},
 		"multiple matches limit returns shortest first": {
-			makeTree('a', makeTree('b', makeTree(
-				'c', makeLeaf(Location{Name: "abc"}),
-				'd', makeTree('e', makeLeaf(Location{Name: "abde"})),
-			))),
+			makeTrie(Location{Name: "abde"}, Location{Name: "abc"}),
 			"ab",
 			1,
 			[]Location{{Name: "abc"}},
 		},
 		"limit < 0 means no limit": {
-			makeTree(
-				'a', makeTree(
-					'b', makeTree(
-						'c', makeLeaf(Location{Name: "abc"}),
-						'd', makeLeaf(Location{Name: "abd"}),
-					),
-				),
-			),
+			makeTrie(Location{Name: "abc"}, Location{Name: "abd"}),
 			"ab",
 			-1,
 			[]Location{{Name: "abc"}, {Name: "abd"}},
 		},
 		"limit is respected by multi-result nodes": {
-			makeTree(
-				'a', makeLeaf(
-					Location{Name: "a"},
-					Location{Name: "a"},
-					Location{Name: "a"},
-				),
-			),
+			makeTrie(Location{Name: "a"}, Location{Name: "a"}, Location{Name: "a"}),
 			"a",
 			2,
 			[]Location{{Name: "a"}, {Name: "a"}},
@@ -224,3 +223,171 @@ func TestTrie_FindMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestTrie_FindTokenMatches(t *testing.T) {
+	newYork := Location{Name: "New York", ID: "1"}
+	yorktown := Location{Name: "Yorktown", ID: "2"}
+	yorkYork := Location{Name: "York-on-York", ID: "3"}
+	niagaraFalls := Location{Name: "Niagara Falls", ID: "4"}
+
+	tree := makeTrie(newYork, yorktown, yorkYork, niagaraFalls)
+
+	tests := map[string]struct {
+		key      string
+		limit    int
+		expected []Match
+	}{
+		"later word": {
+			"falls",
+			0,
+			[]Match{{niagaraFalls, 1, false}},
+		},
+		"start and later words": {
+			"york",
+			0,
+			[]Match{{newYork, 1, false}, {yorkYork, 0, false}, {yorktown, 0, false}},
+		},
+		"duplicate words are only returned once": {
+			"york-on",
+			0,
+			[]Match{{yorkYork, 0, false}},
+		},
+		"spans words": {
+			"new yo",
+			0,
+			[]Match{{newYork, 0, false}},
+		},
+		"limit counts locations": {
+			"york",
+			2,
+			[]Match{{newYork, 1, false}, {yorkYork, 0, false}},
+		},
+		"no match": {
+			"ork",
+			0,
+			[]Match{},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual := tree.FindTokenMatches(tt.key, tt.limit)
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("%#v != %#v", actual, tt.expected)
+			}
+		})
+	}
+
+	// later words don't affect name matching
+	if tree.Find("york") {
+		t.Errorf("later words should not be found as keys")
+	}
+	if actual := tree.FindMatches("york", 0); len(actual) != 2 {
+		t.Errorf("%#v", actual)
+	}
+}
+
+func TestTrie_Walk(t *testing.T) {
+	newYork := Location{Name: "New York", ID: "1"}
+	yorktown := Location{Name: "Yorktown", ID: "2"}
+	york := Location{Name: "York", ID: "3"}
+	stYork := Location{Name: "St. York", ID: "4"}
+
+	tree := makeTrie(newYork, yorktown, york, stYork)
+
+	type visit struct {
+		match     Match
+		minLength int
+	}
+	walk := func(ctx context.Context, prefix string, limit int) ([]visit, error) {
+		visits := []visit{}
+		err := tree.Walk(ctx, prefix, func(match Match, minLength int) bool {
+			visits = append(visits, visit{match, minLength})
+			return len(visits) != limit
+		})
+		return visits, err
+	}
+
+	// shortest key first (in the order they were inserted for the same key),
+	// less the 2 that "st." grows by when it's indexed as "saint"
+	expected := []visit{
+		{Match{newYork, 1, false}, 2},
+		{Match{york, 0, false}, 2},
+		{Match{stYork, 1, false}, 2},
+		{Match{yorktown, 0, false}, 6},
+	}
+	if actual, err := walk(context.Background(), "york", 0); err != nil || !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%#v != %#v (%v)", actual, expected, err)
+	}
+
+	if actual, err := walk(context.Background(), "york", 2); err != nil || !reflect.DeepEqual(actual, expected[:2]) {
+		t.Errorf("%#v != %#v (%v)", actual, expected[:2], err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if actual, err := walk(ctx, "york", 0); err != context.Canceled || len(actual) != 0 {
+		t.Errorf("%#v (%v)", actual, err)
+	}
+}
+
+func TestTokenStarts(t *testing.T) {
+	tests := map[string][]int{
+		"":                []int{0},
+		"york":            []int{0},
+		"new york":        []int{0, 4},
+		"sainte-foy":      []int{0, 7},
+		"st. catharines":  []int{0, 4},
+		"(old) town":      []int{0, 1, 6},
+		"trois-rivières ": []int{0, 6},
+	}
+	for key, expected := range tests {
+		t.Run(key, func(t *testing.T) {
+			if actual := tokenStarts(key); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%#v != %#v", actual, expected)
+			}
+		})
+	}
+}
+
+// Run with -race: lookups by ID mustn't write to the table, so they can run
+// alongside each other and alongside updates (which wait for them).
+func TestTrie_GetConcurrent(t *testing.T) {
+	// a race is only reported if the accesses happen close together, so try
+	// many fresh trees
+	for round := 0; round < 100; round++ {
+		tree := NewTrie()
+		for i := 1; i <= 100; i++ {
+			tree.Insert("Springfield", Location{ID: fmt.Sprint(i), Name: "Springfield"})
+		}
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for reader := 0; reader < 4; reader++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				for i := 1; i <= 100; i++ {
+					if _, found := tree.Get(fmt.Sprint(i)); !found {
+						t.Errorf("location %d not found", i)
+					}
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			// enough new locations to re-sort the IDs
+			for i := 1; i <= 1200; i++ {
+				tree.Replace("Shelbyville", Location{ID: fmt.Sprint(i), Name: "Shelbyville"})
+			}
+		}()
+		close(start)
+		wg.Wait()
+
+		if location, _ := tree.Get("50"); location.Name != "Shelbyville" {
+			t.Errorf("%#v != %#v", location.Name, "Shelbyville")
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GeoNames publishes daily modifications-YYYY-MM-DD.txt files (in the same
+// format as the main dump, without a header) and deletes-YYYY-MM-DD.txt files
+// (geonameid, name and a comment).
+var updateFilePattern = regexp.MustCompile(`^(modifications|deletes)-(\d{4}-\d{2}-\d{2})\.txt$`)
+
+// UpdateStats counts what happened to each row of an update file.
+type UpdateStats struct {
+	Inserted int // new IDs
+	Replaced int // existing IDs with a newer row
+	Stale    int // rows older than the location already loaded
+	Deleted  int
+	Missing  int // deletes for IDs that aren't loaded
+	Filtered int // rows rejected by the load filter
+}
+
+// An Updater applies GeoNames modification and deletion files to a live tree,
+// keyed by geonameid.
+//
+// Applied files are only recorded in memory. The tree is rebuilt from the full
+// dump on every start, so all update files need to be applied again anyway;
+// rows that are older than what's loaded are skipped, so replaying a file that
+// the dump already includes is harmless.
+type Updater struct {
+	tree    *Trie
+	filter  *Filter
+	applied map[string]bool
+}
+
+// NewUpdater creates an updater for a tree. Modified rows are checked against
+// the same filter (which may be nil) that the tree was loaded with.
+func NewUpdater(tree *Trie, filter *Filter) *Updater {
+	return &Updater{
+		tree:    tree,
+		filter:  filter,
+		applied: make(map[string]bool),
+	}
+}
+
+// ApplyDir applies every update file in dir that this updater hasn't applied
+// yet, in date order (modifications before deletes on the same day). It
+// returns the names of the files applied, stopping at the first file that
+// fails. A new updater, like one in a restarted server, applies them all again.
+func (updater *Updater) ApplyDir(dir string) (applied []string, err error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, file := range files {
+		if updateFilePattern.MatchString(file.Name()) && !updater.applied[file.Name()] {
+			pending = append(pending, file.Name())
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		a := updateFilePattern.FindStringSubmatch(pending[i])
+		b := updateFilePattern.FindStringSubmatch(pending[j])
+		if a[2] != b[2] {
+			return a[2] < b[2]
+		}
+		return a[1] > b[1] // "modifications" sorts before "deletes"
+	})
+
+	for _, name := range pending {
+		if _, err := updater.ApplyFile(filepath.Join(dir, name)); err != nil {
+			return applied, err
+		}
+		applied = append(applied, name)
+	}
+
+	return applied, nil
+}
+
+// ApplyFile applies a single update file, choosing how to read it from its name.
+func (updater *Updater) ApplyFile(path string) (stats UpdateStats, err error) {
+	name := filepath.Base(path)
+	match := updateFilePattern.FindStringSubmatch(name)
+	if match == nil {
+		return stats, fmt.Errorf("%s: not a GeoNames update file", name)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+
+	if match[1] == "modifications" {
+		stats, err = updater.ApplyModifications(f)
+	} else {
+		stats, err = updater.ApplyDeletes(f)
+	}
+	if err != nil {
+		return stats, fmt.Errorf("%s: %s", name, err)
+	}
+
+	updater.applied[name] = true
+	return stats, nil
+}
+
+// ApplyModifications inserts or replaces locations from a modifications file.
+func (updater *Updater) ApplyModifications(file io.Reader) (stats UpdateStats, err error) {
+	err = ScanCityData(file, func(location Location) error {
+		current, found := updater.tree.Get(location.ID)
+		if found && current.ModifiedAt > location.ModifiedAt {
+			stats.Stale++
+			return nil
+		}
+
+		// a location that no longer passes the filter has to be removed
+		if !updater.filter.Allow(location) {
+			updater.tree.Remove(location.ID)
+			stats.Filtered++
+			return nil
+		}
+
+		if _, replaced := updater.tree.Replace(location.Name, location); replaced {
+			stats.Replaced++
+		} else {
+			stats.Inserted++
+		}
+		return nil
+	})
+
+	return stats, err
+}
+
+// ApplyDeletes removes the locations listed in a deletes file.
+func (updater *Updater) ApplyDeletes(file io.Reader) (stats UpdateStats, err error) {
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		id := strings.SplitN(scanner.Text(), "\t", 2)[0]
+		if id == "" {
+			continue
+		}
+
+		if updater.tree.Remove(id) {
+			stats.Deleted++
+		} else {
+			stats.Missing++
+		}
+	}
+
+	return stats, scanner.Err()
+}
+
+// Applied returns the names of the update files applied so far, sorted.
+func (updater *Updater) Applied() []string {
+	names := make([]string, 0, len(updater.applied))
+	for name := range updater.applied {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
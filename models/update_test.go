@@ -0,0 +1,148 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// geonamesRow builds a row in the GeoNames dump format.
+func geonamesRow(id, name, modified string) string {
+	return strings.Join([]string{
+		id, name, name, "", "45.5", "-73.5", "P", "PPL", "CA", "", "10",
+		"", "", "", "1000", "", "", "America/Montreal", modified,
+	}, "\t") + "\n"
+}
+
+func loadRows(t *testing.T, rows ...string) *Trie {
+	tree, err := LoadTrie(strings.NewReader(strings.Join(rows, "")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func names(locations []Location) []string {
+	result := []string{}
+	for _, location := range locations {
+		result = append(result, location.Name)
+	}
+	return result
+}
+
+func TestUpdater_ApplyModifications(t *testing.T) {
+	tests := map[string]struct {
+		row      string
+		stats    UpdateStats
+		expected []string
+	}{
+		"new id is inserted": {
+			geonamesRow("3", "Montebello", "2017-01-02"),
+			UpdateStats{Inserted: 1},
+			[]string{"Montreal", "Montmagny", "Montebello"},
+		},
+		"newer row replaces and renames": {
+			geonamesRow("1", "Montréal", "2017-01-02"),
+			UpdateStats{Replaced: 1},
+			[]string{"Montréal", "Montmagny"},
+		},
+		"stale row is skipped": {
+			geonamesRow("1", "Montréal", "2015-01-01"),
+			UpdateStats{Stale: 1},
+			[]string{"Montreal", "Montmagny"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree := loadRows(t,
+				geonamesRow("1", "Montreal", "2016-01-01"),
+				geonamesRow("2", "Montmagny", "2016-01-01"),
+			)
+			updater := NewUpdater(tree, nil)
+
+			stats, err := updater.ApplyModifications(strings.NewReader(tt.row))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats != tt.stats {
+				t.Errorf("%#v != %#v", stats, tt.stats)
+			}
+
+			if actual := names(tree.FindMatches("mont", 0)); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("%#v != %#v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUpdater_ApplyDeletes(t *testing.T) {
+	tree := loadRows(t,
+		geonamesRow("1", "Montreal", "2016-01-01"),
+		geonamesRow("2", "Montmagny", "2016-01-01"),
+	)
+	updater := NewUpdater(tree, nil)
+
+	stats, err := updater.ApplyDeletes(strings.NewReader("1\tMontreal\tduplicate\n9\tNowhere\t\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (UpdateStats{Deleted: 1, Missing: 1}); stats != expected {
+		t.Errorf("%#v != %#v", stats, expected)
+	}
+
+	if tree.Find("montreal") {
+		t.Errorf("deleted location is still found")
+	}
+	if _, found := tree.Get("1"); found {
+		t.Errorf("deleted location is still returned by ID")
+	}
+	if actual := names(tree.FindMatches("mont", 1)); !reflect.DeepEqual(actual, []string{"Montmagny"}) {
+		t.Errorf("%#v", actual)
+	}
+}
+
+func TestUpdater_ApplyDir(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		// the delete on the 2nd must happen after the modification on the 1st
+		"modifications-2017-01-01.txt": geonamesRow("3", "Montebello", "2017-01-01"),
+		"deletes-2017-01-02.txt":       "3\tMontebello\t\n",
+		"modifications-2017-01-02.txt": geonamesRow("4", "Montcalm", "2017-01-02"),
+		"readme.txt":                   "not an update",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree := loadRows(t, geonamesRow("1", "Montreal", "2016-01-01"))
+	updater := NewUpdater(tree, nil)
+
+	applied, err := updater.ApplyDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"modifications-2017-01-01.txt",
+		"modifications-2017-01-02.txt",
+		"deletes-2017-01-02.txt",
+	}
+	if !reflect.DeepEqual(applied, expected) {
+		t.Errorf("%#v != %#v", applied, expected)
+	}
+	if actual := names(tree.FindMatches("mont", 0)); !reflect.DeepEqual(actual, []string{"Montcalm", "Montreal"}) {
+		t.Errorf("%#v", actual)
+	}
+
+	// files are only applied once
+	if applied, err = updater.ApplyDir(dir); err != nil || len(applied) != 0 {
+		t.Errorf("%#v, %v", applied, err)
+	}
+	if len(updater.Applied()) != 3 {
+		t.Errorf("%#v", updater.Applied())
+	}
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// A Viewport is the part of a map a user is looking at. Unlike a bounding box
+// filter, it only biases ranking: places in view rank first, places just out
+// of view next, and the zoom decides how much prominence counts against being
+// close.
+type Viewport struct {
+	BoundingBox
+	Zoom float64 // web map zoom level, 0 for the whole world
+}
+
+// The highest zoom level accepted, closer than any map tiles go
+const maxZoom = 24
+
+// At this zoom and closer (about a city), prominence doesn't count at all.
+// Zoomed further out, it counts for more, up to all of the score at zoom 0.
+const localZoom = 12
+
+// NewViewport makes a viewport from a box, and a zoom level if there is one.
+// Without one, the zoom that fits the box's width on a 256 pixel tile is used.
+func NewViewport(box BoundingBox, zoom *float64) (*Viewport, error) {
+	viewport := &Viewport{BoundingBox: box}
+	if zoom != nil {
+		if *zoom < 0 || *zoom > maxZoom {
+			return nil, fmt.Errorf("zoom %g isn't between 0 and %d", *zoom, maxZoom)
+		}
+		viewport.Zoom = *zoom
+		return viewport, nil
+	}
+
+	width := box.MaxLong - box.MinLong
+	if box.crossesAntimeridian() {
+		width += 360
+	}
+	viewport.Zoom = math.Min(math.Max(math.Log2(360/math.Max(width, 1e-9)), 0), maxZoom)
+	return viewport, nil
+}
+
+// Distance is how far a point is from the viewport in km, 0 if it's in view.
+// It's measured to the nearest point of the box in latitude and longitude,
+// which is close enough for a soft decay.
+func (viewport *Viewport) Distance(lat, long float64) float64 {
+	if viewport.Contains(lat, long) {
+		return 0
+	}
+
+	nearestLat := math.Min(math.Max(lat, viewport.MinLat), viewport.MaxLat)
+	nearestLong := long
+	if !viewport.Contains(nearestLat, long) {
+		// whichever side is nearer, going either way around
+		if longitudeGap(long, viewport.MinLong) < longitudeGap(long, viewport.MaxLong) {
+			nearestLong = viewport.MinLong
+		} else {
+			nearestLong = viewport.MaxLong
+		}
+	}
+	return HaversineDistance(lat, long, nearestLat, nearestLong)
+}
+
+// Size is half the distance across the viewport in km, corner to corner.
+func (viewport *Viewport) Size() float64 {
+	return HaversineDistance(viewport.MinLat, viewport.MinLong, viewport.MaxLat, viewport.MaxLong) / 2
+}
+
+// ProminenceWeight is how much prominence counts against proximity at the
+// viewport's zoom, from 1 zoomed all the way out to 0 at a city.
+func (viewport *Viewport) ProminenceWeight() float64 {
+	return math.Min(math.Max((localZoom-viewport.Zoom)/localZoom, 0), 1)
+}
+
+// longitudeGap is the difference between two longitudes in degrees, the
+// shorter way around.
+func longitudeGap(a, b float64) float64 {
+	gap := math.Mod(math.Abs(a-b), 360)
+	return math.Min(gap, 360-gap)
+}
+
+// A ViewportScorer scores results by how close they are to a viewport, and how
+// prominent they are, weighted by the viewport's zoom. Places in view are as
+// close as can be, and further out the score halves every half a viewport
+// across, so it falls off faster the closer the map is zoomed.
+type ViewportScorer struct {
+	viewport   *Viewport
+	decay      *Decay
+	prominence PopulationScorer
+}
+
+func NewViewportScorer(viewport *Viewport) *ViewportScorer {
+	return &ViewportScorer{
+		viewport: viewport,
+		decay:    &Decay{Function: DecayExp, ScaleKm: math.Max(viewport.Size(), 1), Decay: 0.5},
+	}
+}
+
+func (scorer *ViewportScorer) Score(location Location) float64 {
+	weight := scorer.viewport.ProminenceWeight()
+	return (1-weight)*scorer.proximity(location) + weight*scorer.prominence.Score(location)
+}
+
+func (scorer *ViewportScorer) proximity(location Location) float64 {
+	return scorer.decay.Score(scorer.viewport.Distance(location.Lat, location.Long))
+}
+
+func (scorer *ViewportScorer) Explain(location Location) Explanation {
+	weight := scorer.viewport.ProminenceWeight()
+	distance := scorer.viewport.Distance(location.Lat, location.Long)
+
+	proximity := Explanation{Value: scorer.proximity(location), Description: fmt.Sprintf("1, in view (weight %g)", 1-weight)}
+	if distance > 0 {
+		proximity.Description = fmt.Sprintf("0.5^(%.0f / %.0f), for %.0f km out of view (weight %g)", distance, scorer.decay.ScaleKm, distance, 1-weight)
+	}
+
+	prominence := Explain(&scorer.prominence, location)
+	prominence.Description += fmt.Sprintf(" (weight %g)", weight)
+
+	return Explanation{
+		Value:       scorer.Score(location),
+		Description: fmt.Sprintf("weighted average of closeness and prominence, at zoom %g:", scorer.viewport.Zoom),
+		Details:     []Explanation{proximity, prominence},
+	}
+}
+
+// MaxScore is 1, for a prominent place in view. Names don't bound either.
+func (scorer *ViewportScorer) MaxScore(minLength int) float64 {
+	return 1.0
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewViewport(t *testing.T) {
+	tests := map[string]struct {
+		box      BoundingBox
+		zoom     *float64
+		expected float64
+		valid    bool
+	}{
+		"whole world":             {BoundingBox{-180, -85, 180, 85}, nil, 0, true},
+		"a city":                  {BoundingBox{-79.7, 43.5, -79, 43.9}, nil, math.Log2(360 / 0.7), true},
+		"across the antimeridian": {BoundingBox{179, -17, -179, -16}, nil, math.Log2(360 / 2.0), true},
+		"given zoom":              {BoundingBox{-79.7, 43.5, -79, 43.9}, ptr(5.0), 5, true},
+		"zoom too far in":         {BoundingBox{-79.7, 43.5, -79, 43.9}, ptr(30.0), 0, false},
+		"negative zoom":           {BoundingBox{-79.7, 43.5, -79, 43.9}, ptr(-1.0), 0, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			viewport, err := NewViewport(tt.box, tt.zoom)
+			if (err == nil) != tt.valid {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && math.Abs(viewport.Zoom-tt.expected) > 1e-9 {
+				t.Errorf("%v != %v", viewport.Zoom, tt.expected)
+			}
+		})
+	}
+}
+
+func TestViewport_Distance(t *testing.T) {
+	toronto, _ := NewViewport(BoundingBox{-79.7, 43.5, -79, 43.9}, nil)
+	fiji, _ := NewViewport(BoundingBox{179, -17, -179, -16}, nil)
+
+	tests := map[string]struct {
+		viewport  *Viewport
+		lat, long float64
+		expected  float64
+	}{
+		"in view":                    {toronto, 43.7, -79.4, 0},
+		"north":                      {toronto, 44.9, -79.4, HaversineDistance(44.9, -79.4, 43.9, -79.4)},
+		"west":                       {toronto, 43.7, -80.7, HaversineDistance(43.7, -80.7, 43.7, -79.7)},
+		"north east":                 {toronto, 44.9, -78, HaversineDistance(44.9, -78, 43.9, -79)},
+		"in view across":             {fiji, -16.5, 179.9, 0},
+		"east, across":               {fiji, -16.5, -178, HaversineDistance(-16.5, -178, -16.5, -179)},
+		"west, across":               {fiji, -16.5, 178, HaversineDistance(-16.5, 178, -16.5, 179)},
+		"nearer the other way round": {fiji, -16.5, -170, HaversineDistance(-16.5, -170, -16.5, -179)},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := tt.viewport.Distance(tt.lat, tt.long); math.Abs(actual-tt.expected) > 1e-9 {
+				t.Errorf("%v != %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestViewportScorer_Score(t *testing.T) {
+	box := BoundingBox{-79.7, 43.5, -79, 43.9} // Toronto
+	inView := Location{Name: "Etobicoke", Lat: 43.65, Long: -79.55, Population: 10000}
+	justOut := Location{Name: "Oakville", Lat: 43.45, Long: -79.68, Population: 10000}
+	farOut := Location{Name: "Chicago", Lat: 41.85, Long: -87.65, Population: 2700000}
+
+	tests := map[string]struct {
+		zoom     float64
+		expected []Location // best first
+	}{
+		"zoomed in, closeness counts":   {12, []Location{inView, justOut, farOut}},
+		"zoomed out, prominence counts": {2, []Location{farOut, inView, justOut}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			viewport, _ := NewViewport(box, &tt.zoom)
+			scorer := NewViewportScorer(viewport)
+
+			for i, location := range tt.expected {
+				if explained := Explain(scorer, location); explained.Value != scorer.Score(location) {
+					t.Errorf("%s: explained %v != %v", location.Name, explained.Value, scorer.Score(location))
+				}
+				if scorer.Score(location) > scorer.MaxScore(0) {
+					t.Errorf("%s: %v > max", location.Name, scorer.Score(location))
+				}
+				if i > 0 && scorer.Score(location) >= scorer.Score(tt.expected[i-1]) {
+					t.Errorf("%s: %v >= %s: %v", location.Name, scorer.Score(location), tt.expected[i-1].Name, scorer.Score(tt.expected[i-1]))
+				}
+			}
+		})
+	}
+}
+
+func ptr(value float64) *float64 {
+	return &value
+}
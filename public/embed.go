@@ -0,0 +1,7 @@
+// Package public embeds the static assets served by the server.
+package public
+
+import "embed"
+
+//go:embed *.html
+var Files embed.FS